@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -172,7 +173,7 @@ var _ = Describe("BMMC with HTTP Server", func() {
 			It("sync buffers", func() {
 				// Add a message in first node.
 				// Both nodes must have this message.
-				Expect(bmmc1.AddMessage("my-first-message", "my-callback")).To(Succeed())
+				Expect(bmmc1.AddMessage(context.Background(), "my-first-message", "my-callback")).To(Succeed())
 
 				Eventually(getBufferFn(bmmc1)).Should(ConsistOf(
 					[]string{
@@ -187,7 +188,7 @@ var _ = Describe("BMMC with HTTP Server", func() {
 
 				// Add a message in second node.
 				// Both nodes must have this message.
-				Expect(bmmc2.AddMessage("my-second-message", "my-callback")).To(Succeed())
+				Expect(bmmc2.AddMessage(context.Background(), "my-second-message", "my-callback")).To(Succeed())
 
 				Eventually(getBufferFn(bmmc1)).Should(ConsistOf(
 					[]string{
@@ -246,7 +247,7 @@ var _ = Describe("BMMC with HTTP Server", func() {
 			It("sync buffers", func() {
 				// Add a message in first node.
 				// Both nodes must have this message.
-				Expect(bmmc1.AddMessage("first-message", "my-callback")).To(Succeed())
+				Expect(bmmc1.AddMessage(context.Background(), "first-message", "my-callback")).To(Succeed())
 
 				Eventually(getBufferFn(bmmc1)).Should(ConsistOf(
 					[]string{
@@ -261,7 +262,7 @@ var _ = Describe("BMMC with HTTP Server", func() {
 
 				// Add a message in second node.
 				// Both nodes must have this message.
-				Expect(bmmc2.AddMessage("second-message", "my-callback")).To(Succeed())
+				Expect(bmmc2.AddMessage(context.Background(), "second-message", "my-callback")).To(Succeed())
 
 				Eventually(getBufferFn(bmmc1)).Should(ConsistOf(
 					[]string{
@@ -279,6 +280,64 @@ var _ = Describe("BMMC with HTTP Server", func() {
 		})
 	})
 
+	When("system uses synchronous exchange", func() {
+		var (
+			bmmc1, bmmc2       *bmmc.BMMC
+			stopSrv1, stopSrv2 chan struct{}
+		)
+
+		BeforeEach(func() {
+			host1, err := NewPeer("localhost", suggestPort(), &http.Client{})
+			Expect(err).ToNot(HaveOccurred())
+
+			host2, err := NewPeer("localhost", suggestPort(), &http.Client{})
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, h := range []Peer{host1, host2} {
+				b, err := bmmc.New(&bmmc.Config{
+					Host:       h,
+					BufferSize: 32,
+					Logger:     bmmcLog,
+					Exchange:   bmmc.GossipExchange,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				if bmmc1 == nil {
+					bmmc1 = b
+				} else {
+					bmmc2 = b
+				}
+			}
+
+			stopSrv1 = make(chan struct{})
+			stopSrv2 = make(chan struct{})
+
+			Expect(NewServer(bmmc1, host1.Addr, host1.Port, srvLog).Start(stopSrv1, srvLog)).To(Succeed())
+			Expect(NewServer(bmmc2, host2.Addr, host2.Port, srvLog).Start(stopSrv2, srvLog)).To(Succeed())
+
+			Expect(bmmc1.Start()).To(Succeed())
+			Expect(bmmc2.Start()).To(Succeed())
+
+			Expect(bmmc1.AddPeer(host2.String())).To(Succeed())
+		})
+
+		AfterEach(func() {
+			bmmc1.Stop()
+			bmmc2.Stop()
+
+			close(stopSrv1)
+			close(stopSrv2)
+
+			bmmc1, bmmc2 = nil, nil
+		})
+
+		It("sync buffers", func() {
+			Expect(bmmc1.AddMessage(context.Background(), "exchanged-message", bmmc.NOCALLBACK)).To(Succeed())
+
+			Eventually(getBufferFn(bmmc2)).Should(ConsistOf("exchanged-message"))
+		})
+	})
+
 	When("system has ten nodes", func() {
 		const nodesLen = 10
 
@@ -329,7 +388,7 @@ var _ = Describe("BMMC with HTTP Server", func() {
 
 				// select a random node to send the new message
 				randomNode := rand.Intn(nodesLen) //nolint: gosec
-				Expect(bmmcs[randomNode].AddMessage(msg, bmmc.NOCALLBACK)).To(Succeed())
+				Expect(bmmcs[randomNode].AddMessage(context.Background(), msg, bmmc.NOCALLBACK)).To(Succeed())
 			})
 
 			It("sync all nodes with the new message", func() {
@@ -351,7 +410,7 @@ var _ = Describe("BMMC with HTTP Server", func() {
 					msg := fmt.Sprint("new-message-$d", rand.Int31()) //nolint: gosec
 					expectedBuf = append(expectedBuf, msg)
 
-					Expect(bmmcs[randomNode].AddMessage(msg, bmmc.NOCALLBACK)).To(Succeed())
+					Expect(bmmcs[randomNode].AddMessage(context.Background(), msg, bmmc.NOCALLBACK)).To(Succeed())
 				}
 			})
 
@@ -375,7 +434,7 @@ var _ = Describe("BMMC with HTTP Server", func() {
 					msg := fmt.Sprintf("new-message-%d", rand.Int31()) //nolint: gosec
 					expectedBuf = append(expectedBuf, msg)
 
-					Expect(bmmcs[randomNode].AddMessage(msg, bmmc.NOCALLBACK)).To(Succeed())
+					Expect(bmmcs[randomNode].AddMessage(context.Background(), msg, bmmc.NOCALLBACK)).To(Succeed())
 
 				}
 			})
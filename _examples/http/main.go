@@ -18,6 +18,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -45,13 +46,15 @@ func main() { //nolint: funlen, cyclop, gocyclo, gocognit
 	// }
 
 	callbacks := map[string]func(any, *slog.Logger) error{
-		"first-callback": func(msg any, _ *slog.Logger) error {
-			fmt.Printf("*** First callback called for message `%s`. ***\n", msg)
+		"first-callback": func(data any, _ *slog.Logger) error {
+			d, _ := data.(bmmc.Delivery)
+			fmt.Printf("*** First callback called for message `%v` (%d hops, %s). ***\n", d.Msg, d.Hops, d.Latency)
 
 			return nil
 		},
-		"second-callback": func(msg any, _ *slog.Logger) error {
-			fmt.Printf("### Second callback called for message `%s`. ###\n", msg)
+		"second-callback": func(data any, _ *slog.Logger) error {
+			d, _ := data.(bmmc.Delivery)
+			fmt.Printf("### Second callback called for message `%v` (%d hops, %s). ###\n", d.Msg, d.Hops, d.Latency)
 
 			return nil
 		},
@@ -161,7 +164,7 @@ func main() { //nolint: funlen, cyclop, gocyclo, gocognit
 			message := args[1]
 			cbType := args[2]
 
-			err := node.AddMessage(message, cbType)
+			err := node.AddMessage(context.Background(), message, cbType)
 			if err != nil {
 				fmt.Println("Error at adding message in buffer:", err)
 			}
@@ -18,12 +18,16 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"strings"
 )
 
+var errUnexpectedStatus = errors.New("unexpected status")
+
 // Peer decorates a Peer over HTTP.
 type Peer struct {
 	Addr       string
@@ -59,6 +63,24 @@ func (p Peer) Send(msg []byte, route string, peerToSend string) error {
 	return resp.Body.Close() //nolint: wrapcheck
 }
 
+// Request sends a request and returns the response body.
+func (p Peer) Request(msg []byte, route string, peerToSend string) ([]byte, error) {
+	addr, port := decodePeer(peerToSend)
+
+	resp, err := p.httpClient.Post(httpPath(addr, port, route), "json", bytes.NewBuffer(msg)) //nolint: noctx
+	if err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	defer resp.Body.Close() //nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", errUnexpectedStatus, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body) //nolint: wrapcheck
+}
+
 // NewPeer creates a Peer.
 func NewPeer(addr, port string, httpClient *http.Client) (Peer, error) {
 	if err := addrValidator()(addr); err != nil {
@@ -38,37 +38,27 @@ func NewServer(b *bmmc.BMMC, addr, port string, log *slog.Logger) *Server {
 	return &Server{
 		&http.Server{
 			Addr: fmt.Sprintf("%s:%s", addr, port),
-			Handler: http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
-				switch path := r.URL.Path; path {
-				case bmmc.GossipRoute:
-					body, err := io.ReadAll(r.Body)
-					if err != nil {
-						log.Error("unable to read gossip message body", err)
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					log.Error("unable to read message body", "err", err, "route", r.URL.Path)
+					w.WriteHeader(http.StatusBadRequest)
 
-						return
-					}
-
-					b.GossipHandler(body)
-
-				case bmmc.SolicitationRoute:
-					body, err := io.ReadAll(r.Body)
-					if err != nil {
-						log.Error("unable to read solicitation message body", err)
-
-						return
-					}
+					return
+				}
 
-					b.SolicitationHandler(body)
+				ctx := bmmc.ContextWithSource(r.Context(), r.RemoteAddr)
 
-				case bmmc.SynchronizationRoute:
-					body, err := io.ReadAll(r.Body)
-					if err != nil {
-						log.Error("unable to read synchronization message body", err)
+				resp, err := b.Handle(ctx, r.URL.Path, body)
+				if err != nil {
+					log.Error("unable to handle message", "err", err, "route", r.URL.Path)
+					w.WriteHeader(statusCode(err))
 
-						return
-					}
+					return
+				}
 
-					b.SynchronizationHandler(body)
+				if len(resp) > 0 {
+					w.Write(resp) //nolint: errcheck
 				}
 			}),
 			ReadHeaderTimeout: 30 * time.Second, //nolint: gomnd
@@ -76,6 +66,24 @@ func NewServer(b *bmmc.BMMC, addr, port string, log *slog.Logger) *Server {
 	}
 }
 
+// statusCode returns the http status code for an error returned by the bmmc handler.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, bmmc.ErrUnknownRoute):
+		return http.StatusNotFound
+	case errors.Is(err, bmmc.ErrDecode):
+		return http.StatusBadRequest
+	case errors.Is(err, bmmc.ErrLimitExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, bmmc.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, bmmc.ErrRejectedSender):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // Start starts the http server.
 func (s *Server) Start(stop <-chan struct{}, log *slog.Logger) error { //nolint: unparam
 	errChan := make(chan error)
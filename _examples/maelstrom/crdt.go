@@ -0,0 +1,145 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/crdt"
+)
+
+const (
+	// crdtName is the name of the CRDT used by the workloads
+	crdtName = "workload"
+
+	valueBodyKey   = "value"
+	deltaBodyKey   = "delta"
+	elementBodyKey = "element"
+)
+
+// handleInit adds all other nodes as peers, since the CRDT workloads don't send a topology.
+func handleInit(r *crdt.Replica, n *maelstrom.Node) {
+	n.Handle("init", func(maelstrom.Message) error {
+		for _, id := range n.NodeIDs() {
+			if id == n.ID() {
+				continue
+			}
+
+			if err := r.BMMC().AddPeer(id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// handleGCounter handles the messages of the g-counter workload.
+func handleGCounter(r *crdt.Replica, n *maelstrom.Node, logger *slog.Logger) {
+	handleInit(r, n)
+
+	n.Handle("add", func(msg maelstrom.Message) error {
+		var body struct {
+			Delta uint64 `json:"delta"`
+		}
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		counter, err := r.GCounter(crdtName)
+		if err != nil {
+			return err
+		}
+
+		if err = counter.Increment(context.Background(), body.Delta); err != nil {
+			logger.Error("cannot increment counter", "err", err, deltaBodyKey, body.Delta)
+
+			return err
+		}
+
+		return n.Reply(msg, map[string]any{
+			typeBodyKey: "add_ok",
+		})
+	})
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		counter, err := r.GCounter(crdtName)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, map[string]any{
+			typeBodyKey:  "read_ok",
+			valueBodyKey: counter.Value(),
+		})
+	})
+}
+
+// handleGSet handles the messages of the g-set workload.
+// The elements are kept as JSON, so they are read with their original type.
+func handleGSet(r *crdt.Replica, n *maelstrom.Node, logger *slog.Logger) {
+	handleInit(r, n)
+
+	n.Handle("add", func(msg maelstrom.Message) error {
+		var body struct {
+			Element json.RawMessage `json:"element"`
+		}
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		set, err := r.GSet(crdtName)
+		if err != nil {
+			return err
+		}
+
+		if err = set.Add(context.Background(), string(body.Element)); err != nil {
+			logger.Error("cannot add element to set", "err", err, elementBodyKey, string(body.Element))
+
+			return err
+		}
+
+		return n.Reply(msg, map[string]any{
+			typeBodyKey: "add_ok",
+		})
+	})
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		set, err := r.GSet(crdtName)
+		if err != nil {
+			return err
+		}
+
+		elements := set.Elements()
+		value := make([]json.RawMessage, len(elements))
+
+		for i, element := range elements {
+			value[i] = json.RawMessage(element)
+		}
+
+		return n.Reply(msg, map[string]any{
+			typeBodyKey:  "read_ok",
+			valueBodyKey: value,
+		})
+	})
+}
@@ -19,6 +19,8 @@ package main
 // https://aods.cryingpotato.com/
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
@@ -26,8 +28,11 @@ import (
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+	"github.com/rstefan1/bimodal-multicast/pkg/crdt"
 )
 
+var errUnknownWorkload = errors.New("unknown workload")
+
 func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
@@ -43,7 +48,9 @@ func main() {
 		Logger:        logger.With("component", "bmmc"),
 	}
 
-	bmmcNode, err := bmmc.New(cfg)
+	// the workload is selected with the WORKLOAD environment variable,
+	// since maelstrom doesn't pass arguments to the nodes
+	bmmcNode, handleWorkload, err := newWorkload(os.Getenv("WORKLOAD"), cfg, host.Node, logger)
 	if err != nil {
 		log.Fatal(err)
 
@@ -56,5 +63,31 @@ func main() {
 		return
 	}
 
-	createAndRunServer(bmmcNode, host.Node, logger)
+	createAndRunServer(bmmcNode, host.Node, logger, handleWorkload)
+}
+
+// newWorkload creates the bimodal multicast instance and the handlers of the given workload.
+func newWorkload(workload string, cfg *bmmc.Config, n *maelstrom.Node, logger *slog.Logger) (*bmmc.BMMC, func(), error) {
+	switch workload {
+	case "", "broadcast":
+		b, err := bmmc.New(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return b, func() { handleBroadcast(b, n, logger) }, nil
+	case "g-counter", "g-set":
+		r, err := crdt.New(crdt.Config{BMMC: cfg})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if workload == "g-counter" {
+			return r.BMMC(), func() { handleGCounter(r, n, logger) }, nil
+		}
+
+		return r.BMMC(), func() { handleGSet(r, n, logger) }, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", errUnknownWorkload, workload)
+	}
 }
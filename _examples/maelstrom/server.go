@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log/slog"
@@ -33,43 +34,34 @@ const (
 
 var errCannotCast = errors.New("cannot cast")
 
-func createAndRunServer(b *bmmc.BMMC, n *maelstrom.Node, logger *slog.Logger) { //nolint: funlen, gocyclo, cyclop
-	n.Handle(bmmc.GossipRoute, func(msg maelstrom.Message) error {
-		var body map[string]string
+func createAndRunServer(b *bmmc.BMMC, n *maelstrom.Node, logger *slog.Logger, handleWorkload func()) {
+	for _, route := range []string{bmmc.GossipRoute, bmmc.SolicitationRoute, bmmc.SynchronizationRoute, bmmc.AckRoute} {
+		n.Handle(route, func(msg maelstrom.Message) error {
+			var body map[string]string
 
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		b.GossipHandler([]byte(body[messageBodyKey]))
-
-		return nil
-	})
-
-	n.Handle(bmmc.SolicitationRoute, func(msg maelstrom.Message) error {
-		var body map[string]string
-
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		b.SolicitationHandler([]byte(body[messageBodyKey]))
-
-		return nil
-	})
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
 
-	n.Handle(bmmc.SynchronizationRoute, func(msg maelstrom.Message) error {
-		var body map[string]string
+			// protocol messages are sent without waiting for a reply,
+			// so errors are only logged
+			if _, err := b.Handle(context.Background(), body[typeBodyKey], []byte(body[messageBodyKey])); err != nil {
+				logger.Error("cannot handle bmmc message", "err", err, "route", body[typeBodyKey])
+			}
 
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
+			return nil
+		})
+	}
 
-		b.SynchronizationHandler([]byte(body[messageBodyKey]))
+	handleWorkload()
 
-		return nil
-	})
+	if err := n.Run(); err != nil {
+		logger.Error("cannot start maelstrom server", "err", err)
+	}
+}
 
+// handleBroadcast handles the messages of the broadcast workload.
+func handleBroadcast(b *bmmc.BMMC, n *maelstrom.Node, logger *slog.Logger) { //nolint: funlen, gocyclo, cyclop
 	n.Handle("broadcast", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as a loosely-typed map.
 		var body map[string]any
@@ -77,7 +69,7 @@ func createAndRunServer(b *bmmc.BMMC, n *maelstrom.Node, logger *slog.Logger) {
 			return err
 		}
 
-		if err := b.AddMessage(body[messageBodyKey], bmmc.NOCALLBACK); err != nil {
+		if err := b.AddMessage(context.Background(), body[messageBodyKey], bmmc.NOCALLBACK); err != nil {
 			logger.Error("cannot add message to bmmc", "err", err, "msg", body[messageBodyKey])
 		}
 
@@ -134,8 +126,4 @@ func createAndRunServer(b *bmmc.BMMC, n *maelstrom.Node, logger *slog.Logger) {
 			typeBodyKey: "topology_ok",
 		})
 	})
-
-	if err := n.Run(); err != nil {
-		logger.Error("cannot start maelstrom server", "err", err)
-	}
 }
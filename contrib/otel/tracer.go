@@ -0,0 +1,97 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+)
+
+// instrumentationName is the name of the tracer used by the protocol.
+const instrumentationName = "github.com/rstefan1/bimodal-multicast"
+
+// Tracer is a bmmc.Tracer backed by OpenTelemetry.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracer creates a Tracer with the given tracer provider and propagator.
+// When nil, the global tracer provider and propagator are used.
+func NewTracer(provider trace.TracerProvider, propagator propagation.TextMapPropagator) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	if propagator == nil {
+		propagator = otel.GetTextMapPropagator()
+	}
+
+	return &Tracer{
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagator,
+	}
+}
+
+// Start starts a span as child of the span from the given context.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, bmmc.Span) { //nolint: ireturn
+	ctx, span := t.tracer.Start(ctx, name)
+
+	return ctx, &Span{span: span}
+}
+
+// Inject writes the span context from the given context into headers.
+func (t *Tracer) Inject(ctx context.Context, headers map[string]string) {
+	t.propagator.Inject(ctx, propagation.MapCarrier(headers))
+}
+
+// Extract returns a context with the span context from headers.
+func (t *Tracer) Extract(ctx context.Context, headers map[string]string) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+
+	return t.propagator.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// Span is a bmmc.Span backed by an OpenTelemetry span.
+type Span struct {
+	span trace.Span
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	s.span.SetAttributes(attribute.String(key, value))
+}
+
+// RecordError records the given error in the span.
+func (s *Span) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End ends the span.
+func (s *Span) End() {
+	s.span.End()
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otel
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+var _ bmmc.Tracer = &Tracer{}
+
+var _ = Describe("Tracer", func() {
+	var (
+		recorder *tracetest.SpanRecorder
+		tracer   *Tracer
+	)
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		tracer = NewTracer(provider, propagation.TraceContext{})
+	})
+
+	It("propagates the span context through headers", func() {
+		ctx, span := tracer.Start(context.Background(), "root")
+		span.End()
+
+		headers := map[string]string{}
+		tracer.Inject(ctx, headers)
+		Expect(headers).To(HaveKey("traceparent"))
+
+		_, child := tracer.Start(tracer.Extract(context.Background(), headers), "child")
+		child.End()
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[1].Parent().SpanID()).To(Equal(spans[0].SpanContext().SpanID()))
+		Expect(spans[1].SpanContext().TraceID()).To(Equal(spans[0].SpanContext().TraceID()))
+	})
+
+	It("traces messages across hosts", func() {
+		network := memory.NewNetwork()
+		nodes := make([]*bmmc.BMMC, 2)
+
+		for i, host := range []string{"n0", "n1"} {
+			b, err := bmmc.New(&bmmc.Config{
+				Host:          network.Peer(host),
+				BufferSize:    32,
+				RoundDuration: 10 * time.Millisecond,
+				Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+				Tracer:        tracer,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			network.Register(host, b.Handle)
+			Expect(b.Start()).To(Succeed())
+			DeferCleanup(b.Stop)
+
+			nodes[i] = b
+		}
+
+		Expect(nodes[0].AddPeer("n1")).To(Succeed())
+		Expect(nodes[1].AddPeer("n0")).To(Succeed())
+
+		ctx, root := tracer.Start(context.Background(), "request")
+		Expect(nodes[0].AddMessage(ctx, "message", bmmc.NOCALLBACK)).To(Succeed())
+		root.End()
+
+		Eventually(nodes[1].GetMessages).Should(ConsistOf("message"))
+
+		elementID := func(span sdktrace.ReadOnlySpan) string {
+			for _, kv := range span.Attributes() {
+				if kv.Key == "bmmc.element.id" {
+					return kv.Value.AsString()
+				}
+			}
+
+			return ""
+		}
+
+		findSpan := func(name, id string) sdktrace.ReadOnlySpan {
+			for _, span := range recorder.Ended() {
+				if span.Name() == name && (id == "" || elementID(span) == id) {
+					return span
+				}
+			}
+
+			return nil
+		}
+
+		add := findSpan(bmmc.SpanAddMessage, "")
+		Expect(add).ToNot(BeNil())
+
+		var sync sdktrace.ReadOnlySpan
+
+		Eventually(func() sdktrace.ReadOnlySpan {
+			sync = findSpan(bmmc.SpanSynchronization, elementID(add))
+
+			return sync
+		}).ShouldNot(BeNil())
+
+		Expect(sync.SpanContext().TraceID()).To(Equal(add.SpanContext().TraceID()))
+		Expect(sync.Parent().SpanID()).To(Equal(add.SpanContext().SpanID()))
+	})
+})
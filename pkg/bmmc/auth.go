@@ -0,0 +1,256 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxMessageAge = 30 * time.Second
+
+	nonceSize = 16
+
+	sealErrFmt       = "error at authenticating %s message: %w"
+	openDecodeErrFmt = "error at decoding %s envelope: %w: %w"
+	openAuthErrFmt   = "error at authenticating %s envelope: %w: %w"
+)
+
+var (
+	// ErrUnauthenticated is returned by Handle when the message cannot be authenticated
+	// (e.g. unknown key, invalid MAC, expired or replayed message).
+	ErrUnauthenticated = errors.New("unauthenticated message")
+
+	errMissingAuthKey = errors.New("auth key ID must be one of the auth keys")
+	errAuthDisabled   = errors.New("protocol messages are not authenticated")
+	errUnknownAuthKey = errors.New("unknown auth key")
+	errInvalidMAC     = errors.New("invalid MAC")
+	errExpiredMessage = errors.New("message is too old or from the future")
+	errReplayedNonce  = errors.New("replayed nonce")
+)
+
+// Envelope is a protocol message authenticated with a shared cluster secret.
+type Envelope struct {
+	KeyID     string `json:"keyId"`
+	Timestamp int64  `json:"timestamp"`
+	Nonce     string `json:"nonce"`
+	Payload   []byte `json:"payload"`
+	MAC       []byte `json:"mac"`
+}
+
+// authenticator authenticates protocol messages with HMAC-SHA256.
+type authenticator struct {
+	keys   map[string][]byte
+	keyID  string
+	maxAge time.Duration
+	// nonces contains the nonces of accepted messages and their expiration time
+	nonces    map[string]time.Time
+	lastPrune time.Time
+	mux       *sync.Mutex
+}
+
+// newAuthenticator creates an authenticator. It returns nil if there are no keys.
+func newAuthenticator(keys map[string][]byte, keyID string, maxAge time.Duration) *authenticator {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	a := &authenticator{
+		maxAge:    maxAge,
+		nonces:    map[string]time.Time{},
+		lastPrune: time.Now(),
+		mux:       &sync.Mutex{},
+	}
+
+	a.setKeys(keys, keyID)
+
+	return a
+}
+
+// setKeys replaces the keys of the authenticator.
+func (a *authenticator) setKeys(keys map[string][]byte, keyID string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.keys = map[string][]byte{}
+
+	for id, key := range keys {
+		a.keys[id] = key
+	}
+
+	a.keyID = keyID
+}
+
+// computeMAC returns the MAC of the given envelope, sent on the given route.
+func computeMAC(key []byte, route string, env Envelope) []byte {
+	h := hmac.New(sha256.New, key)
+
+	for _, field := range []string{route, env.KeyID, strconv.FormatInt(env.Timestamp, 10), env.Nonce} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+
+	h.Write(env.Payload)
+
+	return h.Sum(nil)
+}
+
+// seal wraps the given payload in an authenticated envelope.
+func (a *authenticator) seal(route string, payload []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf(sealErrFmt, route, err)
+	}
+
+	a.mux.Lock()
+	keyID, key := a.keyID, a.keys[a.keyID]
+	a.mux.Unlock()
+
+	env := Envelope{
+		KeyID:     keyID,
+		Timestamp: time.Now().UnixNano(),
+		Nonce:     hex.EncodeToString(nonce),
+		Payload:   payload,
+	}
+	env.MAC = computeMAC(key, route, env)
+
+	sealed, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf(sealErrFmt, route, err)
+	}
+
+	return sealed, nil
+}
+
+// open authenticates the given envelope and returns its payload.
+func (a *authenticator) open(route string, body []byte) ([]byte, error) {
+	var env Envelope
+
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf(openDecodeErrFmt, route, ErrDecode, err)
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	key, ok := a.keys[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf(openAuthErrFmt, route, ErrUnauthenticated, errUnknownAuthKey)
+	}
+
+	if !hmac.Equal(env.MAC, computeMAC(key, route, env)) {
+		return nil, fmt.Errorf(openAuthErrFmt, route, ErrUnauthenticated, errInvalidMAC)
+	}
+
+	now := time.Now()
+
+	age := now.Sub(time.Unix(0, env.Timestamp))
+	if age > a.maxAge || age < -a.maxAge {
+		return nil, fmt.Errorf(openAuthErrFmt, route, ErrUnauthenticated, errExpiredMessage)
+	}
+
+	a.pruneNonces(now)
+
+	if _, replayed := a.nonces[env.Nonce]; replayed {
+		return nil, fmt.Errorf(openAuthErrFmt, route, ErrUnauthenticated, errReplayedNonce)
+	}
+
+	// a nonce can't be replayed after its message expires
+	a.nonces[env.Nonce] = time.Unix(0, env.Timestamp).Add(a.maxAge)
+
+	return env.Payload, nil
+}
+
+// pruneNonces removes the expired nonces.
+// Important! Whoever calls this function must LOCK the authenticator.
+func (a *authenticator) pruneNonces(now time.Time) {
+	if now.Sub(a.lastPrune) < a.maxAge {
+		return
+	}
+
+	for nonce, expiration := range a.nonces {
+		if now.After(expiration) {
+			delete(a.nonces, nonce)
+		}
+	}
+
+	a.lastPrune = now
+}
+
+// seal wraps the given payload in an authenticated envelope.
+// It returns the payload if messages are not authenticated.
+func (b *BMMC) seal(route string, payload []byte) ([]byte, error) {
+	if b.auth == nil {
+		return payload, nil
+	}
+
+	return b.auth.seal(b.authRoute(route), payload)
+}
+
+// open authenticates the given message and returns its payload.
+// It returns the message if messages are not authenticated.
+func (b *BMMC) open(route string, body []byte) ([]byte, error) {
+	if b.auth == nil {
+		return body, nil
+	}
+
+	payload, err := b.auth.open(b.authRoute(route), body)
+	if err != nil {
+		b.config.Logger.Error("cannot authenticate message", "err", err)
+
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// authRoute returns the route authenticated by the envelope of a message sent on
+// the given protocol route. The routes of the messages of a topic contain the
+// topic, so they cannot be replayed on other topics or on the control instance.
+func (b *BMMC) authRoute(route string) string {
+	if host, ok := b.config.Host.(routedHost); ok {
+		return host.route(route)
+	}
+
+	return route
+}
+
+// RotateAuthKeys replaces the keys used to authenticate protocol messages.
+// Keep the previous key in the given keys until all nodes use the new key ID.
+func (b *BMMC) RotateAuthKeys(keys map[string][]byte, keyID string) error {
+	if _, ok := keys[keyID]; !ok {
+		return errMissingAuthKey
+	}
+
+	if b.auth == nil {
+		return errAuthDisabled
+	}
+
+	b.auth.setKeys(keys, keyID)
+
+	return nil
+}
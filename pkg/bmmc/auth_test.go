@@ -0,0 +1,124 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authenticated messages", func() {
+	var (
+		nodes []*BMMC
+		keys  map[string][]byte
+	)
+
+	BeforeEach(func() {
+		keys = map[string][]byte{
+			"key-1": []byte("first-cluster-secret"),
+			"key-2": []byte("second-cluster-secret"),
+		}
+
+		nodes = newTestCluster(3, func(cfg *Config) {
+			cfg.AuthKeys = keys
+			cfg.AuthKeyID = "key-1"
+		})
+	})
+
+	It("returns error when auth key ID is not one of the auth keys", func() {
+		_, err := New(&Config{
+			Host:       &fakeHost{},
+			BufferSize: 25,
+			AuthKeys:   keys,
+			AuthKeyID:  "key-3",
+		})
+		Expect(err).To(MatchError(errMissingAuthKey))
+	})
+
+	It("synchronizes authenticated messages", func() {
+		Expect(nodes[0].AddMessage(context.Background(), "authenticated-message", NOCALLBACK)).To(Succeed())
+
+		for _, b := range nodes {
+			Eventually(b.GetMessages).Should(ConsistOf("authenticated-message"))
+		}
+	})
+
+	It("synchronizes messages during key rotation", func() {
+		Expect(nodes[1].RotateAuthKeys(keys, "key-2")).To(Succeed())
+
+		Expect(nodes[0].AddMessage(context.Background(), "first-message", NOCALLBACK)).To(Succeed())
+		Expect(nodes[1].AddMessage(context.Background(), "second-message", NOCALLBACK)).To(Succeed())
+
+		for _, b := range nodes {
+			Eventually(b.GetMessages).Should(ConsistOf("first-message", "second-message"))
+		}
+	})
+
+	It("rejects messages without envelope", func() {
+		_, err := nodes[0].Handle(context.Background(), GossipRoute, []byte(`{"host":"n1","digest":["id"]}`))
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+
+	It("rejects messages authenticated with unknown keys", func() {
+		a := newAuthenticator(map[string][]byte{"key-3": []byte("unknown-secret")}, "key-3", time.Minute)
+
+		body, err := a.seal(GossipRoute, []byte(`{"host":"n1","digest":["id"]}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = nodes[0].Handle(context.Background(), GossipRoute, body)
+		Expect(err).To(MatchError(errUnknownAuthKey))
+	})
+
+	It("rejects messages sent on another route", func() {
+		body, err := nodes[1].seal(SolicitationRoute, []byte(`{"host":"n1","digest":["id"]}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = nodes[0].Handle(context.Background(), GossipRoute, body)
+		Expect(err).To(MatchError(errInvalidMAC))
+	})
+
+	It("rejects replayed messages", func() {
+		body, err := nodes[1].seal(GossipRoute, []byte(`{"host":"n1","digest":[]}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = nodes[0].Handle(context.Background(), GossipRoute, body)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = nodes[0].Handle(context.Background(), GossipRoute, body)
+		Expect(err).To(MatchError(errReplayedNonce))
+	})
+
+	It("rejects expired messages", func() {
+		env := Envelope{
+			KeyID:     "key-1",
+			Timestamp: time.Now().Add(-time.Hour).UnixNano(),
+			Nonce:     "nonce",
+			Payload:   []byte(`{"host":"n1","digest":[]}`),
+		}
+		env.MAC = computeMAC(keys["key-1"], GossipRoute, env)
+
+		body, err := json.Marshal(env)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = nodes[0].Handle(context.Background(), GossipRoute, body)
+		Expect(err).To(MatchError(errExpiredMessage))
+	})
+})
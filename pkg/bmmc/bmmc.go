@@ -17,6 +17,8 @@ limitations under the License.
 package bmmc
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"maps"
@@ -24,6 +26,7 @@ import (
 	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
 	"github.com/rstefan1/bimodal-multicast/pkg/internal/callback"
 	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/ratelimit"
 )
 
 const (
@@ -48,6 +51,34 @@ type BMMC struct {
 	gossipRound *GossipRound
 	// callbacks registry
 	callbacksRegistry *callback.Registry
+	// protocol statistics
+	stats *stats
+	// authenticator for protocol messages
+	auth *authenticator
+	// ciphers for user messages
+	ciphers *cipherSet
+	// rate limiter for synchronization messages, per peer
+	syncLimiter *ratelimit.Limiter
+	// recent send errors
+	sendErrors *sendErrorLog
+	// messages being sent
+	outbound *outboundTracker
+	// hosts known to have each element
+	coverageTracker *coverageTracker
+	// sequence numbers of the messages originated by the host
+	sequencer *sequencer
+	// received messages held back by the delivery order
+	holdBack *holdBackQueue
+	// messages of ordered callbacks held back until they are stable
+	totalOrder *totalOrderQueue
+	// messages received by all members
+	stability *stabilityTracker
+	// broadcast messages waiting for acknowledgements
+	acks *ackTracker
+	// messages retracted by tombstones
+	retractions *retractionTracker
+	// older versions of keyed messages removed from buffer
+	superseded *recentIDs
 	// stop channel
 	stop chan struct{}
 }
@@ -64,6 +95,11 @@ func New(cfg *Config) (*BMMC, error) {
 
 	cfg.Logger = cfg.Logger.With("host", cfg.Host.String())
 
+	ciphers, err := newCipherSet(cfg.EncryptionKeys, cfg.EncryptionKeyID)
+	if err != nil {
+		return nil, err
+	}
+
 	// set callbacks
 	callbacksRegistry, err := callback.NewRegistry(cfg.Callbacks)
 	if err != nil {
@@ -78,12 +114,37 @@ func New(cfg *Config) (*BMMC, error) {
 		messageBuffer:     buffer.NewBuffer(cfg.BufferSize),
 		gossipRound:       NewGossipRound(),
 		callbacksRegistry: callbacksRegistry,
+		stats:             &stats{},
+		auth:              newAuthenticator(cfg.AuthKeys, cfg.AuthKeyID, cfg.MaxMessageAge),
+		ciphers:           ciphers,
+		sendErrors:        newSendErrorLog(),
+		outbound:          newOutboundTracker(),
+		coverageTracker:   newCoverageTracker(),
+		sequencer:         newSequencer(),
+		stability:         newStabilityTracker(cfg.StabilityTimeout),
+		acks:              newAckTracker(),
+		retractions:       newRetractionTracker(cfg.RetractionTTL),
+		superseded:        newRecentIDs(cfg.SupersededTTL),
+	}
+
+	if cfg.Delivery != UnorderedDelivery {
+		b.holdBack = newHoldBackQueue(cfg.Delivery == CausalDelivery, cfg.HoldBackLimit, cfg.HoldBackTimeout)
+	}
+
+	if len(cfg.OrderedCallbacks) > 0 {
+		b.totalOrder = newTotalOrderQueue(cfg.HoldBackTimeout, cfg.SilenceTimeout)
+		maps.Copy(b.callbacksRegistry.Callbacks, cfg.OrderedCallbacks)
+	}
+
+	if cfg.SyncRateLimit > 0 {
+		b.syncLimiter = ratelimit.NewLimiter(cfg.SyncRateLimit, cfg.SyncBurst)
 	}
 
 	// add internal callbacks
 	internalCallbacks := map[string]func(any, *slog.Logger) error{
 		callback.ADDPEER:    callback.AddPeerCallback,
 		callback.REMOVEPEER: callback.RemovePeerCallback,
+		callback.RETRACT:    b.retractCallback,
 	}
 	maps.Copy(b.callbacksRegistry.Callbacks, internalCallbacks) // maps.Copy(dst, src)
 
@@ -107,65 +168,237 @@ func (b *BMMC) Stop() {
 	close(b.stop)
 }
 
-// AddMessage adds new message in messages buffer.
-func (b *BMMC) AddMessage(msg any, callbackType string) error {
-	m, err := buffer.NewElement(msg, callbackType, false)
+// newElement creates a new buffer element originated by the host.
+func (b *BMMC) newElement(
+	msg any, callbackType string, internal bool, headers map[string]string, order elementOrder,
+) (buffer.Element, error) {
+	el, err := buffer.NewElement(msg, callbackType, internal)
 	if err != nil {
-		b.config.Logger.Error("failed to add message in buffer", "err", err)
+		return buffer.Element{}, err //nolint: wrapcheck
+	}
+
+	return b.completeElement(el, headers, order)
+}
+
+// newUserElement creates a new user element originated by the host.
+func (b *BMMC) newUserElement(um userMessage, order elementOrder) (buffer.Element, error) {
+	if um.key == "" {
+		return b.newElement(um.msg, um.callbackType, false, um.headers, order)
+	}
+
+	el, err := buffer.NewKeyedElement(um.key, um.msg, um.callbackType)
+	if err != nil {
+		return buffer.Element{}, err //nolint: wrapcheck
+	}
+
+	return b.completeElement(el, um.headers, order)
+}
+
+// completeElement sets the origin, headers and order of a new element
+// originated by the host, then encrypts and signs it.
+func (b *BMMC) completeElement(el buffer.Element, headers map[string]string, order elementOrder) (buffer.Element, error) {
+	el.Origin = b.config.Host.String()
+	el.Headers = headers
+	el.Clock = order.clock
+	el.Lamport = order.lamport
+
+	if order.unique {
+		if err := el.Sequence(order.epoch, order.seq); err != nil {
+			return buffer.Element{}, err //nolint: wrapcheck
+		}
+	} else {
+		el.Epoch, el.Seq = order.epoch, order.seq
+	}
+
+	if err := b.encryptElement(&el); err != nil {
+		return buffer.Element{}, err
+	}
 
-		return err //nolint: wrapcheck
+	if err := b.signElement(&el); err != nil {
+		return buffer.Element{}, err
 	}
 
-	if err := b.messageBuffer.Add(m); err != nil {
+	return el, nil
+}
+
+// AddMessage adds new message in messages buffer.
+// The span context from the given context is propagated with the message,
+// so the spans started by other hosts for the message are part of the same trace.
+func (b *BMMC) AddMessage(ctx context.Context, msg any, callbackType string) error {
+	_, err := b.AddMessageWithID(ctx, msg, callbackType)
+
+	return err
+}
+
+// AddMessageWithID adds new message in messages buffer, like AddMessage,
+// and returns the ID of the message (e.g. for WaitForCoverage).
+func (b *BMMC) AddMessageWithID(ctx context.Context, msg any, callbackType string) (string, error) {
+	return b.addMessage(ctx, userMessage{msg: msg, callbackType: callbackType})
+}
+
+// userMessage is a user message added by the host.
+type userMessage struct {
+	msg          any
+	callbackType string
+	// key is the key of keyed messages
+	key string
+	// headers are the metadata of the message, without the span context
+	headers map[string]string
+	// register is called with the message ID before the message is sent to peers. Optional.
+	register func(id string)
+}
+
+// addMessage adds new user message in messages buffer.
+func (b *BMMC) addMessage(ctx context.Context, um userMessage) (string, error) {
+	ctx, span := b.config.Tracer.Start(ctx, SpanAddMessage)
+	defer span.End()
+
+	span.SetAttribute("bmmc.host", b.config.Host.String())
+	span.SetAttribute("bmmc.element.callback_type", um.callbackType)
+
+	um.headers = b.elementHeaders(ctx, um.headers)
+
+	m, err := b.addUserElement(ctx, um)
+	if errors.Is(err, buffer.ErrDuplicateElement) {
+		// the message was already added
+		return m.ID, nil
+	}
+
+	if err != nil {
 		b.config.Logger.Error("failed to add message in buffer", "err", err)
+		span.RecordError(err)
 
-		return err //nolint: wrapcheck
+		return "", err //nolint: wrapcheck
 	}
 
+	span.SetAttribute("bmmc.element.id", m.ID)
+
 	b.config.Logger.Debug("synced buffer with message", "round", b.gossipRound.GetNumber())
 
-	b.runCallbacks(m)
+	b.multicastSynchronization([]buffer.Element{m})
 
-	return nil
+	if m.Lamport > 0 {
+		// the message is delivered in total order
+		b.releaseOrdered()
+	} else {
+		b.runCallbacks(ctx, m)
+	}
+
+	return m.ID, nil
 }
 
 // AddPeer adds new peer in peers buffer.
 func (b *BMMC) AddPeer(p string) error {
-	if added := b.peerBuffer.AddPeer(p); !added {
-		return nil
+	return b.AddPeerWithToken(p, "")
+}
+
+// AddPeerWithToken adds new peer in peers buffer, together with its join token.
+// Peers that use a JoinTokenPolicy accept the new peer only if the token is valid.
+func (b *BMMC) AddPeerWithToken(p string, token string) error {
+	added, err := b.addLocalPeer(p, token)
+	if err != nil || !added {
+		return err
 	}
 
-	msg, err := buffer.NewElement(p, callback.ADDPEER, true)
+	var headers map[string]string
+
+	if token != "" {
+		headers = map[string]string{joinTokenHeader: token}
+	}
+
+	msg, err := b.newElement(p, callback.ADDPEER, true, headers, elementOrder{})
 	if err != nil {
 		return fmt.Errorf(addPeerErrFmt, p, err)
 	}
 
-	if err = b.messageBuffer.Add(msg); err != nil {
+	if err = b.addElement(msg); err != nil {
 		return fmt.Errorf(addPeerErrFmt, p, err)
 	}
 
+	b.multicastSynchronization([]buffer.Element{msg})
+
 	return nil
 }
 
 // RemovePeer removes given peer from peers buffer.
 func (b *BMMC) RemovePeer(p string) error {
-	b.peerBuffer.RemovePeer(p)
+	if err := b.removeLocalPeer(p); err != nil {
+		return err
+	}
 
-	msg, err := buffer.NewElement(p, callback.REMOVEPEER, true)
+	msg, err := b.newElement(p, callback.REMOVEPEER, true, nil, elementOrder{})
 	if err != nil {
 		return fmt.Errorf(removePeerErrFmt, p, err)
 	}
 
-	if err := b.messageBuffer.Add(msg); err != nil {
+	if err := b.addElement(msg); err != nil {
+		return fmt.Errorf(removePeerErrFmt, p, err)
+	}
+
+	b.multicastSynchronization([]buffer.Element{msg})
+
+	return nil
+}
+
+// addLocalPeer adds new peer in peers buffer of the host, if the membership
+// policy allows it, without disseminating the change. It returns true if the
+// peer was not in peers buffer.
+func (b *BMMC) addLocalPeer(p string, token string) (bool, error) {
+	change := MembershipChange{
+		Action: MembershipAdd,
+		Peer:   p,
+		Origin: b.config.Host.String(),
+		Token:  token,
+	}
+
+	if err := b.allowMembershipChange(change); err != nil {
+		return false, fmt.Errorf(addPeerErrFmt, p, err)
+	}
+
+	if added := b.peerBuffer.AddPeer(p); !added {
+		return false, nil
+	}
+
+	b.config.Observer.OnPeerAdded(p)
+
+	return true, nil
+}
+
+// removeLocalPeer removes given peer from peers buffer of the host, if the
+// membership policy allows it, without disseminating the change.
+func (b *BMMC) removeLocalPeer(p string) error {
+	change := MembershipChange{
+		Action: MembershipRemove,
+		Peer:   p,
+		Origin: b.config.Host.String(),
+	}
+
+	if err := b.allowMembershipChange(change); err != nil {
 		return fmt.Errorf(removePeerErrFmt, p, err)
 	}
 
+	if removed := b.peerBuffer.RemovePeer(p); removed {
+		b.config.Observer.OnPeerRemoved(p)
+	}
+
 	return nil
 }
 
 // GetMessages returns a slice with all user messages from messages buffer.
+// Encrypted messages that cannot be decrypted by the host are skipped.
 func (b *BMMC) GetMessages() []any {
-	return b.messageBuffer.Messages(false)
+	msgs := []any{}
+
+	for _, el := range b.messageBuffer.UserElements() {
+		el, err := b.decryptElement(el)
+		if err != nil {
+			continue
+		}
+
+		msgs = append(msgs, el.Msg)
+	}
+
+	return msgs
 }
 
 // GetPeers returns an array with all peers from peers buffer.
@@ -173,29 +406,61 @@ func (b *BMMC) GetPeers() []string {
 	return b.peerBuffer.GetPeers()
 }
 
-func (b *BMMC) runCallbacks(el buffer.Element) {
+func (b *BMMC) runCallbacks(ctx context.Context, el buffer.Element) {
 	if el.CallbackType == callback.NOCALLBACK {
 		return
 	}
 
+	if !el.Internal && b.retractions.retracts(el) {
+		// the message was retracted while it was held back
+		return
+	}
+
+	if b.supersededElement(el) {
+		// a newer version of the message was received while it was held back
+		return
+	}
+
 	callbackFn := b.callbacksRegistry.GetCallback(el.CallbackType)
 	if callbackFn == nil {
 		return
 	}
 
+	el, err := b.decryptElement(el)
+	if err != nil {
+		b.config.Logger.Debug("cannot decrypt message for callback", "err", err, "id", el.ID)
+
+		return
+	}
+
+	_, span := b.startElementSpan(ctx, SpanCallback, el)
+	defer span.End()
+
 	var callbackData any
 
-	if el.CallbackType == callback.ADDPEER || el.CallbackType == callback.REMOVEPEER {
+	switch el.CallbackType {
+	case callback.ADDPEER, callback.REMOVEPEER:
 		// internal callback
 		callbackData = callback.PeerCallbackData{
-			Element: el,
-			Buffer:  b.peerBuffer,
+			Element:   el,
+			Buffer:    b.peerBuffer,
+			OnAdded:   b.config.Observer.OnPeerAdded,
+			OnRemoved: b.config.Observer.OnPeerRemoved,
 		}
-	} else {
+	case callback.RETRACT:
+		// internal callback
 		callbackData = el
+	default:
+		callbackData = newDelivery(el)
 	}
 
 	if err := callbackFn(callbackData, b.config.Logger); err != nil {
+		b.incCounter(MetricCallbackFailures)
+		span.RecordError(err)
 		b.config.Logger.Error("failed to run callback for message", "msg", el.Msg)
+
+		return
 	}
+
+	b.incCounter(MetricCallbackSuccesses)
 }
@@ -0,0 +1,437 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// ackHeader is the element header requesting acknowledgements from the receivers.
+	ackHeader = "ack"
+
+	ackDecodingErrFmt     = "error at decoding ack message in Server: %w: %w"
+	ackMarshalErrFmt      = "error at marshal ack message in Server: %w"
+	notAcknowledgedErrFmt = "%w: %d of %d replicas: %w"
+)
+
+var (
+	// ErrNotAcknowledged is returned by Wait when the message was not acknowledged
+	// by enough replicas.
+	ErrNotAcknowledged = errors.New("message not acknowledged by enough replicas")
+	// ErrDuplicateBroadcast is returned by Broadcast when the message is already
+	// in buffer and its acknowledgements are not tracked.
+	ErrDuplicateBroadcast = errors.New("message already in buffer")
+
+	errInvalidBroadcast  = errors.New("invalid broadcast options")
+	errRemovedFromBuffer = errors.New("message removed from buffer")
+)
+
+// Ack is acknowledgement message, sent to the origin of received elements.
+type Ack struct {
+	Host string   `json:"host"`
+	IDs  []string `json:"ids"`
+}
+
+// rawAck is an ack message with IDs not decoded yet.
+type rawAck struct {
+	Ack
+	IDs json.RawMessage `json:"ids"`
+}
+
+// BroadcastOptions are the options of an acknowledged broadcast.
+type BroadcastOptions struct {
+	// CallbackType is the callback type of the message.
+	CallbackType string
+	// Replicas is the number of peers that must acknowledge the message.
+	Replicas int
+	// Quorum requires the acknowledgements of a majority of peers, instead of Replicas.
+	Quorum bool
+	// Timeout is the maximum time to wait for acknowledgements. Optional.
+	Timeout time.Duration
+}
+
+// BroadcastResult is the state of an acknowledged broadcast.
+type BroadcastResult struct {
+	// ID is the ID of the message.
+	ID string
+	// Required is the number of acknowledgements required.
+	Required int
+	// Acked are the peers which acknowledged the message, sorted.
+	Acked []string
+}
+
+// Done returns true if the message was acknowledged by enough replicas.
+func (r BroadcastResult) Done() bool {
+	return len(r.Acked) >= r.Required
+}
+
+// BroadcastHandle waits for the acknowledgements of a broadcast message.
+type BroadcastHandle struct {
+	id       string
+	required int
+	deadline time.Time
+	mux      *sync.Mutex
+	acked    map[string]struct{}
+	// added is true once the message is in buffer
+	added bool
+	// done is closed when the message is acknowledged by enough replicas
+	// or when the acknowledgements are not tracked anymore
+	done   chan struct{}
+	closed bool
+	// cause is the reason the acknowledgements are not tracked anymore
+	cause error
+}
+
+func newBroadcastHandle(required int, deadline time.Time) *BroadcastHandle {
+	h := &BroadcastHandle{
+		required: required,
+		deadline: deadline,
+		mux:      &sync.Mutex{},
+		acked:    map[string]struct{}{},
+		done:     make(chan struct{}),
+	}
+
+	if required == 0 {
+		h.close(nil)
+	}
+
+	return h
+}
+
+// ID returns the ID of the broadcast message.
+func (h *BroadcastHandle) ID() string {
+	return h.id
+}
+
+// Result returns the current state of the broadcast.
+func (h *BroadcastHandle) Result() BroadcastResult {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	acked := make([]string, 0, len(h.acked))
+
+	for host := range h.acked {
+		acked = append(acked, host)
+	}
+
+	sort.Strings(acked)
+
+	return BroadcastResult{ID: h.id, Required: h.required, Acked: acked}
+}
+
+// Wait waits until the message is acknowledged by enough replicas, the timeout
+// of the broadcast expires or the context is done. It returns the result of the
+// broadcast, and an error that wraps ErrNotAcknowledged when the message was not
+// acknowledged by enough replicas, with the peers that acknowledged it so far.
+func (h *BroadcastHandle) Wait(ctx context.Context) (BroadcastResult, error) {
+	if !h.deadline.IsZero() {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithDeadline(ctx, h.deadline)
+		defer cancel()
+	}
+
+	cause := error(nil)
+
+	select {
+	case <-h.done:
+		h.mux.Lock()
+		cause = h.cause
+		h.mux.Unlock()
+	case <-ctx.Done():
+		cause = ctx.Err()
+	}
+
+	result := h.Result()
+	if result.Done() {
+		return result, nil
+	}
+
+	return result, fmt.Errorf(notAcknowledgedErrFmt, ErrNotAcknowledged, len(result.Acked), result.Required, cause)
+}
+
+// ack records the acknowledgement of a peer and returns true if the message
+// is acknowledged by enough replicas.
+func (h *BroadcastHandle) ack(host string) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.acked[host] = struct{}{}
+
+	if len(h.acked) >= h.required {
+		h.closeLocked(nil)
+
+		return true
+	}
+
+	return false
+}
+
+// close stops waiting for acknowledgements.
+func (h *BroadcastHandle) close(cause error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.closeLocked(cause)
+}
+
+func (h *BroadcastHandle) closeLocked(cause error) {
+	if h.closed {
+		return
+	}
+
+	h.closed = true
+	h.cause = cause
+	close(h.done)
+}
+
+// ackTracker routes the received acknowledgements to the broadcast handles,
+// indexed by message ID.
+type ackTracker struct {
+	mux     *sync.Mutex
+	handles map[string]*BroadcastHandle
+}
+
+func newAckTracker() *ackTracker {
+	return &ackTracker{
+		mux:     &sync.Mutex{},
+		handles: map[string]*BroadcastHandle{},
+	}
+}
+
+// register tracks the acknowledgements of the message with given ID.
+func (t *ackTracker) register(id string, h *BroadcastHandle) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.handles[id] = h
+}
+
+// handle returns the handle tracking the acknowledgements of the message with
+// given ID, or nil if they are not tracked.
+func (t *ackTracker) handle(id string) *BroadcastHandle {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.handles[id]
+}
+
+// added records that the message with given ID was added in buffer.
+// The acknowledgements can be received before.
+func (t *ackTracker) added(id string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if h, ok := t.handles[id]; ok {
+		h.added = true
+	}
+}
+
+// remove stops tracking the acknowledgements of the message with given ID.
+func (t *ackTracker) remove(id string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.handles, id)
+}
+
+// ack records the acknowledgements of a peer for the messages with given IDs.
+func (t *ackTracker) ack(host string, ids []string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for _, id := range ids {
+		h, ok := t.handles[id]
+		if ok && h.ack(host) {
+			delete(t.handles, id)
+		}
+	}
+}
+
+// retain stops tracking the acknowledgements of the messages removed from buffer,
+// or whose timeout expired. The digest is computed under lock, after the messages
+// recorded as added are in buffer.
+func (t *ackTracker) retain(digest func() []string, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	ids := digest()
+
+	inBuffer := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		inBuffer[id] = struct{}{}
+	}
+
+	for id, h := range t.handles {
+		if _, ok := inBuffer[id]; !ok && h.added {
+			h.close(errRemovedFromBuffer)
+			delete(t.handles, id)
+
+			continue
+		}
+
+		if !h.deadline.IsZero() && now.After(h.deadline) {
+			h.close(context.DeadlineExceeded)
+			delete(t.handles, id)
+		}
+	}
+}
+
+// Broadcast adds new message in messages buffer, like AddMessage, and requests
+// the receivers to acknowledge it. The returned handle waits until the given
+// number of peers (or a majority of peers) acknowledged the message.
+// Only the acknowledgements of peers are counted. Without ordered delivery, when
+// the message is already in buffer, the handle of the previous broadcast is
+// returned, or ErrDuplicateBroadcast if its acknowledgements are not tracked.
+func (b *BMMC) Broadcast(ctx context.Context, msg any, opts BroadcastOptions) (*BroadcastHandle, error) {
+	if opts.Replicas < 0 || opts.Timeout < 0 {
+		return nil, errInvalidBroadcast
+	}
+
+	required := opts.Replicas
+
+	if opts.Quorum {
+		required = 0
+		if peers := b.peerBuffer.Length(); peers > 0 {
+			required = peers/2 + 1
+		}
+	}
+
+	deadline := time.Time{}
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+
+	h := newBroadcastHandle(required, deadline)
+
+	// duplicate is true when the message is already in buffer, e.g. it was
+	// broadcast before, and previous is the handle of the previous broadcast
+	var (
+		duplicate bool
+		previous  *BroadcastHandle
+	)
+
+	register := func(id string) {
+		h.id = id
+
+		if len(b.messageBuffer.ElementsFromIDs([]string{id})) > 0 {
+			duplicate = true
+			previous = b.acks.handle(id)
+
+			return
+		}
+
+		if required > 0 {
+			b.acks.register(id, h)
+		}
+	}
+
+	um := userMessage{
+		msg:          msg,
+		callbackType: opts.CallbackType,
+		headers:      map[string]string{ackHeader: "true"},
+		register:     register,
+	}
+
+	if _, err := b.addMessage(ctx, um); err != nil {
+		if !duplicate {
+			b.acks.remove(h.id)
+		}
+
+		return nil, err
+	}
+
+	if duplicate {
+		if previous == nil {
+			return nil, ErrDuplicateBroadcast
+		}
+
+		return previous, nil
+	}
+
+	b.acks.added(h.id)
+
+	return h, nil
+}
+
+// ackElements sends acknowledgements for the given elements to their origins.
+func (b *BMMC) ackElements(elements map[string][]string) {
+	for origin, ids := range elements {
+		b.sendAck(Ack{Host: b.config.Host.String(), IDs: ids}, origin) //nolint: errcheck
+	}
+}
+
+// receiveAck receives an ack message.
+func (b *BMMC) receiveAck(msg []byte) (Ack, error) {
+	var raw rawAck
+
+	msg, err := b.open(AckRoute, msg)
+	if err != nil {
+		return Ack{}, err
+	}
+
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		b.config.Logger.Error("cannot decode ack message", "err", err)
+
+		b.stats.rejectedMessages.Add(1)
+
+		return Ack{}, fmt.Errorf(ackDecodingErrFmt, ErrDecode, err)
+	}
+
+	body := raw.Ack
+
+	if body.IDs, err = decodeList[string](b, raw.IDs, b.digestBudget()); err != nil {
+		return Ack{}, err
+	}
+
+	return body, nil
+}
+
+// sendAck sends an ack message.
+func (b *BMMC) sendAck(ack Ack, peerToSend string) error {
+	jsonAck, err := json.Marshal(ack)
+	if err != nil {
+		b.config.Logger.Error("cannot marshal ack message", "err", err)
+
+		return fmt.Errorf(ackMarshalErrFmt, err)
+	}
+
+	jsonAck, err = b.seal(AckRoute, jsonAck)
+	if err != nil {
+		return err
+	}
+
+	done := b.outbound.begin(AckRoute)
+
+	go func() {
+		defer done()
+
+		if err := b.config.Host.Send(jsonAck, AckRoute, peerToSend); err != nil {
+			b.sendFailed(peerToSend, AckRoute, err)
+			b.config.Logger.Error("cannot send ack message", "err", err)
+		}
+	}()
+
+	return nil
+}
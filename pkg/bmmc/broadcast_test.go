@@ -0,0 +1,179 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Broadcast", func() {
+	It("waits until the given number of peers acknowledged the message", func() {
+		nodes := newTestCluster(4, nil)
+
+		h, err := nodes[0].Broadcast(context.Background(), "message", BroadcastOptions{
+			CallbackType: NOCALLBACK,
+			Replicas:     3,
+			Timeout:      5 * time.Second,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.ID()).ToNot(BeEmpty())
+
+		result, err := h.Wait(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Done()).To(BeTrue())
+		Expect(result.ID).To(Equal(h.ID()))
+		Expect(result.Required).To(Equal(3))
+		Expect(result.Acked).To(ConsistOf("n1", "n2", "n3"))
+	})
+
+	It("waits for a quorum of peers", func() {
+		nodes := newTestCluster(5, nil)
+
+		h, err := nodes[0].Broadcast(context.Background(), "message", BroadcastOptions{
+			CallbackType: NOCALLBACK,
+			Quorum:       true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		result, err := h.Wait(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Required).To(Equal(3))
+		Expect(len(result.Acked)).To(BeNumerically(">=", 3))
+	})
+
+	It("reports the partial success when the timeout expires", func() {
+		nodes := newTestCluster(3, nil)
+
+		h, err := nodes[0].Broadcast(context.Background(), "message", BroadcastOptions{
+			CallbackType: NOCALLBACK,
+			Replicas:     3,
+			Timeout:      500 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := h.Wait(context.Background())
+		Expect(err).To(MatchError(ErrNotAcknowledged))
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(result.Done()).To(BeFalse())
+		Expect(result.Acked).To(Equal([]string{"n1", "n2"}))
+	})
+
+	It("doesn't wait when no acknowledgement is required", func() {
+		nodes := newTestCluster(2, nil)
+
+		h, err := nodes[0].Broadcast(context.Background(), "message", BroadcastOptions{CallbackType: NOCALLBACK})
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := h.Wait(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Done()).To(BeTrue())
+		Expect(nodes[0].messageBuffer.Digest()).To(ContainElement(h.ID()))
+	})
+
+	It("returns error for invalid options", func() {
+		nodes := newTestCluster(2, nil)
+
+		_, err := nodes[0].Broadcast(context.Background(), "message", BroadcastOptions{
+			CallbackType: NOCALLBACK,
+			Replicas:     -1,
+		})
+		Expect(err).To(MatchError(errInvalidBroadcast))
+	})
+
+	It("counts only the acknowledgements of peers", func() {
+		nodes := newTestCluster(2, nil)
+
+		h, err := nodes[0].Broadcast(context.Background(), "message", BroadcastOptions{
+			CallbackType: NOCALLBACK,
+			Replicas:     2,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() []string { return h.Result().Acked }).Should(Equal([]string{"n1"}))
+
+		ack := fmt.Sprintf(`{"host":"n9","ids":[%q]}`, h.ID())
+		_, err = nodes[0].Handle(context.Background(), AckRoute, []byte(ack))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.Result().Acked).To(Equal([]string{"n1"}))
+		Expect(h.Result().Done()).To(BeFalse())
+	})
+
+	Context("when the message is already in buffer", func() {
+		var b *BMMC
+
+		BeforeEach(func() {
+			b = newTestCluster(2, nil)[0]
+		})
+
+		It("returns the handle of the previous broadcast", func() {
+			// the only peer can't complete the broadcast, so it is still tracked
+			previous, err := b.Broadcast(context.Background(), "message", BroadcastOptions{
+				CallbackType: NOCALLBACK,
+				Replicas:     2,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			h, err := b.Broadcast(context.Background(), "message", BroadcastOptions{
+				CallbackType: NOCALLBACK,
+				Replicas:     2,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(h).To(BeIdenticalTo(previous))
+			Expect(b.acks.handle(h.ID())).To(BeIdenticalTo(previous))
+			Expect(b.GetMessages()).To(ConsistOf("message"))
+		})
+
+		It("returns error when the acknowledgements are not tracked", func() {
+			id, err := b.AddMessageWithID(context.Background(), "message", NOCALLBACK)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = b.Broadcast(context.Background(), "message", BroadcastOptions{
+				CallbackType: NOCALLBACK,
+				Replicas:     1,
+			})
+			Expect(err).To(MatchError(ErrDuplicateBroadcast))
+			Expect(b.acks.handle(id)).To(BeNil())
+		})
+	})
+
+	It("stops waiting when the message is removed from buffer", func() {
+		tracker := newAckTracker()
+		h := newBroadcastHandle(2, time.Time{})
+		h.id = "a"
+		tracker.register("a", h)
+
+		// the message is not in buffer yet
+		tracker.retain(func() []string { return nil }, time.Now())
+		tracker.added("a")
+
+		tracker.ack("n1", []string{"a", "b"})
+		tracker.retain(func() []string { return []string{"b"} }, time.Now())
+
+		result, err := h.Wait(context.Background())
+		Expect(err).To(MatchError(ErrNotAcknowledged))
+		Expect(err).To(MatchError(errRemovedFromBuffer))
+		Expect(result.Acked).To(Equal([]string{"n1"}))
+	})
+})
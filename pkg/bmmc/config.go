@@ -17,7 +17,9 @@ limitations under the License.
 package bmmc
 
 import (
+	"crypto/ed25519"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"time"
@@ -31,7 +33,49 @@ const (
 	defaultRoundDuration = time.Millisecond * 100
 )
 
-var errInvalidBufSize = errors.New("invalid buffer size")
+var (
+	errInvalidBufSize       = errors.New("invalid buffer size")
+	errInvalidExchangeMode  = errors.New("invalid exchange mode")
+	errInvalidSyncRateLimit = errors.New("invalid synchronization rate limit")
+	errSyncBurstTooSmall    = errors.New("synchronization burst is smaller than the maximum element size")
+	errHostCannotRequest    = errors.New("host must implement Request for synchronous exchange modes")
+	errInvalidDeliveryOrder = errors.New("invalid delivery order")
+	errInvalidHoldBack      = errors.New("invalid hold-back limit or timeout")
+	errInvalidSilence       = errors.New("invalid silence timeout")
+	errInvalidStability     = errors.New("invalid stability timeout")
+	errInvalidSupersededTTL = errors.New("invalid superseded TTL")
+	errInvalidRetractionTTL = errors.New("invalid retraction TTL")
+	errOrderedCallbackType  = errors.New("callback type is both in Callbacks and OrderedCallbacks")
+)
+
+// ExchangeMode is the way protocol messages are exchanged between peers.
+type ExchangeMode int
+
+const (
+	// AsyncExchange sends every protocol message with a new request.
+	AsyncExchange ExchangeMode = iota
+	// SolicitationExchange delivers the synchronization message as the reply
+	// to the solicitation message.
+	SolicitationExchange
+	// GossipExchange delivers the solicitation message as the reply to the
+	// gossip message. The gossiper sends the synchronization message in the same
+	// direction as the gossip message, so peers behind NAT can be synchronized.
+	GossipExchange
+)
+
+// String returns the name of the exchange mode.
+func (m ExchangeMode) String() string {
+	switch m {
+	case AsyncExchange:
+		return "async"
+	case SolicitationExchange:
+		return "solicitation"
+	case GossipExchange:
+		return "gossip"
+	default:
+		return fmt.Sprintf("ExchangeMode(%d)", int(m))
+	}
+}
 
 // Config is the config for the protocol.
 type Config struct {
@@ -47,6 +91,19 @@ type Config struct {
 	// Callbacks functions.
 	// Optional
 	Callbacks map[string]func(any, *slog.Logger) error
+	// OrderedCallbacks are callbacks for messages delivered in the same total
+	// order by all hosts, once all members are known to have advanced past them.
+	// A callback type must not be both in Callbacks and OrderedCallbacks.
+	// Optional
+	OrderedCallbacks map[string]func(any, *slog.Logger) error
+	// RetractCallback is called with a Retraction when a message is retracted.
+	// Optional
+	RetractCallback func(any, *slog.Logger) error
+	// RetractionTTL is the minimum time a retracted message is remembered after its
+	// tombstone was received, so it is not accepted again from peers, even if the
+	// tombstone was removed from buffer.
+	// Optional. Default is 100 gossip rounds.
+	RetractionTTL time.Duration
 	// Gossip round duration.
 	// Optional
 	RoundDuration time.Duration
@@ -54,6 +111,108 @@ type Config struct {
 	// When the buffer is full, the oldest message will be removed.
 	// Required
 	BufferSize int
+	// EvictStable removes the stable messages from buffer before it is full.
+	// A message is stable when it is known to be received by all members.
+	// Optional
+	EvictStable bool
+	// StabilityTimeout is the maximum time the messages missing from an origin are
+	// waited for by stability detection before they are skipped, and the time the
+	// IDs of the stable messages removed from buffer are remembered, so they are
+	// not solicited again.
+	// Optional. Default is 10 gossip rounds.
+	StabilityTimeout time.Duration
+	// SupersededTTL is the time the IDs of the older versions of keyed messages
+	// removed from buffer are remembered, so they are not solicited again.
+	// Optional. Default is 10 gossip rounds.
+	SupersededTTL time.Duration
+	// Exchange is the way protocol messages are exchanged between peers.
+	// Synchronous exchange modes require a Host that implements Request.
+	// Optional. Default is AsyncExchange.
+	Exchange ExchangeMode
+	// PrivateKey is the Ed25519 key used to sign the messages created by the host.
+	// Optional.
+	PrivateKey ed25519.PrivateKey
+	// TrustedKeys are the public keys used to verify the received messages.
+	// When set, unsigned messages and messages with invalid signatures are rejected.
+	// Optional.
+	TrustedKeys KeyStore
+	// AuthKeys are the shared cluster secrets used to authenticate protocol
+	// messages with HMAC-SHA256, indexed by key ID.
+	// More keys can be accepted at the same time during key rotation.
+	// Optional.
+	AuthKeys map[string][]byte
+	// AuthKeyID is the ID of the key used to authenticate sent messages.
+	// Required when AuthKeys is set.
+	AuthKeyID string
+	// MaxMessageAge is the maximum age (and clock skew) of authenticated messages.
+	// Older messages are rejected.
+	// Optional
+	MaxMessageAge time.Duration
+	// EncryptionKeys are the AES keys (16, 24 or 32 bytes) used to encrypt
+	// user messages with AES-GCM, indexed by key ID.
+	// Hosts without the key store and gossip the encrypted messages,
+	// but don't deliver them.
+	// Optional.
+	EncryptionKeys map[string][]byte
+	// EncryptionKeyID is the ID of the key used to encrypt new messages.
+	// Required when EncryptionKeys is set.
+	EncryptionKeyID string
+	// MembershipPolicy is consulted before changes of the peers list
+	// (local or received from peers) are applied.
+	// Optional.
+	MembershipPolicy MembershipPolicy
+	// OnMembershipRejected is called when a change of the peers list is
+	// rejected by the membership policy.
+	// Optional.
+	OnMembershipRejected func(change MembershipChange, err error)
+	// RequireKnownSender rejects messages from hosts that are not in peers buffer.
+	// Optional.
+	RequireKnownSender bool
+	// VerifySource checks that the host of a received message matches the source
+	// address reported by the transport with ContextWithSource (e.g. MatchSourceHost).
+	// When it is set, the messages handled without source are rejected, except the
+	// replies to the requests of the host.
+	// Optional.
+	VerifySource func(host string, source string) bool
+	// SyncRateLimit is the maximum number of bytes per second sent in
+	// synchronization messages to each peer.
+	// Optional. When 0, synchronization messages are not limited.
+	SyncRateLimit int
+	// SyncBurst is the maximum number of bytes sent at once in synchronization
+	// messages to each peer. It must not be smaller than Limits.MaxElementBytes.
+	// An element larger than the burst is sent alone, when the burst is available.
+	// Optional. Default is SyncRateLimit.
+	SyncBurst int
+	// Limits are the limits of received messages.
+	// Optional. By default, received messages are not limited.
+	Limits Limits
+	// Metrics receives the metrics of the protocol.
+	// Optional. By default, metrics are discarded.
+	Metrics Metrics
+	// Tracer starts spans for messages and propagates their span context.
+	// Optional. By default, messages are not traced.
+	Tracer Tracer
+	// Observer is notified about protocol events.
+	// Optional. Multiple observers can be composed with Observers.
+	Observer Observer
+	// Delivery is the order in which received user messages are delivered to callbacks.
+	// Optional. Default is UnorderedDelivery.
+	Delivery DeliveryOrder
+	// HoldBackLimit is the maximum number of received messages held back until
+	// the messages before them are delivered. When the limit is exceeded, the
+	// missing messages of the oldest held back message are skipped.
+	// Optional. Default is BufferSize.
+	HoldBackLimit int
+	// HoldBackTimeout is the maximum time a received message is held back before
+	// the missing messages before it are skipped.
+	// Optional. Default is 10 gossip rounds.
+	HoldBackTimeout time.Duration
+	// SilenceTimeout is the maximum time a member is waited for by the messages of
+	// OrderedCallbacks after it was last seen (i.e. it gossiped or a message from it
+	// was received). Silent members (e.g. crashed members) are not waited for, so
+	// their messages received afterwards may be skipped.
+	// Optional. Default is 50 gossip rounds.
+	SilenceTimeout time.Duration
 }
 
 // validate validates given config.
@@ -62,6 +221,75 @@ func (cfg *Config) validate() error {
 		return errInvalidBufSize
 	}
 
+	if cfg.SyncRateLimit < 0 || cfg.SyncBurst < 0 {
+		return errInvalidSyncRateLimit
+	}
+
+	burst := cfg.SyncBurst
+	if burst == 0 {
+		burst = cfg.SyncRateLimit
+	}
+
+	if burst > 0 && burst < cfg.Limits.MaxElementBytes {
+		return errSyncBurstTooSmall
+	}
+
+	if cfg.HoldBackLimit < 0 || cfg.HoldBackTimeout < 0 {
+		return errInvalidHoldBack
+	}
+
+	if cfg.SilenceTimeout < 0 {
+		return errInvalidSilence
+	}
+
+	if cfg.StabilityTimeout < 0 {
+		return errInvalidStability
+	}
+
+	if cfg.SupersededTTL < 0 {
+		return errInvalidSupersededTTL
+	}
+
+	if cfg.RetractionTTL < 0 {
+		return errInvalidRetractionTTL
+	}
+
+	switch cfg.Delivery {
+	case UnorderedDelivery, FIFODelivery, CausalDelivery:
+	default:
+		return errInvalidDeliveryOrder
+	}
+
+	if cfg.PrivateKey != nil && len(cfg.PrivateKey) != ed25519.PrivateKeySize {
+		return errInvalidPrivateKey
+	}
+
+	if len(cfg.AuthKeys) > 0 {
+		if _, ok := cfg.AuthKeys[cfg.AuthKeyID]; !ok {
+			return errMissingAuthKey
+		}
+	}
+
+	switch cfg.Exchange {
+	case AsyncExchange:
+	case SolicitationExchange, GossipExchange:
+		if _, ok := cfg.Host.(peer.Requester); !ok {
+			return errHostCannotRequest
+		}
+	default:
+		return errInvalidExchangeMode
+	}
+
+	for callbackType := range cfg.OrderedCallbacks {
+		if _, ok := cfg.Callbacks[callbackType]; ok {
+			return errOrderedCallbackType
+		}
+	}
+
+	if err := callback.ValidateCustomCallbacks(cfg.OrderedCallbacks); err != nil {
+		return err //nolint: wrapcheck
+	}
+
 	return callback.ValidateCustomCallbacks(cfg.Callbacks) //nolint: wrapcheck
 }
 
@@ -79,6 +307,50 @@ func (cfg *Config) fillEmptyFields() {
 		cfg.RoundDuration = defaultRoundDuration
 	}
 
+	if cfg.MaxMessageAge == 0 {
+		cfg.MaxMessageAge = defaultMaxMessageAge
+	}
+
+	if cfg.SyncBurst == 0 {
+		cfg.SyncBurst = cfg.SyncRateLimit
+	}
+
+	if cfg.HoldBackLimit == 0 {
+		cfg.HoldBackLimit = cfg.BufferSize
+	}
+
+	if cfg.HoldBackTimeout == 0 {
+		cfg.HoldBackTimeout = defaultHoldBackRounds * cfg.RoundDuration
+	}
+
+	if cfg.SilenceTimeout == 0 {
+		cfg.SilenceTimeout = defaultSilenceRounds * cfg.RoundDuration
+	}
+
+	if cfg.StabilityTimeout == 0 {
+		cfg.StabilityTimeout = defaultStabilityRounds * cfg.RoundDuration
+	}
+
+	if cfg.SupersededTTL == 0 {
+		cfg.SupersededTTL = defaultSupersededRounds * cfg.RoundDuration
+	}
+
+	if cfg.RetractionTTL == 0 {
+		cfg.RetractionTTL = defaultRetractionRounds * cfg.RoundDuration
+	}
+
+	if cfg.Metrics == nil {
+		cfg.Metrics = noopMetrics{}
+	}
+
+	if cfg.Tracer == nil {
+		cfg.Tracer = noopTracer{}
+	}
+
+	if cfg.Observer == nil {
+		cfg.Observer = BaseObserver{}
+	}
+
 	if cfg.Callbacks == nil {
 		cfg.Callbacks = map[string]func(any, *slog.Logger) error{}
 	}
@@ -0,0 +1,195 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Coverage is the estimation of how many members of the cluster have an element.
+type Coverage struct {
+	// Holders is the number of members known to have the element, including the host.
+	Holders int
+	// Members is the number of members of the cluster (peers and the host).
+	Members int
+}
+
+// Fraction returns the fraction of members known to have the element.
+func (c Coverage) Fraction() float64 {
+	if c.Members == 0 {
+		return 0
+	}
+
+	return float64(c.Holders) / float64(c.Members)
+}
+
+// coverageTracker tracks the hosts known to have each element.
+// Hosts learn about holders from gossip messages, which contain the digest of
+// the sender and the holders known by the sender, and from synchronization messages.
+type coverageTracker struct {
+	mux     *sync.Mutex
+	holders map[string]map[string]struct{}
+	// changed is closed and replaced when holders are added
+	changed chan struct{}
+}
+
+func newCoverageTracker() *coverageTracker {
+	return &coverageTracker{
+		mux:     &sync.Mutex{},
+		holders: map[string]map[string]struct{}{},
+		changed: make(chan struct{}),
+	}
+}
+
+// add records the given hosts as holders of the element with given ID.
+func (t *coverageTracker) add(id string, hosts ...string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	holders, ok := t.holders[id]
+	if !ok {
+		holders = map[string]struct{}{}
+		t.holders[id] = holders
+	}
+
+	added := false
+
+	for _, host := range hosts {
+		if _, ok := holders[host]; !ok && host != "" {
+			holders[host] = struct{}{}
+			added = true
+		}
+	}
+
+	if added {
+		close(t.changed)
+		t.changed = make(chan struct{})
+	}
+}
+
+// summary returns the known holders of the elements with given IDs.
+func (t *coverageTracker) summary(ids []string) map[string][]string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	summary := map[string][]string{}
+
+	for _, id := range ids {
+		holders := make([]string, 0, len(t.holders[id]))
+
+		for host := range t.holders[id] {
+			holders = append(holders, host)
+		}
+
+		if len(holders) > 0 {
+			summary[id] = holders
+		}
+	}
+
+	return summary
+}
+
+// holdersOf returns the known holders of the element with given ID that are
+// in the given members set, and a channel closed when holders are added.
+func (t *coverageTracker) holdersOf(id string, members map[string]struct{}) (int, <-chan struct{}) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	n := 0
+
+	for host := range t.holders[id] {
+		if _, ok := members[host]; ok {
+			n++
+		}
+	}
+
+	return n, t.changed
+}
+
+// retain forgets the holders of elements which are not in given IDs.
+func (t *coverageTracker) retain(ids []string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	keep := make(map[string]struct{}, len(ids))
+
+	for _, id := range ids {
+		keep[id] = struct{}{}
+	}
+
+	for id := range t.holders {
+		if _, ok := keep[id]; !ok {
+			delete(t.holders, id)
+		}
+	}
+}
+
+// mergeCoverage records the holders known from a received gossip message:
+// the sender has all elements from its digest, and the holders known by it.
+func (b *BMMC) mergeCoverage(gossip Gossip) {
+	for _, id := range gossip.Digest {
+		b.coverageTracker.add(id, append(gossip.Coverage[id], gossip.Host)...)
+	}
+}
+
+// coverage returns the coverage of the element with given ID and a channel
+// closed when the coverage may have changed.
+func (b *BMMC) coverage(id string) (Coverage, <-chan struct{}) {
+	host := b.config.Host.String()
+	members := map[string]struct{}{host: {}}
+
+	for _, p := range b.peerBuffer.GetPeers() {
+		members[p] = struct{}{}
+	}
+
+	holders, changed := b.coverageTracker.holdersOf(id, members)
+
+	return Coverage{Holders: holders, Members: len(members)}, changed
+}
+
+// Coverage returns the estimation of how many members of the cluster have
+// the element with given ID. The estimation is based on the gossip messages
+// received by the host, so it can be lower than the real coverage.
+func (b *BMMC) Coverage(id string) Coverage {
+	c, _ := b.coverage(id)
+
+	return c
+}
+
+// WaitForCoverage waits until the given fraction of members of the cluster
+// are known to have the element with given ID, or the context is done.
+func (b *BMMC) WaitForCoverage(ctx context.Context, id string, fraction float64) error {
+	// the coverage is checked again every round, since the peers list may change
+	ticker := time.NewTicker(b.config.RoundDuration)
+	defer ticker.Stop()
+
+	for {
+		c, changed := b.coverage(id)
+		if c.Fraction() >= fraction {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err() //nolint: wrapcheck
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Coverage", func() {
+	var nodes []*BMMC
+
+	BeforeEach(func() {
+		nodes = newTestCluster(3, nil)
+	})
+
+	It("estimates the coverage of a message", func() {
+		id, err := nodes[0].AddMessageWithID(context.Background(), "message", NOCALLBACK)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).ToNot(BeEmpty())
+
+		Expect(nodes[0].Coverage(id).Holders).To(BeNumerically(">=", 1))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		Expect(nodes[0].WaitForCoverage(ctx, id, 1)).To(Succeed())
+		Expect(nodes[0].Coverage(id)).To(Equal(Coverage{Holders: 3, Members: 3}))
+
+		// the other nodes learn the holders from gossip messages
+		for _, b := range nodes[1:] {
+			Eventually(func() float64 { return b.Coverage(id).Fraction() }).Should(Equal(1.0))
+		}
+	})
+
+	It("learns holders through intermediate peers", func() {
+		// n0 and n2 are connected only through n1
+		Expect(nodes[0].peerBuffer.RemovePeer("n2")).To(BeTrue())
+		Expect(nodes[2].peerBuffer.RemovePeer("n0")).To(BeTrue())
+
+		id, err := nodes[0].AddMessageWithID(context.Background(), "message", NOCALLBACK)
+		Expect(err).ToNot(HaveOccurred())
+
+		// n2 is not a peer of n0, but n0 learns that it has the message from n1
+		Eventually(func() []string { return nodes[0].coverageTracker.summary([]string{id})[id] }).
+			Should(ContainElement("n2"))
+	})
+
+	It("returns error when the coverage is not reached before the context is done", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		Expect(nodes[0].WaitForCoverage(ctx, "unknown-id", 0.5)).To(MatchError(context.DeadlineExceeded))
+		Expect(nodes[0].Coverage("unknown-id")).To(Equal(Coverage{Holders: 0, Members: 3}))
+	})
+})
@@ -0,0 +1,73 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+// Delivery is the data passed to the callbacks of user messages.
+type Delivery struct {
+	// ID is the ID of the message.
+	ID string
+	// Msg is the message.
+	Msg any
+	// CallbackType is the callback type of the message.
+	CallbackType string
+	// Key is the key of keyed messages, or empty for other messages.
+	Key string
+	// Origin is the host that added the message.
+	Origin string
+	// Headers are the metadata of the message (e.g. trace context).
+	Headers map[string]string
+	// OriginTime is the wall-clock time when the message was added by the origin.
+	OriginTime time.Time
+	// DeliveredAt is the time when the message was delivered by the host.
+	DeliveredAt time.Time
+	// Latency is the time from OriginTime to DeliveredAt.
+	// It is affected by the clock skew between the origin and the host.
+	Latency time.Duration
+	// Hops is the number of hosts that forwarded the message, including the origin.
+	// It is 0 for messages added by the host.
+	Hops int
+}
+
+// newDelivery returns the delivery metadata of the given element, delivered now.
+func newDelivery(el buffer.Element) Delivery {
+	now := time.Now()
+
+	return Delivery{
+		ID:           el.ID,
+		Msg:          el.Msg,
+		CallbackType: el.CallbackType,
+		Key:          el.Key,
+		Origin:       el.Origin,
+		Headers:      el.Headers,
+		OriginTime:   el.Timestamp,
+		DeliveredAt:  now,
+		Latency:      now.Sub(el.Timestamp),
+		Hops:         el.Hops,
+	}
+}
+
+// observeDelivery reports the latency and the hops of an element received from a peer.
+func (b *BMMC) observeDelivery(el buffer.Element) {
+	b.config.Metrics.ObserveHistogram(MetricDeliveryLatency, time.Since(el.Timestamp).Seconds())
+	b.config.Metrics.ObserveHistogram(MetricDeliveryHops, float64(el.Hops))
+}
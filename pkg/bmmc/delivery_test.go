@@ -0,0 +1,89 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Delivery", func() {
+	It("provides the delivery metadata to callbacks", func() {
+		var mux sync.Mutex
+
+		deliveries := map[string]Delivery{}
+		metrics := newFakeMetrics()
+
+		nodes := newTestCluster(3, func(cfg *Config) {
+			host := cfg.Host.String()
+
+			cfg.Metrics = metrics
+			cfg.Callbacks = map[string]func(any, *slog.Logger) error{
+				"my-callback": func(data any, _ *slog.Logger) error {
+					d, ok := data.(Delivery)
+					Expect(ok).To(BeTrue())
+
+					mux.Lock()
+					defer mux.Unlock()
+
+					deliveries[host] = d
+
+					return nil
+				},
+			}
+		})
+
+		// n0 and n2 are connected only through n1
+		Expect(nodes[0].peerBuffer.RemovePeer("n2")).To(BeTrue())
+		Expect(nodes[2].peerBuffer.RemovePeer("n0")).To(BeTrue())
+
+		Expect(nodes[0].AddMessage(context.Background(), "message", "my-callback")).To(Succeed())
+
+		delivery := func(host string) func() Delivery {
+			return func() Delivery {
+				mux.Lock()
+				defer mux.Unlock()
+
+				return deliveries[host]
+			}
+		}
+
+		Eventually(delivery("n2")).ShouldNot(BeZero())
+
+		origin := delivery("n0")()
+		Expect(origin.Msg).To(Equal("message"))
+		Expect(origin.Origin).To(Equal("n0"))
+		Expect(origin.Hops).To(BeZero())
+
+		d := delivery("n2")()
+		Expect(d.ID).To(Equal(origin.ID))
+		Expect(d.Msg).To(Equal("message"))
+		Expect(d.CallbackType).To(Equal("my-callback"))
+		Expect(d.Origin).To(Equal("n0"))
+		Expect(d.OriginTime).To(BeTemporally("~", origin.OriginTime))
+		Expect(d.Latency).To(BeNumerically(">", 0))
+		Expect(d.Hops).To(Equal(2))
+		Expect(delivery("n1")().Hops).To(Equal(1))
+
+		Expect(metrics.observed(MetricDeliveryLatency)).To(Equal(2))
+		Expect(metrics.observed(MetricDeliveryHops)).To(Equal(2))
+	})
+})
@@ -0,0 +1,160 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+const (
+	createCipherErrFmt   = "error at creating cipher for encryption key %q: %w"
+	encryptElementErrFmt = "error at encrypting the element: %w"
+	decryptElementErrFmt = "error at decrypting the element %s: %w"
+)
+
+var (
+	errMissingEncryptionKey = errors.New("encryption key ID must be one of the encryption keys")
+	errUnknownEncryptionKey = errors.New("unknown encryption key")
+	errInvalidCiphertext    = errors.New("invalid ciphertext")
+)
+
+// cipherSet encrypts user messages with AES-GCM.
+type cipherSet struct {
+	aeads map[string]cipher.AEAD
+	keyID string
+}
+
+// newCipherSet creates a cipher set. It returns nil if there are no keys.
+func newCipherSet(keys map[string][]byte, keyID string) (*cipherSet, error) {
+	if len(keys) == 0 {
+		return nil, nil //nolint: nilnil
+	}
+
+	if _, ok := keys[keyID]; !ok {
+		return nil, errMissingEncryptionKey
+	}
+
+	c := &cipherSet{
+		aeads: map[string]cipher.AEAD{},
+		keyID: keyID,
+	}
+
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf(createCipherErrFmt, id, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf(createCipherErrFmt, id, err)
+		}
+
+		c.aeads[id] = aead
+	}
+
+	return c, nil
+}
+
+// additionalData returns the element data authenticated, but not encrypted, by the cipher.
+func additionalData(el buffer.Element) []byte {
+	return []byte(el.Origin + "\x00" + el.CallbackType)
+}
+
+// encrypt replaces the message of the element with its ciphertext.
+func (c *cipherSet) encrypt(el *buffer.Element) error {
+	plaintext, err := json.Marshal(el.Msg)
+	if err != nil {
+		return fmt.Errorf(encryptElementErrFmt, err)
+	}
+
+	aead := c.aeads[c.keyID]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf(encryptElementErrFmt, err)
+	}
+
+	// the nonce is prepended to the ciphertext
+	ciphertext := aead.Seal(nonce, nonce, plaintext, additionalData(*el))
+
+	if err := el.Seal(ciphertext, c.keyID); err != nil {
+		return fmt.Errorf(encryptElementErrFmt, err)
+	}
+
+	return nil
+}
+
+// decrypt returns a copy of the element with the decrypted message.
+func (c *cipherSet) decrypt(el buffer.Element) (buffer.Element, error) {
+	aead, ok := c.aeads[el.KeyID]
+	if !ok {
+		return el, fmt.Errorf(decryptElementErrFmt, el.ID, errUnknownEncryptionKey)
+	}
+
+	if len(el.Ciphertext) < aead.NonceSize() {
+		return el, fmt.Errorf(decryptElementErrFmt, el.ID, errInvalidCiphertext)
+	}
+
+	nonce, ciphertext := el.Ciphertext[:aead.NonceSize()], el.Ciphertext[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData(el))
+	if err != nil {
+		return el, fmt.Errorf(decryptElementErrFmt, el.ID, err)
+	}
+
+	var msg any
+
+	if err := json.Unmarshal(plaintext, &msg); err != nil {
+		return el, fmt.Errorf(decryptElementErrFmt, el.ID, err)
+	}
+
+	el.Msg = msg
+
+	return el, nil
+}
+
+// encryptElement encrypts the message of a new user element.
+// It does nothing if the host has no encryption keys.
+func (b *BMMC) encryptElement(el *buffer.Element) error {
+	if b.ciphers == nil || el.Internal {
+		return nil
+	}
+
+	return b.ciphers.encrypt(el)
+}
+
+// decryptElement returns a copy of the element with the decrypted message.
+// It returns the element if its message is not encrypted.
+func (b *BMMC) decryptElement(el buffer.Element) (buffer.Element, error) {
+	if !el.Encrypted() {
+		return el, nil
+	}
+
+	if b.ciphers == nil {
+		return el, fmt.Errorf(decryptElementErrFmt, el.ID, errUnknownEncryptionKey)
+	}
+
+	return b.ciphers.decrypt(el)
+}
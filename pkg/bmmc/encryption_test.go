@@ -0,0 +1,131 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"log/slog"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encrypted messages", func() {
+	var (
+		nodes     []*BMMC
+		delivered map[string][]any
+		mux       *sync.Mutex
+	)
+
+	deliveredFn := func(host string) func() []any {
+		return func() []any {
+			mux.Lock()
+			defer mux.Unlock()
+
+			return append([]any{}, delivered[host]...)
+		}
+	}
+
+	BeforeEach(func() {
+		delivered = map[string][]any{}
+		mux = &sync.Mutex{}
+
+		keys := map[string][]byte{
+			"key-1": []byte("0123456789abcdef0123456789abcdef"),
+			"key-2": []byte("fedcba9876543210"),
+		}
+
+		nodes = newTestCluster(3, func(cfg *Config) {
+			host := cfg.Host.String()
+
+			cfg.Callbacks = map[string]func(any, *slog.Logger) error{
+				"my-callback": func(data any, _ *slog.Logger) error {
+					el, ok := data.(Delivery)
+					Expect(ok).To(BeTrue())
+
+					mux.Lock()
+					defer mux.Unlock()
+
+					delivered[host] = append(delivered[host], el.Msg)
+
+					return nil
+				},
+			}
+
+			// the last node is a relay without keys
+			if host != "n2" {
+				cfg.EncryptionKeys = keys
+				cfg.EncryptionKeyID = map[string]string{"n0": "key-1", "n1": "key-2"}[host]
+			}
+		})
+	})
+
+	It("returns error when encryption key ID is not one of the encryption keys", func() {
+		_, err := New(&Config{
+			Host:            &fakeHost{},
+			BufferSize:      25,
+			EncryptionKeys:  map[string][]byte{"key-1": []byte("0123456789abcdef")},
+			EncryptionKeyID: "key-2",
+		})
+		Expect(err).To(MatchError(errMissingEncryptionKey))
+	})
+
+	It("returns error when encryption key is invalid", func() {
+		_, err := New(&Config{
+			Host:            &fakeHost{},
+			BufferSize:      25,
+			EncryptionKeys:  map[string][]byte{"key-1": []byte("short")},
+			EncryptionKeyID: "key-1",
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("delivers messages only to hosts with keys", func() {
+		Expect(nodes[0].AddMessage(context.Background(), "first-secret", "my-callback")).To(Succeed())
+		Expect(nodes[1].AddMessage(context.Background(), "second-secret", "my-callback")).To(Succeed())
+
+		for _, b := range nodes[:2] {
+			Eventually(b.GetMessages).Should(ConsistOf("first-secret", "second-secret"))
+			Eventually(deliveredFn(b.config.Host.String())).Should(ConsistOf("first-secret", "second-secret"))
+		}
+
+		// the relay stores and gossips the encrypted messages
+		Eventually(nodes[2].messageBuffer.Length).Should(Equal(2))
+		Expect(nodes[2].GetMessages()).To(BeEmpty())
+		Expect(deliveredFn("n2")()).To(BeEmpty())
+
+		for _, el := range nodes[2].messageBuffer.UserElements() {
+			Expect(el.Encrypted()).To(BeTrue())
+			Expect(el.Msg).To(BeNil())
+		}
+	})
+
+	It("encrypts signed messages", func() {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		nodes[0].config.PrivateKey = priv
+		nodes[1].config.TrustedKeys = StaticKeys{"n0": pub}
+
+		Expect(nodes[0].AddMessage(context.Background(), "signed-secret", NOCALLBACK)).To(Succeed())
+
+		Eventually(nodes[1].GetMessages).Should(ConsistOf("signed-secret"))
+		Expect(nodes[1].Stats().RejectedElements).To(BeZero())
+	})
+})
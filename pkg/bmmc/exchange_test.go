@@ -0,0 +1,138 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+// newTestCluster creates and starts a cluster of nodes connected through an
+// in-memory network. The given function can customize the config of each node.
+func newTestCluster(size int, customize func(*Config)) []*BMMC {
+	network := memory.NewNetwork()
+	nodes := make([]*BMMC, size)
+
+	for i := range nodes {
+		cfg := &Config{
+			Host:          network.Peer(fmt.Sprintf("n%d", i)),
+			BufferSize:    64,
+			RoundDuration: 10 * time.Millisecond,
+			Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+
+		if customize != nil {
+			customize(cfg)
+		}
+
+		b, err := New(cfg)
+		Expect(err).ToNot(HaveOccurred())
+
+		network.Register(cfg.Host.String(), b.Handle)
+
+		nodes[i] = b
+	}
+
+	for i := range nodes {
+		for j := range nodes {
+			if i != j {
+				Expect(nodes[i].peerBuffer.AddPeer(nodes[j].config.Host.String())).To(BeTrue())
+			}
+		}
+
+		Expect(nodes[i].Start()).To(Succeed())
+	}
+
+	DeferCleanup(func() {
+		for _, b := range nodes {
+			b.Stop()
+		}
+	})
+
+	return nodes
+}
+
+var _ = Describe("Exchange modes", func() {
+	It("returns error when host cannot send requests", func() {
+		_, err := New(&Config{
+			Host:       &fakeHost{},
+			BufferSize: 25,
+			Exchange:   SolicitationExchange,
+		})
+		Expect(err).To(MatchError(errHostCannotRequest))
+	})
+
+	It("returns error for invalid exchange modes", func() {
+		_, err := New(&Config{
+			Host:       &fakeHost{},
+			BufferSize: 25,
+			Exchange:   ExchangeMode(100),
+		})
+		Expect(err).To(MatchError(errInvalidExchangeMode))
+	})
+
+	DescribeTable("synchronizes all nodes", func(mode ExchangeMode) {
+		nodes := newTestCluster(3, func(cfg *Config) {
+			cfg.Exchange = mode
+		})
+
+		Expect(nodes[0].AddMessage(context.Background(), "first-message", NOCALLBACK)).To(Succeed())
+		Expect(nodes[2].AddMessage(context.Background(), "second-message", NOCALLBACK)).To(Succeed())
+
+		for _, b := range nodes {
+			Eventually(b.GetMessages).Should(ConsistOf("first-message", "second-message"))
+		}
+	},
+		Entry("async exchange", AsyncExchange),
+		Entry("solicitation exchange", SolicitationExchange),
+		Entry("gossip exchange", GossipExchange),
+	)
+
+	It("rejects the solicitation replies sent by other hosts than the gossiped peer", func() {
+		network := memory.NewNetwork()
+
+		b, err := New(&Config{
+			Host:       network.Peer("n0"),
+			BufferSize: 25,
+			Exchange:   GossipExchange,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.AddMessage(context.Background(), "msg", NOCALLBACK)).To(Succeed())
+
+		body, err := b.marshalSolicitation(Solicitation{Host: "n2", Digest: b.messageBuffer.Digest()})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = b.handleSolicitationReply(body, "n1")
+		Expect(err).To(MatchError(ErrRejectedSender))
+		Expect(b.Stats().RejectedSenders).To(Equal(uint64(1)))
+
+		body, err = b.marshalSolicitation(Solicitation{Host: "n1", Digest: b.messageBuffer.Digest()})
+		Expect(err).ToNot(HaveOccurred())
+
+		reply, err := b.handleSolicitationReply(body, "n1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reply).ToNot(BeEmpty())
+	})
+})
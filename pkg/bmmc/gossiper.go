@@ -22,9 +22,14 @@ import (
 
 // gossipLen is number of nodes which will receive gossip message.
 // It will be 0 if the node has empty peers buffer or if the node has
-// empty message buffer.
+// empty message buffer. The node still gossips after stable messages were
+// removed from its buffer, so that all members learn they are stable.
 func (b *BMMC) computeGossipLen() int {
-	if b.peerBuffer.Length() == 0 || b.messageBuffer.Length() == 0 || b.config.Beta == 0 {
+	if b.peerBuffer.Length() == 0 || b.config.Beta == 0 {
+		return 0
+	}
+
+	if b.messageBuffer.Length() == 0 && b.stability.evicted.len() == 0 {
 		return 0
 	}
 
@@ -39,18 +44,38 @@ func (b *BMMC) round(stop <-chan struct{}) {
 
 			return
 		default:
+			start := time.Now()
+
 			b.gossipRound.Increment()
 
+			b.config.Observer.OnRoundStart(b.gossipRound.GetNumber())
+
 			gossipLen := b.computeGossipLen()
 
 			randomlySelectedPeers := b.peerBuffer.GetRandomPeers(gossipLen)
 
+			digest := b.messageBuffer.Digest()
+			lamport, lastSeq := b.sequencer.mark()
+			received, epochs := b.stability.summary()
+
+			// forget the elements removed from buffer
+			b.coverageTracker.retain(digest)
+			b.acks.retain(b.messageBuffer.Digest, start)
+			b.retractions.retain(b.messageBuffer.Digest, start)
+			b.superseded.expire(start)
+
 			// send gossip messages
 			for _, p := range randomlySelectedPeers {
 				gossipMsg := Gossip{
 					Host:        b.config.Host.String(),
 					RoundNumber: b.gossipRound,
-					Digest:      b.messageBuffer.Digest(),
+					Digest:      digest,
+					Coverage:    b.coverageTracker.summary(digest),
+					Lamport:     lamport,
+					LastSeq:     lastSeq,
+					Epoch:       b.sequencer.epoch,
+					Received:    received,
+					Epochs:      epochs,
 				}
 
 				b.sendGossip(gossipMsg, p) //nolint: errcheck
@@ -58,6 +83,12 @@ func (b *BMMC) round(stop <-chan struct{}) {
 
 			(*b.messageBuffer).IncrementGossipCount()
 
+			b.releaseHeldBack()
+			b.releaseOrderedBack()
+			b.updateStability()
+
+			b.observeRound(start)
+
 			time.Sleep(b.config.RoundDuration)
 		}
 	}
@@ -17,6 +17,8 @@ limitations under the License.
 package bmmc
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
@@ -45,6 +47,7 @@ var _ = Describe("Gossiper", func() {
 				config: &Config{
 					Beta: 0.5,
 				},
+				stability: newStabilityTracker(time.Second),
 			}
 		})
 
@@ -58,6 +61,12 @@ var _ = Describe("Gossiper", func() {
 			Expect(b.computeGossipLen()).To(Equal(0))
 		})
 
+		It("returns proper gossip len if stable messages were evicted from messageBuffer", func() {
+			b.messageBuffer = buffer.NewBuffer(25)
+			b.stability.evicted.add(time.Now(), "id")
+			Expect(b.computeGossipLen()).To(Equal(1))
+		})
+
 		It("returns 0 if beta is 0", func() {
 			b.config.Beta = 0
 			Expect(b.computeGossipLen()).To(Equal(0))
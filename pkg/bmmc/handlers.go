@@ -17,6 +17,11 @@ limitations under the License.
 package bmmc
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
 )
 
@@ -27,16 +32,109 @@ const (
 	SolicitationRoute = "/solicitation"
 	// SynchronizationRoute is the route for synchronization messages.
 	SynchronizationRoute = "/synchronization"
+	// AckRoute is the route for acknowledgement messages of broadcast messages.
+	AckRoute = "/ack"
+
+	unknownRouteErrFmt   = "%w: %s"
+	rejectedSenderErrFmt = "%w: %q"
+)
+
+var (
+	// ErrUnknownRoute is returned by Handle when the given route is not a protocol route.
+	ErrUnknownRoute = errors.New("unknown route")
+	// ErrDecode is returned by Handle when the message body cannot be decoded.
+	ErrDecode = errors.New("cannot decode message")
+	// ErrRejectedSender is returned by Handle when the sender of the message is rejected.
+	ErrRejectedSender = errors.New("rejected sender")
 )
 
+// Handle handles a message received by the host server on the given route.
+// It returns the response body that should be sent back to the sender
+// (nil when there is nothing to reply) and an error that wraps one of
+// ErrUnknownRoute, ErrDecode, ErrLimitExceeded, ErrUnauthenticated or
+// ErrRejectedSender when the message is rejected.
+func (b *BMMC) Handle(ctx context.Context, route string, body []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	if err := b.checkBody(body); err != nil {
+		return nil, err
+	}
+
+	switch route {
+	case GossipRoute:
+		return b.handleGossip(ctx, body)
+	case SolicitationRoute:
+		return b.handleSolicitation(ctx, body)
+	case SynchronizationRoute:
+		return b.handleSynchronization(ctx, body)
+	case AckRoute:
+		return b.handleAck(ctx, body)
+	default:
+		return nil, fmt.Errorf(unknownRouteErrFmt, ErrUnknownRoute, route)
+	}
+}
+
 // GossipHandler handles a gossip message.
+// Use Handle to find out if the message was rejected.
 func (b *BMMC) GossipHandler(body []byte) {
-	gossipDigest, p, roundNumber, err := b.receiveGossip(body)
+	b.Handle(context.Background(), GossipRoute, body) //nolint: errcheck
+}
+
+// SolicitationHandler handles a solicitation message.
+// Use Handle to find out if the message was rejected.
+func (b *BMMC) SolicitationHandler(body []byte) {
+	b.Handle(context.Background(), SolicitationRoute, body) //nolint: errcheck
+}
+
+// SynchronizationHandler handles a synchronization message.
+// Use Handle to find out if the message was rejected.
+func (b *BMMC) SynchronizationHandler(body []byte) {
+	b.Handle(context.Background(), SynchronizationRoute, body) //nolint: errcheck
+}
+
+// validateSender validates the host of a received message.
+// The host is used as destination for replies, so it must not be empty,
+// must not be the host itself and must pass the configured sender checks.
+func (b *BMMC) validateSender(ctx context.Context, host string) error {
+	if host == "" || host == b.config.Host.String() || !b.checkSender(ctx, host) {
+		b.stats.rejectedSenders.Add(1)
+		b.config.Logger.Debug("rejected message from sender", "sender", host)
+
+		return fmt.Errorf(rejectedSenderErrFmt, ErrRejectedSender, host)
+	}
+
+	return nil
+}
+
+func (b *BMMC) handleGossip(ctx context.Context, body []byte) ([]byte, error) {
+	gossip, err := b.receiveGossip(body)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	digest := b.messageBuffer.Digest()
+	gossipDigest, p, roundNumber := gossip.Digest, gossip.Host, gossip.RoundNumber
+
+	if err = b.validateSender(ctx, p); err != nil {
+		return nil, err
+	}
+
+	b.incCounter(MetricGossipsReceived)
+	b.config.Observer.OnGossipReceived(p, len(gossipDigest))
+
+	b.mergeCoverage(gossip)
+	b.reportOrder(gossip)
+
+	// only the members are waited for, so the summaries of other senders are not kept
+	if b.peerBuffer.Contains(p) {
+		b.stability.report(p, gossip.Received, gossip.Epochs)
+	}
+
+	// the stable, the retracted and the superseded elements removed from buffer are not solicited again
+	digest := append(b.messageBuffer.Digest(), b.stability.evicted.list()...)
+	digest = append(digest, b.retractions.ids()...)
+	digest = append(digest, b.superseded.list()...)
 	missingDigest := buffer.MissingStrings(gossipDigest, digest)
 
 	if len(missingDigest) > 0 {
@@ -46,46 +144,191 @@ func (b *BMMC) GossipHandler(body []byte) {
 			Digest:      missingDigest,
 		}
 
+		b.config.Observer.OnSolicitationSent(p, len(missingDigest))
+
+		if b.config.Exchange == GossipExchange {
+			// reply with the solicitation message
+			return b.marshalSolicitation(solicitationMsg)
+		}
+
 		if err = b.sendSolicitation(solicitationMsg, p); err != nil {
-			return
+			return nil, err
 		}
 	}
+
+	return nil, nil
 }
 
-// SolicitationHandler handles a solicitation message.
-func (b *BMMC) SolicitationHandler(body []byte) {
-	missingDigest, p, _, err := b.receiveSolicitation(body)
+func (b *BMMC) handleSolicitation(ctx context.Context, body []byte) ([]byte, error) {
+	solicitation, err := b.receiveSolicitation(body)
 	if err != nil {
-		return
+		return nil, err
 	}
 
+	return b.replySolicitation(ctx, solicitation)
+}
+
+// replySolicitation sends the elements requested by the given solicitation
+// message to its host, or returns the synchronization message as reply.
+func (b *BMMC) replySolicitation(ctx context.Context, solicitation Solicitation) ([]byte, error) {
+	missingDigest, p := solicitation.Digest, solicitation.Host
+
+	if err := b.validateSender(ctx, p); err != nil {
+		return nil, err
+	}
+
+	b.incCounter(MetricSolicitationsReceived)
+	b.config.Observer.OnSolicitationReceived(p, len(missingDigest)+len(solicitation.Sequences))
+
 	missingElements := b.messageBuffer.ElementsFromIDs(missingDigest)
+	missingElements = append(missingElements, b.messageBuffer.ElementsFromSequences(solicitation.Sequences)...)
+	missingElements = b.limitSynchronization(p, missingElements)
+
+	b.traceSolicitation(ctx, missingElements, p)
+
+	b.config.Observer.OnSynchronizationSent(p, len(missingElements))
 
 	synchronizationMsg := Synchronization{
 		Host:     b.config.Host.String(),
 		Elements: missingElements,
 	}
 
-	if err = b.sendSynchronization(synchronizationMsg, p); err != nil {
-		return
+	if b.config.Exchange != AsyncExchange {
+		// reply with the synchronization message
+		return b.marshalSynchronization(synchronizationMsg)
+	}
+
+	if err := b.sendSynchronization(synchronizationMsg, p); err != nil {
+		return nil, err
 	}
+
+	return nil, nil
 }
 
-// SynchronizationHandler handles a synchronization message.
-func (b *BMMC) SynchronizationHandler(body []byte) {
-	rcvElements, _, err := b.receiveSynchronization(body)
+func (b *BMMC) handleSynchronization(ctx context.Context, body []byte) ([]byte, error) {
+	rcvElements, p, err := b.receiveSynchronization(body)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	for _, m := range rcvElements {
-		err = b.messageBuffer.Add(m)
-		if err != nil {
-			b.config.Logger.Error("failed to sync buffer with message", "err", err, "msg", m.Msg)
-		} else {
-			b.config.Logger.Debug("buffer successfully synced with message", "msg", m.Msg)
+	if err = b.validateSender(ctx, p); err != nil {
+		return nil, err
+	}
+
+	b.config.Observer.OnSynchronizationReceived(p, len(rcvElements))
 
-			b.runCallbacks(m)
+	// the received elements are acknowledged to their origins, if requested
+	acks := map[string][]string{}
+
+	for _, m := range rcvElements {
+		if b.synchronizeElement(ctx, m, p) && m.Headers[ackHeader] != "" && m.Origin != "" {
+			acks[m.Origin] = append(acks[m.Origin], m.ID)
 		}
 	}
+
+	b.ackElements(acks)
+
+	return nil, nil
+}
+
+func (b *BMMC) handleAck(ctx context.Context, body []byte) ([]byte, error) {
+	ack, err := b.receiveAck(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = b.validateSender(ctx, ack.Host); err != nil {
+		return nil, err
+	}
+
+	// only the members are counted as replicas of the broadcast messages
+	if b.peerBuffer.Contains(ack.Host) {
+		b.acks.ack(ack.Host, ack.IDs)
+	}
+
+	return nil, nil
+}
+
+// synchronizeElement adds an element received from the given peer in buffer
+// and returns true if the element was not received before.
+// The span of the element is child of the span context from the element headers.
+func (b *BMMC) synchronizeElement(ctx context.Context, m buffer.Element, p string) bool {
+	// the element was forwarded by the peer
+	m.Hops++
+
+	ctx, span := b.startElementSpan(b.config.Tracer.Extract(ctx, m.Headers), SpanSynchronization, m)
+	defer span.End()
+
+	span.SetAttribute("bmmc.peer", p)
+
+	if err := b.acceptElement(m); err != nil {
+		b.stats.rejectedElements.Add(1)
+		b.config.Logger.Error("rejected element", "err", err, "id", m.ID)
+		span.RecordError(err)
+
+		return false
+	}
+
+	b.coverageTracker.add(m.ID, p)
+
+	if b.retractions.retracts(m) {
+		b.config.Logger.Debug("ignored retracted element", "id", m.ID)
+
+		return false
+	}
+
+	if b.receivedBefore(m) {
+		// the element was received and removed from buffer
+		return false
+	}
+
+	if b.superseded.has(m.ID) {
+		// the older version of the keyed element was removed from buffer
+		return false
+	}
+
+	err := b.addElement(m)
+	if errors.Is(err, buffer.ErrDuplicateElement) {
+		// the element was already received from another peer
+		return false
+	}
+
+	if errors.Is(err, buffer.ErrStaleElement) {
+		b.config.Logger.Debug("ignored stale element", "id", m.ID, "key", m.Key)
+
+		// the stale element is passed to the delivery order without running
+		// its callback, so the next messages of its origin are not held back
+		b.superseded.add(time.Now(), m.ID)
+		b.recordReceived(m)
+		b.deliver(ctx, m, p)
+
+		return false
+	}
+
+	if err != nil {
+		b.config.Logger.Error("failed to sync buffer with message", "err", err, "msg", m.Msg)
+		span.RecordError(err)
+
+		return false
+	}
+
+	b.config.Logger.Debug("buffer successfully synced with message", "msg", m.Msg)
+
+	b.recordReceived(m)
+
+	b.incCounter(MetricSynchronizedElements)
+	b.observeDelivery(m)
+
+	b.deliver(ctx, m, p)
+
+	return true
+}
+
+// acceptElement returns an error if a received element must not be added in buffer.
+func (b *BMMC) acceptElement(el buffer.Element) error {
+	if err := b.verifyElement(el); err != nil {
+		return err
+	}
+
+	return b.allowMembershipElement(el)
 }
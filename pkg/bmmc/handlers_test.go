@@ -0,0 +1,90 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeHost struct{}
+
+func (h *fakeHost) String() string {
+	return "localhost:19999"
+}
+
+func (h *fakeHost) Send(_ []byte, _ string, _ string) error {
+	return nil
+}
+
+var _ = Describe("Handlers", func() {
+	Describe("Handle function", func() {
+		var b *BMMC
+
+		BeforeEach(func() {
+			var err error
+
+			b, err = New(&Config{
+				Host:       &fakeHost{},
+				BufferSize: 25,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error for unknown routes", func() {
+			_, err := b.Handle(context.Background(), "/unknown", []byte(`{}`))
+			Expect(err).To(MatchError(ErrUnknownRoute))
+		})
+
+		DescribeTable("returns error when message cannot be decoded", func(route string) {
+			_, err := b.Handle(context.Background(), route, []byte(`{"host":`))
+			Expect(err).To(MatchError(ErrDecode))
+		},
+			Entry("gossip", GossipRoute),
+			Entry("solicitation", SolicitationRoute),
+			Entry("synchronization", SynchronizationRoute),
+		)
+
+		DescribeTable("returns error when sender is rejected", func(route, body string) {
+			_, err := b.Handle(context.Background(), route, []byte(body))
+			Expect(err).To(MatchError(ErrRejectedSender))
+		},
+			Entry("gossip without host", GossipRoute, `{"digest":["id"]}`),
+			Entry("solicitation without host", SolicitationRoute, `{"digest":["id"]}`),
+			Entry("synchronization from itself", SynchronizationRoute, `{"host":"localhost:19999"}`),
+		)
+
+		It("returns error when context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := b.Handle(ctx, GossipRoute, []byte(`{"host":"localhost:29999"}`))
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		It("adds received elements in buffer", func() {
+			body := `{"host":"localhost:29999","elements":[{"id":"my-id","msg":"my message","callbackType":"no-callback"}]}`
+
+			resp, err := b.Handle(context.Background(), SynchronizationRoute, []byte(body))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp).To(BeNil())
+			Expect(b.GetMessages()).To(ConsistOf("my message"))
+		})
+	})
+})
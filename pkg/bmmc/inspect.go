@@ -0,0 +1,212 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSendErrors is the number of recent send errors kept for inspection.
+const maxSendErrors = 32
+
+// Inspection is a snapshot of the protocol state, used for debugging.
+type Inspection struct {
+	Host       string
+	Round      int64
+	Elements   []ElementState
+	Peers      []string
+	Config     ConfigState
+	Stats      Stats
+	SendErrors []SendError
+	// Pending are the received messages held back by the delivery order.
+	Pending []PendingMessage
+	// Outbound is the number of messages being sent, per route.
+	Outbound map[string]int64
+	// StableFrontier is the sequence number up to which the messages from
+	// each origin are known to be received by all members.
+	StableFrontier map[string]uint64
+}
+
+// ElementState is the state of an element from the messages buffer.
+type ElementState struct {
+	ElementInfo
+
+	Key         string
+	GossipCount int64
+	Hops        int
+	Encrypted   bool
+	Stable      bool
+}
+
+// ConfigState is the config of the protocol, without secrets.
+type ConfigState struct {
+	Beta               float64
+	RoundDuration      time.Duration
+	BufferSize         int
+	EvictStable        bool
+	Exchange           ExchangeMode
+	Delivery           DeliveryOrder
+	Limits             Limits
+	SyncRateLimit      int
+	SyncBurst          int
+	MaxMessageAge      time.Duration
+	RequireKnownSender bool
+	Signing            bool
+	Authentication     bool
+	Encryption         bool
+	MembershipPolicy   bool
+}
+
+// SendError is a message that could not be sent to a peer.
+type SendError struct {
+	Time  time.Time
+	Peer  string
+	Route string
+	Err   string
+}
+
+// sendErrorLog keeps the most recent send errors.
+type sendErrorLog struct {
+	mux    *sync.Mutex
+	errors []SendError
+	next   int
+}
+
+func newSendErrorLog() *sendErrorLog {
+	return &sendErrorLog{
+		mux:    &sync.Mutex{},
+		errors: make([]SendError, 0, maxSendErrors),
+	}
+}
+
+// add adds a send error, replacing the oldest one when the log is full.
+func (l *sendErrorLog) add(sendErr SendError) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if len(l.errors) < maxSendErrors {
+		l.errors = append(l.errors, sendErr)
+
+		return
+	}
+
+	l.errors[l.next] = sendErr
+	l.next = (l.next + 1) % maxSendErrors
+}
+
+// recent returns the send errors, from the oldest to the newest.
+func (l *sendErrorLog) recent() []SendError {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	recent := make([]SendError, 0, len(l.errors))
+	recent = append(recent, l.errors[l.next:]...)
+	recent = append(recent, l.errors[:l.next]...)
+
+	return recent
+}
+
+// outboundTracker counts the messages being sent, per route.
+type outboundTracker struct {
+	mux    *sync.Mutex
+	counts map[string]int64
+}
+
+func newOutboundTracker() *outboundTracker {
+	return &outboundTracker{
+		mux:    &sync.Mutex{},
+		counts: map[string]int64{},
+	}
+}
+
+// begin marks a message as being sent on the given route.
+// The returned function must be called when the message was sent.
+func (t *outboundTracker) begin(route string) func() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.counts[route]++
+
+	return func() {
+		t.mux.Lock()
+		defer t.mux.Unlock()
+
+		t.counts[route]--
+	}
+}
+
+// snapshot returns the number of messages being sent, per route.
+func (t *outboundTracker) snapshot() map[string]int64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	counts := make(map[string]int64, len(t.counts))
+
+	for route, count := range t.counts {
+		counts[route] = count
+	}
+
+	return counts
+}
+
+// Inspect returns a snapshot of the protocol state.
+func (b *BMMC) Inspect() Inspection {
+	elements := b.messageBuffer.AllElements()
+	states := make([]ElementState, len(elements))
+
+	for i, el := range elements {
+		states[i] = ElementState{
+			ElementInfo: elementInfo(el),
+			Key:         el.Key,
+			GossipCount: el.GossipCount,
+			Hops:        el.Hops,
+			Encrypted:   el.Encrypted(),
+			Stable:      b.stability.isStable(el.Origin, el.Epoch, el.Seq),
+		}
+	}
+
+	return Inspection{
+		Host:     b.config.Host.String(),
+		Round:    b.gossipRound.GetNumber(),
+		Elements: states,
+		Peers:    b.peerBuffer.GetPeers(),
+		Config: ConfigState{
+			Beta:               b.config.Beta,
+			RoundDuration:      b.config.RoundDuration,
+			BufferSize:         b.config.BufferSize,
+			EvictStable:        b.config.EvictStable,
+			Exchange:           b.config.Exchange,
+			Delivery:           b.config.Delivery,
+			Limits:             b.config.Limits,
+			SyncRateLimit:      b.config.SyncRateLimit,
+			SyncBurst:          b.config.SyncBurst,
+			MaxMessageAge:      b.config.MaxMessageAge,
+			RequireKnownSender: b.config.RequireKnownSender,
+			Signing:            b.config.PrivateKey != nil,
+			Authentication:     b.auth != nil,
+			Encryption:         b.ciphers != nil,
+			MembershipPolicy:   b.config.MembershipPolicy != nil,
+		},
+		Stats:      b.Stats(),
+		SendErrors: b.sendErrors.recent(),
+		Pending:    b.Pending(),
+		Outbound:   b.outbound.snapshot(),
+
+		StableFrontier: b.StableFrontier(),
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+const (
+	addKeyedMessageErrFmt = "error at adding the message with key %q: %w"
+
+	// defaultSupersededRounds is the default number of gossip rounds during which
+	// the IDs of the superseded elements removed from buffer are remembered.
+	defaultSupersededRounds = 10
+)
+
+var (
+	// ErrStaleMessage is returned by AddKeyedMessage when the buffer already
+	// contains a newer version of the message with the same key.
+	ErrStaleMessage = errors.New("a newer version of the message exists")
+
+	errEmptyMessageKey = errors.New("message key must not be empty")
+)
+
+// AddKeyedMessage adds new version of the message with given key in messages buffer.
+// The newer version supersedes the older ones, by timestamp and then by origin:
+// the older versions are removed from the buffers and are not gossiped anymore,
+// and the versions received after a newer one are ignored instead of delivered.
+func (b *BMMC) AddKeyedMessage(ctx context.Context, key string, msg any, callbackType string) error {
+	if key == "" {
+		return fmt.Errorf(addKeyedMessageErrFmt, key, errEmptyMessageKey)
+	}
+
+	_, err := b.addMessage(ctx, userMessage{msg: msg, callbackType: callbackType, key: key})
+	if errors.Is(err, buffer.ErrStaleElement) {
+		return fmt.Errorf(addKeyedMessageErrFmt, key, ErrStaleMessage)
+	}
+
+	return err
+}
+
+// supersededElement returns true if the given element is an older version of
+// a keyed element from buffer.
+func (b *BMMC) supersededElement(el buffer.Element) bool {
+	return el.Key != "" && (b.superseded.has(el.ID) || b.messageBuffer.IsSuperseded(el))
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Keyed messages", func() {
+	var (
+		nodes      []*BMMC
+		deliveries map[string][]any
+		mux        *sync.Mutex
+	)
+
+	BeforeEach(func() {
+		clusterDeliveries, clusterMux := map[string][]any{}, &sync.Mutex{}
+		deliveries, mux = clusterDeliveries, clusterMux
+
+		nodes = newTestCluster(3, func(cfg *Config) {
+			host := cfg.Host.String()
+
+			cfg.Callbacks = map[string]func(any, *slog.Logger) error{
+				"config": func(data any, _ *slog.Logger) error {
+					clusterMux.Lock()
+					defer clusterMux.Unlock()
+
+					clusterDeliveries[host] = append(clusterDeliveries[host], data.(Delivery).Msg)
+
+					return nil
+				},
+			}
+		})
+	})
+
+	deliveriesOf := func(host string) []any {
+		mux.Lock()
+		defer mux.Unlock()
+
+		return append([]any{}, deliveries[host]...)
+	}
+
+	It("replaces the older version on all hosts", func() {
+		Expect(nodes[0].AddKeyedMessage(context.Background(), "color", "red", "config")).To(Succeed())
+
+		for _, b := range nodes {
+			Eventually(b.GetMessages).Should(ConsistOf("red"))
+		}
+
+		Expect(nodes[1].AddKeyedMessage(context.Background(), "color", "blue", "config")).To(Succeed())
+
+		for _, b := range nodes {
+			host := b.config.Host.String()
+
+			Eventually(b.GetMessages).Should(ConsistOf("blue"))
+			Eventually(func() []any { return deliveriesOf(host) }).Should(Equal([]any{"red", "blue"}))
+		}
+	})
+
+	It("keeps the messages with different keys", func() {
+		Expect(nodes[0].AddKeyedMessage(context.Background(), "color", "red", "config")).To(Succeed())
+		Expect(nodes[0].AddKeyedMessage(context.Background(), "size", "big", "config")).To(Succeed())
+
+		for _, b := range nodes {
+			Eventually(b.GetMessages).Should(ConsistOf("red", "big"))
+		}
+	})
+
+	It("ignores the stale versions received after a newer one", func() {
+		older, err := nodes[0].newUserElement(userMessage{msg: "red", callbackType: "config", key: "color"}, elementOrder{})
+		Expect(err).ToNot(HaveOccurred())
+
+		newer, err := nodes[0].newUserElement(userMessage{msg: "blue", callbackType: "config", key: "color"}, elementOrder{})
+		Expect(err).ToNot(HaveOccurred())
+
+		older.Timestamp = newer.Timestamp.Add(-time.Second)
+
+		Expect(nodes[1].synchronizeElement(context.Background(), newer, "n0")).To(BeTrue())
+		Expect(nodes[1].synchronizeElement(context.Background(), older, "n0")).To(BeFalse())
+
+		Expect(nodes[1].GetMessages()).To(ConsistOf("blue"))
+		Expect(deliveriesOf("n1")).To(Equal([]any{"blue"}))
+		Expect(nodes[1].superseded.has(older.ID)).To(BeTrue())
+	})
+
+	It("returns error when a newer version exists", func() {
+		newer, err := nodes[1].newUserElement(userMessage{msg: "blue", callbackType: "config", key: "color"}, elementOrder{})
+		Expect(err).ToNot(HaveOccurred())
+
+		newer.Timestamp = newer.Timestamp.Add(time.Hour)
+
+		Expect(nodes[0].synchronizeElement(context.Background(), newer, "n1")).To(BeTrue())
+
+		err = nodes[0].AddKeyedMessage(context.Background(), "color", "red", "config")
+		Expect(err).To(MatchError(ErrStaleMessage))
+	})
+
+	It("returns error when the key is empty", func() {
+		Expect(nodes[0].AddKeyedMessage(context.Background(), "", "red", "config")).To(MatchError(errEmptyMessageKey))
+	})
+})
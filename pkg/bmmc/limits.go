@@ -0,0 +1,281 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+const (
+	limitExceededErrFmt = "%w: %s has %d, limit is %d"
+	boundedDecodeErrFmt = "error at decoding %s of received message: %w: %w"
+	jsonTokenErrFmt     = "%w: %v"
+)
+
+var (
+	// ErrLimitExceeded is returned by Handle when a received message exceeds the configured limits.
+	ErrLimitExceeded = errors.New("message limit exceeded")
+
+	errUnexpectedJSONToken = errors.New("unexpected JSON token")
+)
+
+// Limits are the limits of received messages.
+// They are enforced before the messages are processed. A zero value means no limit.
+type Limits struct {
+	// MaxBodyBytes is the maximum size of a received message body.
+	MaxBodyBytes int
+	// MaxDigestLen is the maximum number of IDs in the digest of
+	// gossip and solicitation messages and in ack messages, of coverage and
+	// stability entries in gossip messages and of sequence numbers in
+	// solicitation messages.
+	MaxDigestLen int
+	// MaxSyncElements is the maximum number of elements in a synchronization message.
+	MaxSyncElements int
+	// MaxElementBytes is the maximum encoded size of an element from a synchronization message.
+	MaxElementBytes int
+	// MaxCallbackTypeLen is the maximum length of the callback type of an element.
+	MaxCallbackTypeLen int
+}
+
+// checkLimit returns an error if the given value exceeds the given limit.
+func (b *BMMC) checkLimit(what string, value, limit int) error {
+	if limit <= 0 || value <= limit {
+		return nil
+	}
+
+	b.stats.rejectedMessages.Add(1)
+	b.config.Logger.Error("received message exceeds limit", "limit", what, "value", value, "max", limit)
+
+	return fmt.Errorf(limitExceededErrFmt, ErrLimitExceeded, what, value, limit)
+}
+
+// checkBody returns an error if the received body is too large.
+func (b *BMMC) checkBody(body []byte) error {
+	return b.checkLimit("body bytes", len(body), b.config.Limits.MaxBodyBytes)
+}
+
+// digestBudget returns the budget of IDs in the digest of
+// gossip and solicitation messages and in ack messages.
+func (b *BMMC) digestBudget() *itemBudget {
+	return &itemBudget{what: "digest length", limit: b.config.Limits.MaxDigestLen}
+}
+
+// coverageBudget returns the budget of coverage entries in gossip messages.
+// The number of holders of each entry is bounded by MaxBodyBytes.
+func (b *BMMC) coverageBudget() *itemBudget {
+	return &itemBudget{what: "coverage entries", limit: b.config.Limits.MaxDigestLen}
+}
+
+// stabilityBudget returns the budget of origins in the stability summary of gossip messages.
+func (b *BMMC) stabilityBudget() *itemBudget {
+	return &itemBudget{what: "stability entries", limit: b.config.Limits.MaxDigestLen}
+}
+
+// sequencesBudget returns the budget of sequence numbers in solicitation messages.
+func (b *BMMC) sequencesBudget() *itemBudget {
+	return &itemBudget{what: "sequence numbers", limit: b.config.Limits.MaxDigestLen}
+}
+
+// syncElementsBudget returns the budget of elements in synchronization messages.
+func (b *BMMC) syncElementsBudget() *itemBudget {
+	return &itemBudget{what: "synchronization elements", limit: b.config.Limits.MaxSyncElements}
+}
+
+// decodeElements decodes the elements of a synchronization message,
+// checking the limits before each element is decoded.
+func (b *BMMC) decodeElements(rawElements []json.RawMessage) ([]buffer.Element, error) {
+	limits := b.config.Limits
+
+	elements := make([]buffer.Element, len(rawElements))
+
+	for i, raw := range rawElements {
+		if err := b.checkLimit("element bytes", len(raw), limits.MaxElementBytes); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(raw, &elements[i]); err != nil {
+			b.stats.rejectedMessages.Add(1)
+
+			return nil, fmt.Errorf(synchronizationDecodeErrFmt, ErrDecode, err)
+		}
+
+		if err := b.checkLimit("callback type length", len(elements[i].CallbackType), limits.MaxCallbackTypeLen); err != nil {
+			return nil, err
+		}
+	}
+
+	return elements, nil
+}
+
+// itemBudget counts the items decoded from a received message against a limit.
+type itemBudget struct {
+	what  string
+	limit int
+	count int
+}
+
+// take counts a new item and returns an error if the limit is exceeded.
+func (budget *itemBudget) take(b *BMMC) error {
+	budget.count++
+
+	return b.checkLimit(budget.what, budget.count, budget.limit)
+}
+
+// decodeFailed returns the error for a field of a received message that cannot be decoded.
+func (b *BMMC) decodeFailed(what string, err error) error {
+	b.stats.rejectedMessages.Add(1)
+	b.config.Logger.Error("cannot decode received message", "field", what, "err", err)
+
+	return fmt.Errorf(boundedDecodeErrFmt, what, ErrDecode, err)
+}
+
+// decodeList decodes the given JSON array of a received message.
+// The budget is checked before each item is decoded, so a message exceeding
+// the limits is rejected without decoding all its items.
+func decodeList[T any](b *BMMC, raw json.RawMessage, budget *itemBudget) ([]T, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	return decodeArray[T](b, json.NewDecoder(bytes.NewReader(raw)), budget)
+}
+
+// decodeArray decodes the next JSON array from the given decoder.
+// The items are not counted if the budget is nil.
+func decodeArray[T any](b *BMMC, dec *json.Decoder, budget *itemBudget) ([]T, error) {
+	ok, err := beginValue(dec, '[')
+	if err != nil {
+		return nil, b.decodeFailed("list", err)
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	list := []T{}
+
+	for dec.More() {
+		if budget != nil {
+			if err := budget.take(b); err != nil {
+				return nil, err
+			}
+		}
+
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return nil, b.decodeFailed("list item", err)
+		}
+
+		list = append(list, item)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, b.decodeFailed("list", err)
+	}
+
+	return list, nil
+}
+
+// decodeObject decodes the given JSON object of a received message,
+// using the given function to decode its values.
+// The budget is checked before each entry is decoded. The entries are not
+// counted if the budget is nil.
+func decodeObject[T any](
+	b *BMMC, raw json.RawMessage, budget *itemBudget, decodeValue func(dec *json.Decoder) (T, error),
+) (map[string]T, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+
+	ok, err := beginValue(dec, '{')
+	if err != nil {
+		return nil, b.decodeFailed("object", err)
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	object := map[string]T{}
+
+	for dec.More() {
+		if budget != nil {
+			if err := budget.take(b); err != nil {
+				return nil, err
+			}
+		}
+
+		token, err := dec.Token()
+		if err != nil {
+			return nil, b.decodeFailed("object key", err)
+		}
+
+		key, _ := token.(string)
+
+		value, err := decodeValue(dec)
+		if err != nil {
+			return nil, err
+		}
+
+		object[key] = value
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, b.decodeFailed("object", err)
+	}
+
+	return object, nil
+}
+
+// decodeObjectValue decodes the next value from the given decoder.
+func decodeObjectValue[T any](b *BMMC) func(dec *json.Decoder) (T, error) {
+	return func(dec *json.Decoder) (T, error) {
+		var value T
+		if err := dec.Decode(&value); err != nil {
+			return value, b.decodeFailed("object value", err)
+		}
+
+		return value, nil
+	}
+}
+
+// beginValue reads the first token of the next value from the given decoder.
+// It returns false if the value is null and an error if the value doesn't
+// start with the given delimiter.
+func beginValue(dec *json.Decoder, delim json.Delim) (bool, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return false, err //nolint: wrapcheck
+	}
+
+	if token == nil {
+		return false, nil
+	}
+
+	if token != delim {
+		return false, fmt.Errorf(jsonTokenErrFmt, errUnexpectedJSONToken, token)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limits", func() {
+	var b *BMMC
+
+	BeforeEach(func() {
+		nodes := newTestCluster(2, func(cfg *Config) {
+			cfg.Limits = Limits{
+				MaxBodyBytes:       4096,
+				MaxDigestLen:       2,
+				MaxSyncElements:    2,
+				MaxElementBytes:    1024,
+				MaxCallbackTypeLen: 16,
+			}
+		})
+		b = nodes[0]
+	})
+
+	element := func(id, callbackType, msg string) string {
+		return fmt.Sprintf(`{"id":%q,"timestamp":"2024-01-01T00:00:00Z","msg":%q,"callbackType":%q}`,
+			id, msg, callbackType)
+	}
+
+	DescribeTable("rejects messages exceeding the limits", func(route, body string) {
+		_, err := b.Handle(context.Background(), route, []byte(body))
+		Expect(err).To(MatchError(ErrLimitExceeded))
+		Expect(b.Stats().RejectedMessages).To(Equal(uint64(1)))
+		Expect(b.messageBuffer.Length()).To(BeZero())
+	},
+		Entry("too large body", GossipRoute,
+			fmt.Sprintf(`{"host":"n1","digest":[%q]}`, strings.Repeat("x", 4096))),
+		Entry("too long gossip digest", GossipRoute,
+			`{"host":"n1","digest":["a","b","c"]}`),
+		Entry("too many gossip coverage entries", GossipRoute,
+			`{"host":"n1","digest":["a"],"coverage":{"a":["n1"],"b":["n1"],"c":["n1"]}}`),
+		Entry("too many gossip stability entries", GossipRoute,
+			`{"host":"n1","digest":["a"],"received":{"n0":1,"n2":1,"n3":1}}`),
+		Entry("too many gossip epochs", GossipRoute,
+			`{"host":"n1","digest":["a"],"epochs":{"n0":1,"n2":1,"n3":1}}`),
+		Entry("too long solicitation digest", SolicitationRoute,
+			`{"host":"n1","digest":["a","b","c"]}`),
+		Entry("too many solicited sequence numbers", SolicitationRoute,
+			`{"host":"n1","digest":[],"sequences":{"n0":[1,2],"n2":[1]}}`),
+		Entry("too many acknowledged IDs", AckRoute,
+			`{"host":"n1","ids":["a","b","c"]}`),
+		Entry("too many synchronization elements", SynchronizationRoute,
+			fmt.Sprintf(`{"host":"n1","elements":[%s,%s,%s]}`,
+				element("a", NOCALLBACK, "a"), element("b", NOCALLBACK, "b"), element("c", NOCALLBACK, "c"))),
+		Entry("too large element", SynchronizationRoute,
+			fmt.Sprintf(`{"host":"n1","elements":[%s]}`, element("a", NOCALLBACK, strings.Repeat("x", 1024)))),
+		Entry("too long callback type", SynchronizationRoute,
+			fmt.Sprintf(`{"host":"n1","elements":[%s]}`, element("a", strings.Repeat("x", 17), "a"))),
+		Entry("gossip digest exceeding the limit before its invalid items", GossipRoute,
+			`{"host":"n1","digest":["a","b",{"c":1}]}`),
+		Entry("synchronization elements exceeding the limit before their invalid items", SynchronizationRoute,
+			fmt.Sprintf(`{"host":"n1","elements":[%s,%s,{"id":1}]}`, element("a", NOCALLBACK, "a"), element("b", NOCALLBACK, "b"))),
+	)
+
+	DescribeTable("rejects messages with invalid bounded fields", func(route, body string) {
+		_, err := b.Handle(context.Background(), route, []byte(body))
+		Expect(err).To(MatchError(ErrDecode))
+		Expect(b.Stats().RejectedMessages).To(Equal(uint64(1)))
+	},
+		Entry("gossip digest is not a list", GossipRoute, `{"host":"n1","digest":{"a":1}}`),
+		Entry("gossip digest has invalid items", GossipRoute, `{"host":"n1","digest":[1]}`),
+		Entry("gossip coverage is not an object", GossipRoute, `{"host":"n1","coverage":["a"]}`),
+		Entry("gossip stability summary has invalid values", GossipRoute, `{"host":"n1","received":{"n0":"a"}}`),
+		Entry("solicited sequence numbers are invalid", SolicitationRoute, `{"host":"n1","sequences":{"n0":["a"]}}`),
+	)
+
+	It("checks the limits of messages received by the legacy handlers", func() {
+		b.GossipHandler([]byte(fmt.Sprintf(`{"host":"n1","digest":[%q]}`, strings.Repeat("x", 4096))))
+		b.SolicitationHandler([]byte(`{"host":"n1","digest":["a","b","c"]}`))
+		b.SynchronizationHandler([]byte(fmt.Sprintf(`{"host":"n1","elements":[%s,%s,%s]}`,
+			element("a", NOCALLBACK, "a"), element("b", NOCALLBACK, "b"), element("c", NOCALLBACK, "c"))))
+
+		Expect(b.Stats().RejectedMessages).To(Equal(uint64(3)))
+		Expect(b.messageBuffer.Length()).To(BeZero())
+	})
+
+	It("counts messages that cannot be decoded", func() {
+		_, err := b.Handle(context.Background(), SynchronizationRoute, []byte(`{"host":"n1","elements":[{"id":1}]}`))
+		Expect(err).To(MatchError(ErrDecode))
+		Expect(b.Stats().RejectedMessages).To(Equal(uint64(1)))
+	})
+
+	It("decodes bounded fields of messages within the limits", func() {
+		gossip, err := b.receiveGossip([]byte(
+			`{"host":"n1","digest":["a"],"coverage":{"a":["n1","n2"]},"received":{"n0":2},"epochs":{"n0":1}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(gossip.Digest).To(Equal([]string{"a"}))
+		Expect(gossip.Coverage).To(Equal(map[string][]string{"a": {"n1", "n2"}}))
+		Expect(gossip.Received).To(Equal(map[string]uint64{"n0": 2}))
+		Expect(gossip.Epochs).To(Equal(map[string]uint64{"n0": 1}))
+
+		solicitation, err := b.receiveSolicitation([]byte(`{"host":"n1","digest":null,"sequences":{"n0":[1],"n2":[2]}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(solicitation.Digest).To(BeNil())
+		Expect(solicitation.Sequences).To(Equal(map[string][]uint64{"n0": {1}, "n2": {2}}))
+	})
+
+	It("accepts messages within the limits", func() {
+		_, err := b.Handle(context.Background(), SynchronizationRoute,
+			[]byte(fmt.Sprintf(`{"host":"n1","elements":[%s]}`, element("a", NOCALLBACK, "a"))))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.Stats().RejectedMessages).To(BeZero())
+	})
+})
+
+// newFuzzNode creates a node with limits for fuzz tests.
+func newFuzzNode(f *testing.F) *BMMC {
+	f.Helper()
+
+	b, err := New(&Config{
+		Host:       &fakeHost{},
+		BufferSize: 64,
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Limits: Limits{
+			MaxBodyBytes:       1 << 16,
+			MaxDigestLen:       128,
+			MaxSyncElements:    64,
+			MaxElementBytes:    1 << 12,
+			MaxCallbackTypeLen: 64,
+		},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	return b
+}
+
+func FuzzReceiveGossip(f *testing.F) {
+	b := newFuzzNode(f)
+
+	f.Add([]byte(`{"host":"n1","digest":["a","b"],"roundNumber":{"number":1}}`))
+	f.Add([]byte(`{"digest":null}`))
+
+	f.Fuzz(func(_ *testing.T, msg []byte) {
+		b.receiveGossip(msg) //nolint: errcheck
+	})
+}
+
+func FuzzReceiveSolicitation(f *testing.F) {
+	b := newFuzzNode(f)
+
+	f.Add([]byte(`{"host":"n1","digest":["a","b"],"roundNumber":{"number":1}}`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(_ *testing.T, msg []byte) {
+		b.receiveSolicitation(msg) //nolint: errcheck
+	})
+}
+
+func FuzzReceiveSynchronization(f *testing.F) {
+	b := newFuzzNode(f)
+
+	f.Add([]byte(`{"host":"n1","elements":[{"id":"a","msg":"a","callbackType":"no-callback"}]}`))
+	f.Add([]byte(`{"host":"n1","elements":[null,{}]}`))
+
+	f.Fuzz(func(_ *testing.T, msg []byte) {
+		b.receiveSynchronization(msg) //nolint: errcheck
+	})
+}
+
+func FuzzReceiveAck(f *testing.F) {
+	b := newFuzzNode(f)
+
+	f.Add([]byte(`{"host":"n1","ids":["a","b"]}`))
+	f.Add([]byte(`{"ids":[null,1]}`))
+
+	f.Fuzz(func(_ *testing.T, msg []byte) {
+		b.receiveAck(msg) //nolint: errcheck
+	})
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/callback"
+)
+
+const (
+	// joinTokenHeader is the element header with the join token of an added peer.
+	joinTokenHeader = "join-token"
+
+	membershipRejectedErrFmt = "%w: %w"
+	parseCIDRErrFmt          = "error at parsing CIDR %q: %w"
+)
+
+var (
+	// ErrMembershipRejected is returned when a change of the peers list is rejected by the membership policy.
+	ErrMembershipRejected = errors.New("membership change rejected")
+
+	errPeerNotAllowed    = errors.New("peer is not in allowlist")
+	errInvalidJoinToken  = errors.New("invalid join token")
+	errNotSelfRemoval    = errors.New("only a peer can remove itself")
+	errUnknownMembership = errors.New("unknown membership change")
+)
+
+// MembershipAction is the action of a membership change.
+type MembershipAction string
+
+const (
+	// MembershipAdd adds a peer in peers list.
+	MembershipAdd MembershipAction = callback.ADDPEER
+	// MembershipRemove removes a peer from peers list.
+	MembershipRemove MembershipAction = callback.REMOVEPEER
+)
+
+// MembershipChange is a change of the peers list.
+type MembershipChange struct {
+	// Action is the membership action.
+	Action MembershipAction
+	// Peer is the added or removed peer.
+	Peer string
+	// Origin is the host that requested the change.
+	// Use signed messages, otherwise the origin can be spoofed.
+	Origin string
+	// Token is the join token of the added peer.
+	Token string
+}
+
+// MembershipPolicy decides if a change of the peers list is allowed.
+type MembershipPolicy interface {
+	// Allow returns an error if the given change is not allowed.
+	Allow(change MembershipChange) error
+}
+
+// MembershipPolicies is a policy that allows a change only if all policies allow it.
+type MembershipPolicies []MembershipPolicy
+
+// Allow returns the error of the first policy that doesn't allow the change.
+func (p MembershipPolicies) Allow(change MembershipChange) error {
+	for _, policy := range p {
+		if err := policy.Allow(change); err != nil {
+			return err //nolint: wrapcheck
+		}
+	}
+
+	return nil
+}
+
+// AllowlistPolicy allows adding only given peers or peers with an IP address
+// from given CIDR ranges. Peers are matched by their host, without port.
+type AllowlistPolicy struct {
+	Peers []string
+	CIDRs []*net.IPNet
+}
+
+// NewAllowlistPolicy creates an allowlist policy with given peers and CIDR ranges.
+func NewAllowlistPolicy(peers []string, cidrs []string) (*AllowlistPolicy, error) {
+	p := &AllowlistPolicy{
+		Peers: peers,
+		CIDRs: make([]*net.IPNet, 0, len(cidrs)),
+	}
+
+	for _, c := range cidrs {
+		_, ipNet, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf(parseCIDRErrFmt, c, err)
+		}
+
+		p.CIDRs = append(p.CIDRs, ipNet)
+	}
+
+	return p, nil
+}
+
+// peerHost returns the host of given peer, without port.
+func peerHost(p string) string {
+	if host, _, err := net.SplitHostPort(p); err == nil {
+		return host
+	}
+
+	return p
+}
+
+// Allow allows removals and additions of peers from allowlist.
+func (p *AllowlistPolicy) Allow(change MembershipChange) error {
+	if change.Action != MembershipAdd {
+		return nil
+	}
+
+	host := peerHost(change.Peer)
+
+	for _, allowed := range p.Peers {
+		if change.Peer == allowed || host == allowed {
+			return nil
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, ipNet := range p.CIDRs {
+			if ipNet.Contains(ip) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf(membershipRejectedErrFmt, ErrMembershipRejected, errPeerNotAllowed)
+}
+
+// NewJoinToken returns the join token of the given peer, signed with the cluster join key.
+func NewJoinToken(key []byte, peer string) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(peer))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// JoinTokenPolicy allows adding only peers with a join token created by NewJoinToken.
+type JoinTokenPolicy struct {
+	Key []byte
+}
+
+// Allow allows removals and additions of peers with valid join tokens.
+func (p JoinTokenPolicy) Allow(change MembershipChange) error {
+	if change.Action != MembershipAdd {
+		return nil
+	}
+
+	token, err := hex.DecodeString(change.Token)
+	if err != nil {
+		return fmt.Errorf(membershipRejectedErrFmt, ErrMembershipRejected, errInvalidJoinToken)
+	}
+
+	expected, _ := hex.DecodeString(NewJoinToken(p.Key, change.Peer))
+
+	if !hmac.Equal(token, expected) {
+		return fmt.Errorf(membershipRejectedErrFmt, ErrMembershipRejected, errInvalidJoinToken)
+	}
+
+	return nil
+}
+
+// SelfRemovalPolicy allows a peer to be removed only by itself.
+type SelfRemovalPolicy struct{}
+
+// Allow allows additions of peers and removals requested by the removed peer.
+func (SelfRemovalPolicy) Allow(change MembershipChange) error {
+	if change.Action == MembershipRemove && change.Origin != change.Peer {
+		return fmt.Errorf(membershipRejectedErrFmt, ErrMembershipRejected, errNotSelfRemoval)
+	}
+
+	return nil
+}
+
+// membershipChange returns the membership change of an internal peer element.
+func membershipChange(el buffer.Element) (MembershipChange, error) {
+	if el.CallbackType != callback.ADDPEER && el.CallbackType != callback.REMOVEPEER {
+		return MembershipChange{}, errUnknownMembership
+	}
+
+	p, ok := el.Msg.(string)
+	if !ok {
+		return MembershipChange{}, errUnknownMembership
+	}
+
+	return MembershipChange{
+		Action: MembershipAction(el.CallbackType),
+		Peer:   p,
+		Origin: el.Origin,
+		Token:  el.Headers[joinTokenHeader],
+	}, nil
+}
+
+// allowMembershipChange consults the membership policy before a change of peers list.
+// Rejected changes are logged and reported to the OnMembershipRejected hook.
+func (b *BMMC) allowMembershipChange(change MembershipChange) error {
+	if b.config.MembershipPolicy == nil {
+		return nil
+	}
+
+	if err := b.config.MembershipPolicy.Allow(change); err != nil {
+		b.config.Logger.Error("membership change rejected", "err", err,
+			"action", change.Action, "peer", change.Peer, "origin", change.Origin)
+
+		if b.config.OnMembershipRejected != nil {
+			b.config.OnMembershipRejected(change, err)
+		}
+
+		return err //nolint: wrapcheck
+	}
+
+	return nil
+}
+
+// allowMembershipElement consults the membership policy for a received element.
+// It allows user elements and the internal elements which don't change the
+// peers list, e.g. tombstones.
+func (b *BMMC) allowMembershipElement(el buffer.Element) error {
+	if !el.Internal || b.config.MembershipPolicy == nil {
+		return nil
+	}
+
+	if el.CallbackType != callback.ADDPEER && el.CallbackType != callback.REMOVEPEER {
+		return nil
+	}
+
+	change, err := membershipChange(el)
+	if err != nil {
+		return fmt.Errorf(membershipRejectedErrFmt, ErrMembershipRejected, err)
+	}
+
+	return b.allowMembershipChange(change)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/callback"
+)
+
+var _ = Describe("Membership policies", func() {
+	Describe("AllowlistPolicy", func() {
+		var policy *AllowlistPolicy
+
+		BeforeEach(func() {
+			var err error
+
+			policy, err = NewAllowlistPolicy([]string{"n1", "localhost"}, []string{"10.0.0.0/24"})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error for invalid CIDR ranges", func() {
+			_, err := NewAllowlistPolicy(nil, []string{"10.0.0.0"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		DescribeTable("allows peers from allowlist", func(p string) {
+			Expect(policy.Allow(MembershipChange{Action: MembershipAdd, Peer: p})).To(Succeed())
+		},
+			Entry("peer", "n1"),
+			Entry("host with port", "localhost:19999"),
+			Entry("IP from CIDR range", "10.0.0.7:19999"),
+		)
+
+		It("rejects other peers", func() {
+			err := policy.Allow(MembershipChange{Action: MembershipAdd, Peer: "10.0.1.7:19999"})
+			Expect(err).To(MatchError(ErrMembershipRejected))
+		})
+
+		It("allows removals", func() {
+			Expect(policy.Allow(MembershipChange{Action: MembershipRemove, Peer: "n2"})).To(Succeed())
+		})
+	})
+
+	Describe("JoinTokenPolicy", func() {
+		policy := JoinTokenPolicy{Key: []byte("join-key")}
+
+		It("allows peers with valid join tokens", func() {
+			token := NewJoinToken([]byte("join-key"), "n1")
+			Expect(policy.Allow(MembershipChange{Action: MembershipAdd, Peer: "n1", Token: token})).To(Succeed())
+		})
+
+		It("rejects peers with join tokens for another peer", func() {
+			token := NewJoinToken([]byte("join-key"), "n2")
+			err := policy.Allow(MembershipChange{Action: MembershipAdd, Peer: "n1", Token: token})
+			Expect(err).To(MatchError(errInvalidJoinToken))
+		})
+
+		It("rejects peers without join tokens", func() {
+			err := policy.Allow(MembershipChange{Action: MembershipAdd, Peer: "n1"})
+			Expect(err).To(MatchError(errInvalidJoinToken))
+		})
+	})
+
+	Describe("SelfRemovalPolicy", func() {
+		It("allows a peer to remove itself", func() {
+			Expect(SelfRemovalPolicy{}.Allow(MembershipChange{Action: MembershipRemove, Peer: "n1", Origin: "n1"})).To(Succeed())
+		})
+
+		It("rejects removals of other peers", func() {
+			err := SelfRemovalPolicy{}.Allow(MembershipChange{Action: MembershipRemove, Peer: "n1", Origin: "n2"})
+			Expect(err).To(MatchError(errNotSelfRemoval))
+		})
+	})
+
+	Describe("MembershipPolicies", func() {
+		It("rejects a change if one of the policies rejects it", func() {
+			policies := MembershipPolicies{SelfRemovalPolicy{}, JoinTokenPolicy{Key: []byte("join-key")}}
+
+			Expect(policies.Allow(MembershipChange{Action: MembershipRemove, Peer: "n1", Origin: "n1"})).To(Succeed())
+			Expect(policies.Allow(MembershipChange{Action: MembershipAdd, Peer: "n1"})).To(MatchError(errInvalidJoinToken))
+		})
+	})
+
+	Describe("BMMC with membership policy", func() {
+		var (
+			nodes    []*BMMC
+			rejected chan MembershipChange
+		)
+
+		BeforeEach(func() {
+			rejected = make(chan MembershipChange, 10)
+
+			nodes = newTestCluster(2, func(cfg *Config) {
+				cfg.MembershipPolicy = MembershipPolicies{
+					SelfRemovalPolicy{},
+					JoinTokenPolicy{Key: []byte("join-key")},
+				}
+				cfg.OnMembershipRejected = func(change MembershipChange, _ error) {
+					rejected <- change
+				}
+			})
+		})
+
+		It("rejects local changes", func() {
+			Expect(nodes[0].AddPeer("n2")).To(MatchError(errInvalidJoinToken))
+			Expect(nodes[0].RemovePeer("n1")).To(MatchError(errNotSelfRemoval))
+
+			Expect(rejected).To(Receive(Equal(MembershipChange{Action: MembershipAdd, Peer: "n2", Origin: "n0"})))
+			Expect(rejected).To(Receive(Equal(MembershipChange{Action: MembershipRemove, Peer: "n1", Origin: "n0"})))
+			Expect(nodes[0].GetPeers()).To(ConsistOf("n1"))
+		})
+
+		It("adds peers with valid join tokens on all nodes", func() {
+			Expect(nodes[0].AddPeerWithToken("n2", NewJoinToken([]byte("join-key"), "n2"))).To(Succeed())
+
+			Eventually(nodes[1].GetPeers).Should(ConsistOf("n0", "n2"))
+		})
+
+		It("rejects received changes", func() {
+			Expect(nodes[0].peerBuffer.AddPeer("n2")).To(BeTrue())
+
+			// n1 tries to remove n2 from peers list
+			el, err := buffer.NewElement("n2", callback.REMOVEPEER, true)
+			Expect(err).ToNot(HaveOccurred())
+
+			el.Origin = "n1"
+
+			body, err := json.Marshal(Synchronization{Host: "n1", Elements: []buffer.Element{el}})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = nodes[0].Handle(context.Background(), SynchronizationRoute, body)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(rejected).To(Receive(Equal(MembershipChange{Action: MembershipRemove, Peer: "n2", Origin: "n1"})))
+			Expect(nodes[0].GetPeers()).To(ConsistOf("n1", "n2"))
+			Expect(nodes[0].messageBuffer.Length()).To(BeZero())
+			Expect(nodes[0].Stats().RejectedElements).To(Equal(uint64(1)))
+		})
+
+		It("accepts the tombstones of retracted messages", func() {
+			id, err := nodes[0].AddMessageWithID(context.Background(), "message", NOCALLBACK)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(nodes[1].messageBuffer.Digest).Should(ContainElement(id))
+
+			Expect(nodes[0].RetractMessage(id)).To(Succeed())
+
+			Eventually(nodes[1].messageBuffer.Digest).ShouldNot(ContainElement(id))
+			Expect(nodes[1].retractions.has(id)).To(BeTrue())
+			Expect(nodes[1].Stats().RejectedElements).To(BeZero())
+			Expect(rejected).ToNot(Receive())
+		})
+	})
+})
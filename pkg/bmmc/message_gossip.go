@@ -17,12 +17,15 @@ limitations under the License.
 package bmmc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
 )
 
 const (
-	gossipDecodingErrFmt = "error at decoding gossip message in Server: %w"
+	gossipDecodingErrFmt = "error at decoding gossip message in Server: %w: %w"
 	gossipMarshalErrFmt  = "gossiper from %s can not marshal the gossip message: %w"
 )
 
@@ -31,19 +34,70 @@ type Gossip struct {
 	Host        string       `json:"host"`
 	RoundNumber *GossipRound `json:"roundNumber"`
 	Digest      []string     `json:"digest"`
+	// Coverage contains the hosts known by the sender to have the elements
+	// from digest, indexed by element ID.
+	Coverage map[string][]string `json:"coverage,omitempty"`
+	// Lamport is the Lamport clock of the sender and LastSeq is the sequence
+	// number of the last message added by the sender in its Epoch, for total
+	// order delivery.
+	Lamport uint64 `json:"lamport,omitempty"`
+	LastSeq uint64 `json:"lastSeq,omitempty"`
+	Epoch   uint64 `json:"epoch,omitempty"`
+	// Received contains the sequence numbers up to which the sender received
+	// all messages, indexed by origin, for stability detection. Epochs contains
+	// the epochs of the origins from Received.
+	Received map[string]uint64 `json:"received,omitempty"`
+	Epochs   map[string]uint64 `json:"epochs,omitempty"`
+}
+
+// rawGossip is a gossip message with the fields bounded by Limits not decoded yet.
+type rawGossip struct {
+	Gossip
+	Digest   json.RawMessage `json:"digest"`
+	Coverage json.RawMessage `json:"coverage,omitempty"`
+	Received json.RawMessage `json:"received,omitempty"`
+	Epochs   json.RawMessage `json:"epochs,omitempty"`
 }
 
 // receiveGossip receives a gossip message.
-func (b *BMMC) receiveGossip(msg []byte) ([]string, string, *GossipRound, error) {
-	var body Gossip
+func (b *BMMC) receiveGossip(msg []byte) (Gossip, error) {
+	var raw rawGossip
 
-	if err := json.Unmarshal(msg, &body); err != nil {
+	msg, err := b.open(GossipRoute, msg)
+	if err != nil {
+		return Gossip{}, err
+	}
+
+	if err := json.Unmarshal(msg, &raw); err != nil {
 		b.config.Logger.Error("cannot decode gossip message", "err", err)
 
-		return nil, "", nil, fmt.Errorf(gossipDecodingErrFmt, err)
+		b.stats.rejectedMessages.Add(1)
+
+		return Gossip{}, fmt.Errorf(gossipDecodingErrFmt, ErrDecode, err)
+	}
+
+	body := raw.Gossip
+
+	if body.Digest, err = decodeList[string](b, raw.Digest, b.digestBudget()); err != nil {
+		return Gossip{}, err
+	}
+
+	body.Coverage, err = decodeObject(b, raw.Coverage, b.coverageBudget(), decodeObjectValue[[]string](b))
+	if err != nil {
+		return Gossip{}, err
+	}
+
+	body.Received, err = decodeObject(b, raw.Received, b.stabilityBudget(), decodeObjectValue[uint64](b))
+	if err != nil {
+		return Gossip{}, err
 	}
 
-	return body.Digest, body.Host, body.RoundNumber, nil
+	body.Epochs, err = decodeObject(b, raw.Epochs, b.stabilityBudget(), decodeObjectValue[uint64](b))
+	if err != nil {
+		return Gossip{}, err
+	}
+
+	return body, nil
 }
 
 // sendGossip sends a gossip message.
@@ -55,11 +109,89 @@ func (b *BMMC) sendGossip(gossipMsg Gossip, peerToSend string) error {
 		return fmt.Errorf(gossipMarshalErrFmt, gossipMsg.Host, err)
 	}
 
+	jsonGossip, err = b.seal(GossipRoute, jsonGossip)
+	if err != nil {
+		return err
+	}
+
+	b.incCounter(MetricGossipsSent)
+	b.config.Observer.OnGossipSent(peerToSend, len(gossipMsg.Digest))
+
+	done := b.outbound.begin(GossipRoute)
+
 	go func() {
+		defer done()
+
+		if b.config.Exchange == GossipExchange {
+			b.requestGossip(jsonGossip, peerToSend)
+
+			return
+		}
+
 		if err := b.config.Host.Send(jsonGossip, GossipRoute, peerToSend); err != nil {
+			b.sendFailed(peerToSend, GossipRoute, err)
 			b.config.Logger.Error("cannot send gossip message to peer", "err", err)
 		}
 	}()
 
 	return nil
 }
+
+// requestGossip sends a gossip message and waits for the solicitation message as reply.
+// The synchronization message is sent to the same peer.
+func (b *BMMC) requestGossip(jsonGossip []byte, peerToSend string) {
+	requester, ok := b.config.Host.(peer.Requester)
+	if !ok {
+		return
+	}
+
+	resp, err := requester.Request(jsonGossip, GossipRoute, peerToSend)
+	if err != nil {
+		b.sendFailed(peerToSend, GossipRoute, err)
+		b.config.Logger.Error("cannot send gossip message to peer", "err", err)
+
+		return
+	}
+
+	if len(resp) == 0 {
+		return // peer doesn't miss any message
+	}
+
+	jsonSynchronization, err := b.handleSolicitationReply(resp, peerToSend)
+	if err != nil {
+		b.config.Logger.Error("rejected solicitation reply", "err", err, "peer", peerToSend)
+
+		return
+	}
+
+	if len(jsonSynchronization) == 0 {
+		return
+	}
+
+	if err := b.config.Host.Send(jsonSynchronization, SynchronizationRoute, peerToSend); err != nil {
+		b.sendFailed(peerToSend, SynchronizationRoute, err)
+		b.config.Logger.Error("cannot send synchronization message", "err", err)
+	}
+}
+
+// handleSolicitationReply handles the solicitation message received as reply to
+// a gossip message sent to the given peer. The solicitation must be sent by the
+// peer, since the synchronization message is sent to it.
+func (b *BMMC) handleSolicitationReply(body []byte, peerToSend string) ([]byte, error) {
+	if err := b.checkBody(body); err != nil {
+		return nil, err
+	}
+
+	solicitation, err := b.receiveSolicitation(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if solicitation.Host != peerToSend {
+		b.stats.rejectedSenders.Add(1)
+
+		return nil, fmt.Errorf(rejectedSenderErrFmt, ErrRejectedSender, solicitation.Host)
+	}
+
+	return b.replySolicitation(contextWithRequestedPeer(context.Background(), peerToSend), solicitation)
+}
@@ -17,12 +17,15 @@ limitations under the License.
 package bmmc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
 )
 
 const (
-	solicitationDecodingErrFmt = "error at decoding http solicitation message in Server: %w"
+	solicitationDecodingErrFmt = "error at decoding http solicitation message in Server: %w: %w"
 	solicitationMarshalErrFmt  = "error at marshal http solicitation in Server: %w"
 )
 
@@ -31,35 +34,114 @@ type Solicitation struct {
 	Host        string       `json:"host"`
 	RoundNumber *GossipRound `json:"roundNumber"`
 	Digest      []string     `json:"digest"`
+	// Sequences contains the sequence numbers of the solicited elements,
+	// indexed by their origin (see DeliveryOrder).
+	Sequences map[string][]uint64 `json:"sequences,omitempty"`
+}
+
+// rawSolicitation is a solicitation message with the fields bounded by Limits not decoded yet.
+type rawSolicitation struct {
+	Solicitation
+	Digest    json.RawMessage `json:"digest"`
+	Sequences json.RawMessage `json:"sequences,omitempty"`
 }
 
 // receiveSolicitation receives http solicitation message.
-func (b *BMMC) receiveSolicitation(msg []byte) ([]string, string, *GossipRound, error) {
-	var body Solicitation
+func (b *BMMC) receiveSolicitation(msg []byte) (Solicitation, error) {
+	var raw rawSolicitation
+
+	msg, err := b.open(SolicitationRoute, msg)
+	if err != nil {
+		return Solicitation{}, err
+	}
 
-	if err := json.Unmarshal(msg, &body); err != nil {
+	if err := json.Unmarshal(msg, &raw); err != nil {
 		b.config.Logger.Error("cannot decode solicitation message", "err", err)
 
-		return nil, "", nil, fmt.Errorf(solicitationDecodingErrFmt, err)
+		b.stats.rejectedMessages.Add(1)
+
+		return Solicitation{}, fmt.Errorf(solicitationDecodingErrFmt, ErrDecode, err)
+	}
+
+	body := raw.Solicitation
+
+	if body.Digest, err = decodeList[string](b, raw.Digest, b.digestBudget()); err != nil {
+		return Solicitation{}, err
 	}
 
-	return body.Digest, body.Host, body.RoundNumber, nil
+	// the sequence numbers of all origins share the same budget
+	sequences := b.sequencesBudget()
+
+	body.Sequences, err = decodeObject(b, raw.Sequences, nil, func(dec *json.Decoder) ([]uint64, error) {
+		return decodeArray[uint64](b, dec, sequences)
+	})
+	if err != nil {
+		return Solicitation{}, err
+	}
+
+	return body, nil
 }
 
-// sendSolicitation send http solicitation message.
-func (b *BMMC) sendSolicitation(solicitation Solicitation, peerToSend string) error {
+// marshalSolicitation encodes a solicitation message.
+func (b *BMMC) marshalSolicitation(solicitation Solicitation) ([]byte, error) {
 	jsonSolicitation, err := json.Marshal(solicitation)
 	if err != nil {
 		b.config.Logger.Error("cannot marshal solicitation message", "err", err)
 
-		return fmt.Errorf(solicitationMarshalErrFmt, err)
+		return nil, fmt.Errorf(solicitationMarshalErrFmt, err)
 	}
 
+	b.incCounter(MetricSolicitationsSent)
+
+	return b.seal(SolicitationRoute, jsonSolicitation)
+}
+
+// sendSolicitation send http solicitation message.
+func (b *BMMC) sendSolicitation(solicitation Solicitation, peerToSend string) error {
+	jsonSolicitation, err := b.marshalSolicitation(solicitation)
+	if err != nil {
+		return err
+	}
+
+	done := b.outbound.begin(SolicitationRoute)
+
 	go func() {
+		defer done()
+
+		if b.config.Exchange == SolicitationExchange {
+			b.requestSolicitation(jsonSolicitation, peerToSend)
+
+			return
+		}
+
 		if err := b.config.Host.Send(jsonSolicitation, SolicitationRoute, peerToSend); err != nil {
+			b.sendFailed(peerToSend, SolicitationRoute, err)
 			b.config.Logger.Error("cannot send solicitation message", "err", err)
 		}
 	}()
 
 	return nil
 }
+
+// requestSolicitation sends a solicitation message and handles the
+// synchronization message received as reply.
+func (b *BMMC) requestSolicitation(jsonSolicitation []byte, peerToSend string) {
+	requester, ok := b.config.Host.(peer.Requester)
+	if !ok {
+		return
+	}
+
+	resp, err := requester.Request(jsonSolicitation, SolicitationRoute, peerToSend)
+	if err != nil {
+		b.sendFailed(peerToSend, SolicitationRoute, err)
+		b.config.Logger.Error("cannot send solicitation message", "err", err)
+
+		return
+	}
+
+	if len(resp) == 0 {
+		return
+	}
+
+	b.handleSynchronization(contextWithRequestedPeer(context.Background(), peerToSend), resp) //nolint: errcheck
+}
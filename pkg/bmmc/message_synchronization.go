@@ -21,10 +21,11 @@ import (
 	"fmt"
 
 	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
 )
 
 const (
-	synchronizationDecodeErrFmt  = "error at decoding synchronization message in Server: %w"
+	synchronizationDecodeErrFmt  = "error at decoding synchronization message in Server: %w: %w"
 	synchronizationMarshalErrFmt = "error at marshal synchronization message in Server: %w"
 )
 
@@ -34,33 +35,101 @@ type Synchronization struct {
 	Elements []buffer.Element `json:"elements"`
 }
 
+// rawSynchronization is a synchronization message with elements not decoded yet.
+type rawSynchronization struct {
+	Host     string          `json:"host"`
+	Elements json.RawMessage `json:"elements"`
+}
+
 // receiveSynchronization receives http solicitation message.
 func (b *BMMC) receiveSynchronization(msg []byte) ([]buffer.Element, string, error) {
-	var body Synchronization
+	var body rawSynchronization
+
+	msg, err := b.open(SynchronizationRoute, msg)
+	if err != nil {
+		return nil, "", err
+	}
 
 	if err := json.Unmarshal(msg, &body); err != nil {
 		b.config.Logger.Error("cannot decode synchronization message", "err", err)
 
-		return nil, "", fmt.Errorf(synchronizationDecodeErrFmt, err)
+		b.stats.rejectedMessages.Add(1)
+
+		return nil, "", fmt.Errorf(synchronizationDecodeErrFmt, ErrDecode, err)
+	}
+
+	rawElements, err := decodeList[json.RawMessage](b, body.Elements, b.syncElementsBudget())
+	if err != nil {
+		return nil, "", err
+	}
+
+	elements, err := b.decodeElements(rawElements)
+	if err != nil {
+		return nil, "", err
 	}
 
-	return body.Elements, body.Host, nil
+	return elements, body.Host, nil
 }
 
-// sendSynchronization send http synchronization message.
-func (b *BMMC) sendSynchronization(synchronization Synchronization, peerToSend string) error {
+// marshalSynchronization encodes a synchronization message.
+func (b *BMMC) marshalSynchronization(synchronization Synchronization) ([]byte, error) {
 	jsonSynchronization, err := json.Marshal(synchronization)
 	if err != nil {
 		b.config.Logger.Error("cannot marshal synchronization message", "err", err)
 
-		return fmt.Errorf(synchronizationMarshalErrFmt, err)
+		return nil, fmt.Errorf(synchronizationMarshalErrFmt, err)
+	}
+
+	return b.seal(SynchronizationRoute, jsonSynchronization)
+}
+
+// sendSynchronization send http synchronization message.
+func (b *BMMC) sendSynchronization(synchronization Synchronization, peerToSend string) error {
+	jsonSynchronization, err := b.marshalSynchronization(synchronization)
+	if err != nil {
+		return err
 	}
 
+	done := b.outbound.begin(SynchronizationRoute)
+
 	go func() {
+		defer done()
+
 		if err := b.config.Host.Send(jsonSynchronization, SynchronizationRoute, peerToSend); err != nil {
+			b.sendFailed(peerToSend, SynchronizationRoute, err)
 			b.config.Logger.Error("cannot send synchronization message", "err", err)
 		}
 	}()
 
 	return nil
 }
+
+// multicastSynchronization sends a synchronization message with given elements
+// to all peers at once. It does nothing if the host doesn't support multicast.
+func (b *BMMC) multicastSynchronization(elements []buffer.Element) {
+	multicaster, ok := b.config.Host.(peer.Multicaster)
+	if !ok {
+		return
+	}
+
+	jsonSynchronization, err := b.marshalSynchronization(Synchronization{
+		Host:     b.config.Host.String(),
+		Elements: elements,
+	})
+	if err != nil {
+		return
+	}
+
+	b.config.Observer.OnSynchronizationSent("", len(elements))
+
+	done := b.outbound.begin(SynchronizationRoute)
+
+	go func() {
+		defer done()
+
+		if err := multicaster.Multicast(jsonSynchronization, SynchronizationRoute); err != nil {
+			b.sendFailed("", SynchronizationRoute, err)
+			b.config.Logger.Error("cannot multicast synchronization message", "err", err)
+		}
+	}()
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type multicastMsg struct {
+	msg   []byte
+	route string
+}
+
+type fakeMulticastHost struct {
+	multicasts chan multicastMsg
+}
+
+func (h *fakeMulticastHost) String() string {
+	return "localhost:19999"
+}
+
+func (h *fakeMulticastHost) Send(_ []byte, _ string, _ string) error {
+	return nil
+}
+
+func (h *fakeMulticastHost) Multicast(msg []byte, route string) error {
+	h.multicasts <- multicastMsg{msg: msg, route: route}
+
+	return nil
+}
+
+var _ = Describe("Synchronization", func() {
+	Describe("multicastSynchronization function", func() {
+		var (
+			host *fakeMulticastHost
+			b    *BMMC
+		)
+
+		BeforeEach(func() {
+			var err error
+
+			host = &fakeMulticastHost{multicasts: make(chan multicastMsg, 1)}
+
+			b, err = New(&Config{
+				Host:       host,
+				BufferSize: 25,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("multicasts new messages when host supports multicast", func() {
+			Expect(b.AddMessage(context.Background(), "my message", NOCALLBACK)).To(Succeed())
+
+			var m multicastMsg
+			Eventually(host.multicasts).Should(Receive(&m))
+			Expect(m.route).To(Equal(SynchronizationRoute))
+
+			var sync Synchronization
+			Expect(json.Unmarshal(m.msg, &sync)).To(Succeed())
+			Expect(sync.Host).To(Equal(host.String()))
+			Expect(sync.Elements).To(HaveLen(1))
+			Expect(sync.Elements[0].Msg).To(Equal("my message"))
+		})
+
+		It("multicasts internal peer messages when host supports multicast", func() {
+			Expect(b.AddPeer("localhost:29999")).To(Succeed())
+
+			var m multicastMsg
+			Eventually(host.multicasts).Should(Receive(&m))
+
+			var sync Synchronization
+			Expect(json.Unmarshal(m.msg, &sync)).To(Succeed())
+			Expect(sync.Elements).To(HaveLen(1))
+			Expect(sync.Elements[0].Msg).To(Equal("localhost:29999"))
+			Expect(sync.Elements[0].Internal).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,124 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+// Names of the metrics reported by the protocol.
+const (
+	// MetricGossipsSent is the counter of sent gossip messages.
+	MetricGossipsSent = "bmmc_gossips_sent_total"
+	// MetricGossipsReceived is the counter of received gossip messages.
+	MetricGossipsReceived = "bmmc_gossips_received_total"
+	// MetricSolicitationsSent is the counter of sent solicitation messages.
+	MetricSolicitationsSent = "bmmc_solicitations_sent_total"
+	// MetricSolicitationsReceived is the counter of received solicitation messages.
+	MetricSolicitationsReceived = "bmmc_solicitations_received_total"
+	// MetricSynchronizedElements is the counter of elements received in
+	// synchronization messages and added in buffer.
+	MetricSynchronizedElements = "bmmc_synchronized_elements_total"
+	// MetricCallbackSuccesses is the counter of callbacks that succeeded.
+	MetricCallbackSuccesses = "bmmc_callback_successes_total"
+	// MetricCallbackFailures is the counter of callbacks that failed.
+	MetricCallbackFailures = "bmmc_callback_failures_total"
+	// MetricBufferSize is the gauge of the number of elements in buffer.
+	MetricBufferSize = "bmmc_buffer_size"
+	// MetricBufferEvictions is the counter of elements removed from the full buffer.
+	MetricBufferEvictions = "bmmc_buffer_evictions_total"
+	// MetricPeers is the gauge of the number of peers.
+	MetricPeers = "bmmc_peers"
+	// MetricSendErrors is the counter of messages that could not be sent.
+	MetricSendErrors = "bmmc_send_errors_total"
+	// MetricRoundDuration is the histogram of the gossip round durations, in seconds.
+	MetricRoundDuration = "bmmc_round_duration_seconds"
+	// MetricDeliveryLatency is the histogram of the time from the origin of an
+	// element until it is received by the host, in seconds.
+	MetricDeliveryLatency = "bmmc_delivery_latency_seconds"
+	// MetricDeliveryHops is the histogram of the number of hops of the received elements.
+	MetricDeliveryHops = "bmmc_delivery_hops"
+)
+
+// Metrics receives the metrics of the protocol.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// AddCounter adds the given delta to a counter.
+	AddCounter(name string, delta float64)
+	// SetGauge sets the value of a gauge.
+	SetGauge(name string, value float64)
+	// ObserveHistogram adds an observation to a histogram.
+	ObserveHistogram(name string, value float64)
+}
+
+// LabeledMetrics is a Metrics which supports labels (e.g. metrics.Registry).
+// The instances of the topics of Topics report their metrics with the topic
+// label when the Metrics of the config implements it.
+type LabeledMetrics interface {
+	Metrics
+	// WithLabel returns the metrics which adds the given label to the reported metrics.
+	WithLabel(key, value string) Metrics
+}
+
+// noopMetrics discards all metrics.
+type noopMetrics struct{}
+
+func (noopMetrics) AddCounter(string, float64) {}
+
+func (noopMetrics) SetGauge(string, float64) {}
+
+func (noopMetrics) ObserveHistogram(string, float64) {}
+
+// incCounter increments the given counter.
+func (b *BMMC) incCounter(name string) {
+	b.config.Metrics.AddCounter(name, 1)
+}
+
+// addElement adds the given element in buffer and updates the buffer metrics.
+func (b *BMMC) addElement(el buffer.Element) error {
+	evicted, err := b.messageBuffer.Insert(el)
+	if err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	b.coverageTracker.add(el.ID, b.config.Host.String())
+	b.config.Observer.OnElementAdded(elementInfo(el))
+
+	switch {
+	case evicted == nil:
+	case el.Key != "" && evicted.Key == el.Key:
+		// the older version of the keyed element is not solicited again
+		b.superseded.add(time.Now(), evicted.ID)
+		b.config.Observer.OnElementEvicted(elementInfo(*evicted))
+	default:
+		b.incCounter(MetricBufferEvictions)
+		b.config.Observer.OnElementEvicted(elementInfo(*evicted))
+	}
+
+	b.config.Metrics.SetGauge(MetricBufferSize, float64(b.messageBuffer.Length()))
+
+	return nil
+}
+
+// observeRound reports the metrics of a gossip round started at the given time.
+func (b *BMMC) observeRound(start time.Time) {
+	b.config.Metrics.ObserveHistogram(MetricRoundDuration, time.Since(start).Seconds())
+	b.config.Metrics.SetGauge(MetricPeers, float64(b.peerBuffer.Length()))
+	b.config.Metrics.SetGauge(MetricBufferSize, float64(b.messageBuffer.Length()))
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeMetrics records the reported metrics.
+type fakeMetrics struct {
+	mux          sync.Mutex
+	counters     map[string]float64
+	gauges       map[string]float64
+	observations map[string]int
+}
+
+func newFakeMetrics() *fakeMetrics {
+	return &fakeMetrics{
+		counters:     map[string]float64{},
+		gauges:       map[string]float64{},
+		observations: map[string]int{},
+	}
+}
+
+func (m *fakeMetrics) AddCounter(name string, delta float64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.counters[name] += delta
+}
+
+func (m *fakeMetrics) SetGauge(name string, value float64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.gauges[name] = value
+}
+
+func (m *fakeMetrics) ObserveHistogram(name string, _ float64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.observations[name]++
+}
+
+func (m *fakeMetrics) counter(name string) float64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.counters[name]
+}
+
+func (m *fakeMetrics) gauge(name string) float64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.gauges[name]
+}
+
+func (m *fakeMetrics) observed(name string) int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.observations[name]
+}
+
+var _ = Describe("Metrics", func() {
+	It("reports the protocol metrics", func() {
+		metrics := []*fakeMetrics{newFakeMetrics(), newFakeMetrics()}
+		i := 0
+
+		nodes := newTestCluster(2, func(cfg *Config) {
+			cfg.Metrics = metrics[i]
+			cfg.Callbacks = map[string]func(any, *slog.Logger) error{
+				"ok": func(any, *slog.Logger) error { return nil },
+			}
+			i++
+		})
+
+		Expect(nodes[0].AddMessage(context.Background(), "message", "ok")).To(Succeed())
+
+		Eventually(func() []any { return nodes[1].GetMessages() }).Should(ConsistOf("message"))
+
+		Eventually(func() float64 { return metrics[0].counter(MetricGossipsSent) }).Should(BeNumerically(">", 0))
+		Eventually(func() int { return metrics[0].observed(MetricRoundDuration) }).Should(BeNumerically(">", 0))
+		Eventually(func() float64 { return metrics[0].gauge(MetricPeers) }).Should(Equal(1.0))
+		Expect(metrics[0].counter(MetricCallbackSuccesses)).To(Equal(1.0))
+		Expect(metrics[0].counter(MetricSolicitationsReceived)).To(BeNumerically(">=", 1))
+
+		Expect(metrics[1].counter(MetricGossipsReceived)).To(BeNumerically(">", 0))
+		Expect(metrics[1].counter(MetricSolicitationsSent)).To(BeNumerically(">=", 1))
+		Expect(metrics[1].counter(MetricSynchronizedElements)).To(BeNumerically(">=", 1))
+		Expect(metrics[1].counter(MetricCallbackSuccesses)).To(BeNumerically(">=", 1))
+		Expect(metrics[1].gauge(MetricBufferSize)).To(Equal(1.0))
+		Expect(metrics[1].counter(MetricSendErrors)).To(BeZero())
+	})
+
+	It("counts evicted elements", func() {
+		metrics := newFakeMetrics()
+
+		nodes := newTestCluster(1, func(cfg *Config) {
+			cfg.BufferSize = 2
+			cfg.Metrics = metrics
+		})
+
+		for _, msg := range []string{"a", "b", "c"} {
+			Expect(nodes[0].AddMessage(context.Background(), msg, NOCALLBACK)).To(Succeed())
+		}
+
+		Expect(metrics.counter(MetricBufferEvictions)).To(Equal(1.0))
+		Expect(metrics.gauge(MetricBufferSize)).To(Equal(2.0))
+	})
+})
@@ -0,0 +1,248 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+// ElementInfo describes an element of the messages buffer.
+type ElementInfo struct {
+	ID           string
+	Origin       string
+	CallbackType string
+	Timestamp    time.Time
+	Internal     bool
+}
+
+// Observer is notified about protocol events.
+// The hooks are called synchronously by the protocol, so they must not block.
+// Implementations must be safe for concurrent use.
+type Observer interface {
+	// OnRoundStart is called when a gossip round starts.
+	OnRoundStart(round int64)
+	// OnGossipSent is called when a gossip message is sent to a peer.
+	OnGossipSent(peer string, digestLen int)
+	// OnGossipReceived is called when a gossip message is received from a peer.
+	OnGossipReceived(peer string, digestLen int)
+	// OnSolicitationSent is called when a solicitation message is sent to a peer.
+	OnSolicitationSent(peer string, digestLen int)
+	// OnSolicitationReceived is called when a solicitation message is received from a peer.
+	OnSolicitationReceived(peer string, digestLen int)
+	// OnSynchronizationSent is called when a synchronization message is sent to a peer.
+	// The peer is empty when the message is sent to the multicast group.
+	OnSynchronizationSent(peer string, elements int)
+	// OnSynchronizationReceived is called when a synchronization message is received from a peer.
+	OnSynchronizationReceived(peer string, elements int)
+	// OnElementAdded is called when an element is added in the messages buffer.
+	OnElementAdded(el ElementInfo)
+	// OnElementEvicted is called when an element is removed from the full messages buffer,
+	// when a stable element is removed early from the messages buffer or when an
+	// older version of a keyed element is replaced by a newer one.
+	OnElementEvicted(el ElementInfo)
+	// OnElementStable is called when an element from the messages buffer becomes
+	// stable, i.e. it is known to be received by all members.
+	OnElementStable(el ElementInfo)
+	// OnPeerAdded is called when a peer is added in the peers buffer.
+	OnPeerAdded(peer string)
+	// OnPeerRemoved is called when a peer is removed from the peers buffer.
+	OnPeerRemoved(peer string)
+	// OnSendError is called when a message cannot be sent to a peer.
+	OnSendError(peer string, route string, err error)
+}
+
+// TopicObserver is an Observer which observes the topics of Topics separately.
+// The instance of each topic notifies the observer returned by Topic instead of
+// the Observer of the config.
+type TopicObserver interface {
+	Observer
+	// Topic returns the observer of the events of the given topic.
+	Topic(topic string) Observer
+}
+
+// BaseObserver ignores all protocol events.
+// It can be embedded by observers interested only in some events.
+type BaseObserver struct{}
+
+// OnRoundStart does nothing.
+func (BaseObserver) OnRoundStart(int64) {}
+
+// OnGossipSent does nothing.
+func (BaseObserver) OnGossipSent(string, int) {}
+
+// OnGossipReceived does nothing.
+func (BaseObserver) OnGossipReceived(string, int) {}
+
+// OnSolicitationSent does nothing.
+func (BaseObserver) OnSolicitationSent(string, int) {}
+
+// OnSolicitationReceived does nothing.
+func (BaseObserver) OnSolicitationReceived(string, int) {}
+
+// OnSynchronizationSent does nothing.
+func (BaseObserver) OnSynchronizationSent(string, int) {}
+
+// OnSynchronizationReceived does nothing.
+func (BaseObserver) OnSynchronizationReceived(string, int) {}
+
+// OnElementAdded does nothing.
+func (BaseObserver) OnElementAdded(ElementInfo) {}
+
+// OnElementEvicted does nothing.
+func (BaseObserver) OnElementEvicted(ElementInfo) {}
+
+// OnElementStable does nothing.
+func (BaseObserver) OnElementStable(ElementInfo) {}
+
+// OnPeerAdded does nothing.
+func (BaseObserver) OnPeerAdded(string) {}
+
+// OnPeerRemoved does nothing.
+func (BaseObserver) OnPeerRemoved(string) {}
+
+// OnSendError does nothing.
+func (BaseObserver) OnSendError(string, string, error) {}
+
+// Observers notifies all its observers about protocol events, in order.
+type Observers []Observer
+
+// Topic returns the observers of the given topic. The observers which implement
+// TopicObserver are replaced by their observers of the topic.
+func (o Observers) Topic(topic string) Observer {
+	observers := make(Observers, len(o))
+
+	for i, observer := range o {
+		if topicObserver, ok := observer.(TopicObserver); ok {
+			observers[i] = topicObserver.Topic(topic)
+		} else {
+			observers[i] = observer
+		}
+	}
+
+	return observers
+}
+
+// OnRoundStart notifies all observers.
+func (o Observers) OnRoundStart(round int64) {
+	for _, observer := range o {
+		observer.OnRoundStart(round)
+	}
+}
+
+// OnGossipSent notifies all observers.
+func (o Observers) OnGossipSent(peer string, digestLen int) {
+	for _, observer := range o {
+		observer.OnGossipSent(peer, digestLen)
+	}
+}
+
+// OnGossipReceived notifies all observers.
+func (o Observers) OnGossipReceived(peer string, digestLen int) {
+	for _, observer := range o {
+		observer.OnGossipReceived(peer, digestLen)
+	}
+}
+
+// OnSolicitationSent notifies all observers.
+func (o Observers) OnSolicitationSent(peer string, digestLen int) {
+	for _, observer := range o {
+		observer.OnSolicitationSent(peer, digestLen)
+	}
+}
+
+// OnSolicitationReceived notifies all observers.
+func (o Observers) OnSolicitationReceived(peer string, digestLen int) {
+	for _, observer := range o {
+		observer.OnSolicitationReceived(peer, digestLen)
+	}
+}
+
+// OnSynchronizationSent notifies all observers.
+func (o Observers) OnSynchronizationSent(peer string, elements int) {
+	for _, observer := range o {
+		observer.OnSynchronizationSent(peer, elements)
+	}
+}
+
+// OnSynchronizationReceived notifies all observers.
+func (o Observers) OnSynchronizationReceived(peer string, elements int) {
+	for _, observer := range o {
+		observer.OnSynchronizationReceived(peer, elements)
+	}
+}
+
+// OnElementAdded notifies all observers.
+func (o Observers) OnElementAdded(el ElementInfo) {
+	for _, observer := range o {
+		observer.OnElementAdded(el)
+	}
+}
+
+// OnElementEvicted notifies all observers.
+func (o Observers) OnElementEvicted(el ElementInfo) {
+	for _, observer := range o {
+		observer.OnElementEvicted(el)
+	}
+}
+
+// OnElementStable notifies all observers.
+func (o Observers) OnElementStable(el ElementInfo) {
+	for _, observer := range o {
+		observer.OnElementStable(el)
+	}
+}
+
+// OnPeerAdded notifies all observers.
+func (o Observers) OnPeerAdded(peer string) {
+	for _, observer := range o {
+		observer.OnPeerAdded(peer)
+	}
+}
+
+// OnPeerRemoved notifies all observers.
+func (o Observers) OnPeerRemoved(peer string) {
+	for _, observer := range o {
+		observer.OnPeerRemoved(peer)
+	}
+}
+
+// OnSendError notifies all observers.
+func (o Observers) OnSendError(peer string, route string, err error) {
+	for _, observer := range o {
+		observer.OnSendError(peer, route, err)
+	}
+}
+
+// elementInfo returns the description of given element.
+func elementInfo(el buffer.Element) ElementInfo {
+	return ElementInfo{
+		ID:           el.ID,
+		Origin:       el.Origin,
+		CallbackType: el.CallbackType,
+		Timestamp:    el.Timestamp,
+		Internal:     el.Internal,
+	}
+}
+
+// sendFailed reports a message that cannot be sent to the given peer.
+func (b *BMMC) sendFailed(peer string, route string, err error) {
+	b.incCounter(MetricSendErrors)
+	b.sendErrors.add(SendError{Time: time.Now(), Peer: peer, Route: route, Err: err.Error()})
+	b.config.Observer.OnSendError(peer, route, err)
+}
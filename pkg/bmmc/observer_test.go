@@ -0,0 +1,187 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// recordingObserver records some protocol events.
+type recordingObserver struct {
+	BaseObserver
+
+	mux     sync.Mutex
+	rounds  int
+	gossips map[string]int
+	added   []string
+	evicted []string
+	stable  []string
+	peers   []string
+	errors  []string
+}
+
+func newRecordingObserver() *recordingObserver {
+	return &recordingObserver{gossips: map[string]int{}}
+}
+
+func (o *recordingObserver) OnRoundStart(int64) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.rounds++
+}
+
+func (o *recordingObserver) OnGossipReceived(peer string, _ int) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.gossips[peer]++
+}
+
+func (o *recordingObserver) OnElementAdded(el ElementInfo) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.added = append(o.added, el.ID)
+}
+
+func (o *recordingObserver) OnElementEvicted(el ElementInfo) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.evicted = append(o.evicted, el.ID)
+}
+
+func (o *recordingObserver) OnElementStable(el ElementInfo) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.stable = append(o.stable, el.ID)
+}
+
+func (o *recordingObserver) OnPeerAdded(peer string) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.peers = append(o.peers, "+"+peer)
+}
+
+func (o *recordingObserver) OnPeerRemoved(peer string) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.peers = append(o.peers, "-"+peer)
+}
+
+func (o *recordingObserver) OnSendError(peer string, route string, _ error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.errors = append(o.errors, peer+route)
+}
+
+func (o *recordingObserver) snapshot() recordingObserver {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	gossips := map[string]int{}
+	for k, v := range o.gossips {
+		gossips[k] = v
+	}
+
+	return recordingObserver{
+		rounds:  o.rounds,
+		gossips: gossips,
+		added:   append([]string{}, o.added...),
+		evicted: append([]string{}, o.evicted...),
+		stable:  append([]string{}, o.stable...),
+		peers:   append([]string{}, o.peers...),
+		errors:  append([]string{}, o.errors...),
+	}
+}
+
+var _ = Describe("Observer", func() {
+	It("notifies all observers about protocol events", func() {
+		first, second := newRecordingObserver(), newRecordingObserver()
+		receiver := newRecordingObserver()
+		i := 0
+
+		nodes := newTestCluster(2, func(cfg *Config) {
+			cfg.BufferSize = 2
+
+			if i == 0 {
+				cfg.Observer = Observers{first, second}
+			} else {
+				cfg.Observer = receiver
+			}
+
+			i++
+		})
+
+		for _, msg := range []string{"a", "b", "c"} {
+			Expect(nodes[0].AddMessage(context.Background(), msg, NOCALLBACK)).To(Succeed())
+		}
+
+		Expect(nodes[0].AddPeer("n9")).To(Succeed())
+
+		Eventually(func() []string { return receiver.snapshot().peers }).Should(ContainElement("+n9"))
+		Eventually(func() []string { return first.snapshot().errors }).Should(ContainElement("n9" + GossipRoute))
+		Eventually(func() map[string]int { return receiver.snapshot().gossips }).Should(HaveKey("n0"))
+
+		Expect(nodes[0].RemovePeer("n9")).To(Succeed())
+
+		Eventually(func() []string { return receiver.snapshot().peers }).Should(ContainElement("-n9"))
+
+		for _, o := range []*recordingObserver{first, second} {
+			events := o.snapshot()
+			Expect(events.rounds).To(BeNumerically(">", 0))
+			Expect(events.added).To(HaveLen(5))
+			Expect(events.evicted).To(HaveLen(3))
+			Expect(events.peers).To(Equal([]string{"+n9", "-n9"}))
+		}
+	})
+
+	It("ignores events with BaseObserver", func() {
+		var observer Observer = BaseObserver{}
+
+		Expect(func() {
+			observer.OnSendError("n1", GossipRoute, errors.New("error"))
+			observer.OnElementAdded(ElementInfo{ID: "id"})
+		}).ToNot(Panic())
+	})
+
+	It("replaces the topic observers with their observers of the topic", func() {
+		plain := newRecordingObserver()
+		topics := &topicObservers{
+			recordingObserver: newRecordingObserver(),
+			mux:               &sync.Mutex{},
+			topics:            map[string]*recordingObserver{},
+		}
+
+		observer := Observers{plain, topics}.Topic("news")
+		observer.OnElementAdded(ElementInfo{ID: "id"})
+
+		Expect(plain.snapshot().added).To(Equal([]string{"id"}))
+		Expect(topics.snapshot().added).To(BeEmpty())
+		Expect(topics.topic("news").snapshot().added).To(Equal([]string{"id"}))
+	})
+})
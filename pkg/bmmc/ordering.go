@@ -0,0 +1,696 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+const (
+	defaultHoldBackRounds = 10
+	// maxSolicitedSequences is the maximum number of missing sequence numbers
+	// solicited at once from an origin.
+	maxSolicitedSequences = 64
+)
+
+// DeliveryOrder is the order in which received user messages are delivered to callbacks.
+type DeliveryOrder int
+
+const (
+	// UnorderedDelivery delivers the messages in the order they are received.
+	UnorderedDelivery DeliveryOrder = iota
+	// FIFODelivery delivers the messages from each origin in the order they
+	// were added by the origin.
+	FIFODelivery
+	// CausalDelivery delivers the messages after the messages delivered by their
+	// origin before it added them (and in FIFO order).
+	CausalDelivery
+)
+
+// String returns the name of the delivery order.
+func (o DeliveryOrder) String() string {
+	switch o {
+	case UnorderedDelivery:
+		return "unordered"
+	case FIFODelivery:
+		return "fifo"
+	case CausalDelivery:
+		return "causal"
+	default:
+		return fmt.Sprintf("DeliveryOrder(%d)", int(o))
+	}
+}
+
+// elementOrder is the position of an element originated by the host in the delivery order.
+type elementOrder struct {
+	// epoch is the incarnation of the host and seq is the sequence number of the element
+	epoch uint64
+	seq   uint64
+	// unique makes the ID of the element unique per origin and sequence number, so
+	// the same message added again is delivered again in order; otherwise the ID
+	// depends only on the message, and the same message is added only once
+	unique bool
+	// clock is the sequence number of the last element delivered from each origin
+	clock map[string]uint64
+	// lamport is the Lamport timestamp of the element, or 0 if it is not delivered in total order
+	lamport uint64
+}
+
+// sequencer assigns consecutive sequence numbers to the user messages originated
+// by the host, and keeps the Lamport clock of the host. The sequence numbers
+// start from 1 in each epoch, i.e. each time the host is started, so the epoch
+// is the start time of the host.
+type sequencer struct {
+	mux     *sync.Mutex
+	epoch   uint64
+	last    uint64
+	lamport uint64
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{
+		mux:   &sync.Mutex{},
+		epoch: uint64(time.Now().UnixNano()),
+	}
+}
+
+// clock returns the Lamport clock of the host.
+func (s *sequencer) clock() uint64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.lamport
+}
+
+// mark returns the Lamport clock and the last sequence number of the host.
+// All messages up to the sequence number have timestamps up to the clock.
+func (s *sequencer) mark() (uint64, uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.lamport, s.last
+}
+
+// epochs tracks the current epoch of each origin. An origin gets a new epoch
+// when it restarts, and numbers its elements from 1 again.
+// Whoever uses epochs must LOCK them, e.g. with the lock of the tracker.
+type epochs map[string]uint64
+
+// observe records the epoch of an element from the given origin. It returns a
+// negative number if the element is from a previous epoch of the origin, a
+// positive number if the origin restarted, i.e. the state kept for the origin
+// must be reset, and 0 otherwise.
+func (e epochs) observe(origin string, epoch uint64) int {
+	current, ok := e[origin]
+
+	switch {
+	case !ok:
+		e[origin] = epoch
+
+		return 0
+	case epoch < current:
+		return -1
+	case epoch > current:
+		e[origin] = epoch
+
+		return 1
+	default:
+		return 0
+	}
+}
+
+// current returns true if the given epoch is the current epoch of the origin.
+func (e epochs) current(origin string, epoch uint64) bool {
+	current, ok := e[origin]
+
+	return !ok || current == epoch
+}
+
+// witness advances the Lamport clock of the host to the given timestamp.
+func (s *sequencer) witness(lamport uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if lamport > s.lamport {
+		s.lamport = lamport
+	}
+}
+
+// heldElement is a received element held back until it can be delivered.
+type heldElement struct {
+	// trace contains the span context of the element, which is restored when
+	// the element is delivered
+	trace    map[string]string
+	el       buffer.Element
+	peer     string
+	received time.Time
+}
+
+// PendingMessage is a received message held back by the delivery order.
+type PendingMessage struct {
+	ElementInfo
+
+	// Seq is the sequence number of the message from its origin.
+	Seq uint64
+	// Waiting contains the sequence number of the last message from each origin
+	// that must be delivered before the message, for the origins that are behind.
+	Waiting map[string]uint64
+	// HeldSince is the time when the message was received.
+	HeldSince time.Time
+	// Lamport is the Lamport timestamp of a message of an ordered callback.
+	Lamport uint64
+	// Unstable contains the members which are not known to have advanced past
+	// the Lamport timestamp of a message of an ordered callback.
+	Unstable []string
+	// WaitingUntil contains the time until which each unstable member (except the
+	// host) is waited for, unless it is seen again, before it is considered silent.
+	WaitingUntil map[string]time.Time
+}
+
+// holdBackQueue holds back the received elements until the elements before them
+// are delivered. Origins number their elements from 1 in each epoch. In FIFO order,
+// an element is delivered after the previous elements from the same origin. In
+// causal order, it is also delivered after the elements from its clock, which were
+// delivered by the origin before it created the element.
+type holdBackQueue struct {
+	mux     *sync.Mutex
+	causal  bool
+	limit   int
+	timeout time.Duration
+	epochs  epochs
+	// next is the sequence number of the next element to deliver, per origin
+	next map[string]uint64
+	// held are the elements held back, per origin and sequence number
+	held map[string]map[uint64]heldElement
+	len  int
+}
+
+func newHoldBackQueue(causal bool, limit int, timeout time.Duration) *holdBackQueue {
+	return &holdBackQueue{
+		mux:     &sync.Mutex{},
+		causal:  causal,
+		limit:   limit,
+		timeout: timeout,
+		epochs:  epochs{},
+		next:    map[string]uint64{},
+		held:    map[string]map[uint64]heldElement{},
+	}
+}
+
+// nextSeq returns the sequence number of the next element to deliver from given origin.
+func (q *holdBackQueue) nextSeq(origin string) uint64 {
+	if next, ok := q.next[origin]; ok {
+		return next
+	}
+
+	return 1
+}
+
+// delivered records that the element with given sequence number was
+// delivered without being held back (e.g. it was added by the host).
+func (q *holdBackQueue) delivered(origin string, epoch uint64, seq uint64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.epochs.observe(origin, epoch) < 0 {
+		return
+	}
+
+	if seq >= q.nextSeq(origin) {
+		q.next[origin] = seq + 1
+	}
+}
+
+// clock returns the sequence number of the last element delivered from each
+// origin, except the given one.
+func (q *holdBackQueue) clock(except string) map[string]uint64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	clock := map[string]uint64{}
+
+	for origin, next := range q.next {
+		if origin != except && next > 1 {
+			clock[origin] = next - 1
+		}
+	}
+
+	return clock
+}
+
+// push adds a received element in queue. It returns the elements that can be
+// delivered, in order, and the number of skipped elements.
+// When the origin of the element restarted, the elements held back from its
+// previous epoch are delivered and its elements are numbered from 1 again.
+func (q *holdBackQueue) push(h heldElement) ([]heldElement, uint64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	origin, seq := h.el.Origin, h.el.Seq
+
+	var ready []heldElement
+
+	switch q.epochs.observe(origin, h.el.Epoch) {
+	case -1:
+		// the element is from a previous epoch of its origin
+		return nil, 0
+	case 1:
+		ready = q.restart(origin)
+	}
+
+	if seq < q.nextSeq(origin) {
+		// the element was already delivered or skipped
+		return ready, 0
+	}
+
+	if _, ok := q.held[origin][seq]; ok {
+		return ready, 0
+	}
+
+	if q.held[origin] == nil {
+		q.held[origin] = map[uint64]heldElement{}
+	}
+
+	q.held[origin][seq] = h
+	q.len++
+
+	ready = append(ready, q.release()...)
+
+	var skipped uint64
+
+	for q.limit > 0 && q.len > q.limit {
+		r, s := q.skip(q.oldest())
+
+		ready = append(ready, r...)
+		skipped += s
+	}
+
+	return ready, skipped
+}
+
+// expire skips the missing elements before the elements held back longer than
+// the timeout. It returns the elements that can be delivered and the number of
+// skipped elements.
+func (q *holdBackQueue) expire(now time.Time) ([]heldElement, uint64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	var (
+		ready   []heldElement
+		skipped uint64
+	)
+
+	for q.len > 0 {
+		oldest := q.oldest()
+		if now.Sub(oldest.received) < q.timeout {
+			break
+		}
+
+		r, s := q.skip(oldest)
+
+		ready = append(ready, r...)
+		skipped += s
+	}
+
+	return ready, skipped
+}
+
+// waiting returns the sequence number of the last element from each origin that
+// must be delivered before the given element, for the origins that are behind.
+func (q *holdBackQueue) waiting(el buffer.Element) map[string]uint64 {
+	waiting := map[string]uint64{}
+
+	if el.Seq > q.nextSeq(el.Origin) {
+		waiting[el.Origin] = el.Seq - 1
+	}
+
+	if !q.causal {
+		return waiting
+	}
+
+	for origin, seq := range el.Clock {
+		if origin != el.Origin && seq >= q.nextSeq(origin) {
+			waiting[origin] = seq
+		}
+	}
+
+	return waiting
+}
+
+// missing returns the missing sequence numbers of the elements held back,
+// indexed by the peer which sent the element held back and by origin.
+func (q *holdBackQueue) missing() map[string]map[string][]uint64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	missing := map[string]map[string][]uint64{}
+	solicited := map[string]map[uint64]struct{}{}
+
+	for _, h := range q.sorted() {
+		for origin, last := range q.waiting(h.el) {
+			if solicited[origin] == nil {
+				solicited[origin] = map[uint64]struct{}{}
+			}
+
+			for seq := q.nextSeq(origin); seq <= last && len(solicited[origin]) < maxSolicitedSequences; seq++ {
+				if _, ok := q.held[origin][seq]; ok {
+					continue
+				}
+
+				if _, ok := solicited[origin][seq]; ok {
+					continue
+				}
+
+				solicited[origin][seq] = struct{}{}
+
+				if missing[h.peer] == nil {
+					missing[h.peer] = map[string][]uint64{}
+				}
+
+				missing[h.peer][origin] = append(missing[h.peer][origin], seq)
+			}
+		}
+	}
+
+	return missing
+}
+
+// pending returns the elements held back, sorted by origin and sequence number.
+func (q *holdBackQueue) pending() []PendingMessage {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	held := q.sorted()
+	pending := make([]PendingMessage, len(held))
+
+	for i, h := range held {
+		pending[i] = PendingMessage{
+			ElementInfo: elementInfo(h.el),
+			Seq:         h.el.Seq,
+			Waiting:     q.waiting(h.el),
+			HeldSince:   h.received,
+		}
+	}
+
+	return pending
+}
+
+// release removes the elements that can be delivered, in order.
+func (q *holdBackQueue) release() []heldElement {
+	var ready []heldElement
+
+	for released := true; released; {
+		released = false
+
+		for _, origin := range q.origins() {
+			for {
+				h, ok := q.held[origin][q.nextSeq(origin)]
+				if !ok || len(q.waiting(h.el)) > 0 {
+					break
+				}
+
+				ready = append(ready, h)
+				q.remove(h.el)
+				q.next[origin] = h.el.Seq + 1
+				released = true
+			}
+		}
+	}
+
+	return ready
+}
+
+// skip gives up on the missing elements that must be delivered before the given
+// element. The elements held back before it are delivered, even if their own
+// predecessors are missing. It returns the elements that can be delivered and
+// the number of skipped elements.
+func (q *holdBackQueue) skip(h heldElement) ([]heldElement, uint64) {
+	var (
+		ready   []heldElement
+		skipped uint64
+	)
+
+	for origin, last := range q.waiting(h.el) {
+		next := q.nextSeq(origin)
+		skipped += last + 1 - next
+
+		for _, held := range q.sortedFrom(origin) {
+			if held.el.Seq > last {
+				break
+			}
+
+			ready = append(ready, held)
+			q.remove(held.el)
+			skipped--
+		}
+
+		q.next[origin] = last + 1
+	}
+
+	return append(ready, q.release()...), skipped
+}
+
+// restart forgets the delivered elements of the given origin, since it restarted,
+// and returns the elements held back from its previous epoch, in order.
+func (q *holdBackQueue) restart(origin string) []heldElement {
+	ready := q.sortedFrom(origin)
+
+	for _, h := range ready {
+		q.remove(h.el)
+	}
+
+	delete(q.next, origin)
+
+	return ready
+}
+
+// remove removes the given element from queue.
+func (q *holdBackQueue) remove(el buffer.Element) {
+	delete(q.held[el.Origin], el.Seq)
+	q.len--
+
+	if len(q.held[el.Origin]) == 0 {
+		delete(q.held, el.Origin)
+	}
+}
+
+// oldest returns the element held back for the longest time.
+func (q *holdBackQueue) oldest() heldElement {
+	var oldest heldElement
+
+	for _, held := range q.held {
+		for _, h := range held {
+			if oldest.received.IsZero() || h.received.Before(oldest.received) {
+				oldest = h
+			}
+		}
+	}
+
+	return oldest
+}
+
+// sorted returns the elements held back, sorted by origin and sequence number.
+func (q *holdBackQueue) sorted() []heldElement {
+	sorted := make([]heldElement, 0, q.len)
+
+	for _, origin := range q.origins() {
+		sorted = append(sorted, q.sortedFrom(origin)...)
+	}
+
+	return sorted
+}
+
+// sortedFrom returns the elements held back from given origin, sorted by sequence number.
+func (q *holdBackQueue) sortedFrom(origin string) []heldElement {
+	sorted := make([]heldElement, 0, len(q.held[origin]))
+
+	for _, h := range q.held[origin] {
+		sorted = append(sorted, h)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].el.Seq < sorted[j].el.Seq
+	})
+
+	return sorted
+}
+
+// origins returns the sorted origins with elements held back.
+func (q *holdBackQueue) origins() []string {
+	origins := make([]string, 0, len(q.held))
+
+	for origin := range q.held {
+		origins = append(origins, origin)
+	}
+
+	sort.Strings(origins)
+
+	return origins
+}
+
+// addUserElement creates a user element originated by the host and adds it in buffer.
+// The element gets the next sequence number of the host, which is consumed only
+// if the element is added in buffer. Elements of ordered callbacks also get the next
+// Lamport timestamp and are held back in the total order queue.
+// The register function of the message is called with the element ID before the
+// element is added in buffer, i.e. before it can be sent to peers.
+func (b *BMMC) addUserElement(ctx context.Context, um userMessage) (buffer.Element, error) {
+	b.sequencer.mux.Lock()
+	defer b.sequencer.mux.Unlock()
+
+	ordered := b.totalOrder != nil && b.orderedCallback(um.callbackType)
+
+	order := elementOrder{
+		epoch:  b.sequencer.epoch,
+		seq:    b.sequencer.last + 1,
+		unique: b.holdBack != nil || ordered,
+	}
+
+	if ordered {
+		order.lamport = b.sequencer.lamport + 1
+	}
+
+	if b.config.Delivery == CausalDelivery {
+		order.clock = b.holdBack.clock(b.config.Host.String())
+	}
+
+	m, err := b.newUserElement(um, order)
+	if err != nil {
+		return buffer.Element{}, err
+	}
+
+	if um.register != nil {
+		um.register(m.ID)
+	}
+
+	if err := b.addElement(m); err != nil {
+		return m, err
+	}
+
+	b.sequencer.last = order.seq
+	b.stability.receive(m.Origin, order.epoch, order.seq, time.Now())
+
+	if b.holdBack != nil {
+		b.holdBack.delivered(m.Origin, order.epoch, order.seq)
+	}
+
+	if ordered {
+		b.sequencer.lamport = order.lamport
+		b.totalOrder.push(b.holdElement(ctx, m, ""))
+	}
+
+	return m, nil
+}
+
+// deliver runs the callbacks of a received element in the configured delivery
+// order. Out of order elements are held back and the missing elements are
+// solicited from the peer which sent the element.
+func (b *BMMC) deliver(ctx context.Context, el buffer.Element, p string) {
+	if el.Lamport > 0 {
+		b.sequencer.witness(el.Lamport)
+	}
+
+	if b.holdBack == nil || el.Internal || el.Seq == 0 {
+		b.dispatch(ctx, el)
+
+		return
+	}
+
+	ready, skipped := b.holdBack.push(b.holdElement(ctx, el, p))
+
+	b.deliverHeld(ready, skipped)
+
+	if len(ready) == 0 {
+		b.solicitSequences(b.holdBack.missing())
+	}
+}
+
+// releaseHeldBack delivers the elements held back longer than the hold-back
+// timeout and solicits the missing elements again.
+func (b *BMMC) releaseHeldBack() {
+	if b.holdBack == nil {
+		return
+	}
+
+	b.deliverHeld(b.holdBack.expire(time.Now()))
+	b.solicitSequences(b.holdBack.missing())
+}
+
+// deliverHeld dispatches the elements that are no longer held back.
+func (b *BMMC) deliverHeld(ready []heldElement, skipped uint64) {
+	if skipped > 0 {
+		b.stats.skippedMessages.Add(skipped)
+		b.config.Logger.Warn("skipped missing messages", "count", skipped)
+	}
+
+	for _, h := range ready {
+		b.dispatch(b.heldContext(h), h.el)
+	}
+}
+
+// holdElement returns the given element received from the given peer, to be
+// held back. Only the span context from the given context is kept.
+func (b *BMMC) holdElement(ctx context.Context, el buffer.Element, p string) heldElement {
+	return heldElement{trace: b.traceHeaders(ctx), el: el, peer: p, received: time.Now()}
+}
+
+// heldContext returns a context with the span context of the given element held back.
+func (b *BMMC) heldContext(h heldElement) context.Context {
+	return b.config.Tracer.Extract(context.Background(), h.trace)
+}
+
+// solicitSequences solicits the missing elements, given by their sequence
+// numbers indexed by the peer to solicit and by origin.
+func (b *BMMC) solicitSequences(missing map[string]map[string][]uint64) {
+	// the gossip round is copied, since it is incremented by the gossiper
+	roundNumber := &GossipRound{Number: b.gossipRound.GetNumber(), Mux: &sync.RWMutex{}}
+
+	for p, sequences := range missing {
+		solicitationMsg := Solicitation{
+			Host:        b.config.Host.String(),
+			RoundNumber: roundNumber,
+			Sequences:   sequences,
+		}
+
+		b.config.Observer.OnSolicitationSent(p, len(sequences))
+
+		b.sendSolicitation(solicitationMsg, p) //nolint: errcheck
+	}
+}
+
+// Pending returns the user messages held back by the delivery order (or by the
+// total order of ordered callbacks), together with what they are waiting for.
+func (b *BMMC) Pending() []PendingMessage {
+	pending := []PendingMessage{}
+
+	if b.holdBack != nil {
+		pending = append(pending, b.holdBack.pending()...)
+	}
+
+	if b.totalOrder != nil {
+		pending = append(pending,
+			b.totalOrder.pending(b.peerBuffer.GetPeers(), b.sequencer.clock(), b.config.Host.String(), time.Now())...)
+	}
+
+	return pending
+}
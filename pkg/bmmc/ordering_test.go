@@ -0,0 +1,340 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+var _ = Describe("Ordered delivery", func() {
+	var (
+		delivered map[string][]any
+		mux       *sync.Mutex
+	)
+
+	deliveredFn := func(host string) func() []any {
+		return func() []any {
+			mux.Lock()
+			defer mux.Unlock()
+
+			return append([]any{}, delivered[host]...)
+		}
+	}
+
+	newOrderedCluster := func(size int, order DeliveryOrder, customize func(*Config)) []*BMMC {
+		// late callbacks of a previous cluster must not change the messages of this cluster
+		clusterDelivered, clusterMux := delivered, mux
+
+		return newTestCluster(size, func(cfg *Config) {
+			host := cfg.Host.String()
+
+			cfg.Delivery = order
+			cfg.Callbacks = map[string]func(any, *slog.Logger) error{
+				"my-callback": func(data any, _ *slog.Logger) error {
+					clusterMux.Lock()
+					defer clusterMux.Unlock()
+
+					clusterDelivered[host] = append(clusterDelivered[host], data.(Delivery).Msg)
+
+					return nil
+				},
+			}
+
+			if customize != nil {
+				customize(cfg)
+			}
+		})
+	}
+
+	// synchronizeEpoch sends to b a synchronization message with an element
+	// created by the given origin in the given epoch.
+	synchronizeEpoch := func(b *BMMC, origin string, epoch uint64, seq uint64, clock map[string]uint64) {
+		name := fmt.Sprintf("%s-%d", origin, seq)
+		if epoch > 0 {
+			name = fmt.Sprintf("%s-%d-%d", origin, epoch, seq)
+		}
+
+		el, err := json.Marshal(buffer.Element{
+			ID:           name,
+			Timestamp:    time.Now(),
+			Msg:          name,
+			CallbackType: "my-callback",
+			Origin:       origin,
+			Seq:          seq,
+			Epoch:        epoch,
+			Clock:        clock,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = b.Handle(context.Background(), SynchronizationRoute,
+			[]byte(fmt.Sprintf(`{"host":"n9","elements":[%s]}`, el)))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	// synchronize sends to b a synchronization message with an element
+	// created by the given origin.
+	synchronize := func(b *BMMC, origin string, seq uint64, clock map[string]uint64) {
+		synchronizeEpoch(b, origin, 0, seq, clock)
+	}
+
+	BeforeEach(func() {
+		delivered = map[string][]any{}
+		mux = &sync.Mutex{}
+	})
+
+	It("returns error when delivery order is invalid", func() {
+		_, err := New(&Config{
+			Host:       &fakeHost{},
+			BufferSize: 25,
+			Delivery:   DeliveryOrder(42),
+		})
+		Expect(err).To(MatchError(errInvalidDeliveryOrder))
+	})
+
+	It("numbers the messages added by the host", func() {
+		b := newOrderedCluster(1, FIFODelivery, nil)[0]
+
+		Expect(b.AddMessage(context.Background(), "first", NOCALLBACK)).To(Succeed())
+		Expect(b.AddMessage(context.Background(), "first", NOCALLBACK)).To(Succeed())
+		Expect(b.AddMessage(context.Background(), "second", NOCALLBACK)).To(Succeed())
+
+		seqs := map[uint64]any{}
+		for _, el := range b.messageBuffer.UserElements() {
+			seqs[el.Seq] = el.Msg
+		}
+
+		Expect(seqs).To(Equal(map[uint64]any{1: "first", 2: "first", 3: "second"}))
+	})
+
+	It("adds the same message only once without ordered delivery", func() {
+		b := newOrderedCluster(1, UnorderedDelivery, nil)[0]
+
+		Expect(b.AddMessage(context.Background(), "first", NOCALLBACK)).To(Succeed())
+		Expect(b.AddMessage(context.Background(), "first", NOCALLBACK)).To(Succeed())
+		Expect(b.AddMessage(context.Background(), "second", NOCALLBACK)).To(Succeed())
+
+		seqs := map[uint64]any{}
+		for _, el := range b.messageBuffer.UserElements() {
+			seqs[el.Seq] = el.Msg
+		}
+
+		Expect(seqs).To(Equal(map[uint64]any{1: "first", 2: "second"}))
+	})
+
+	Context("in FIFO order", func() {
+		It("delivers the messages from each origin in order", func() {
+			b := newOrderedCluster(1, FIFODelivery, nil)[0]
+
+			synchronize(b, "n8", 2, nil)
+			synchronize(b, "n7", 1, nil)
+			synchronize(b, "n8", 3, nil)
+			Expect(deliveredFn("n0")()).To(Equal([]any{"n7-1"}))
+
+			synchronize(b, "n8", 1, nil)
+			Expect(deliveredFn("n0")()).To(Equal([]any{"n7-1", "n8-1", "n8-2", "n8-3"}))
+
+			// already delivered messages are ignored
+			synchronize(b, "n7", 1, nil)
+			Expect(deliveredFn("n0")()).To(HaveLen(4))
+		})
+
+		It("delivers the same message added by different origins", func() {
+			nodes := newOrderedCluster(3, FIFODelivery, func(cfg *Config) {
+				cfg.HoldBackTimeout = time.Hour
+			})
+
+			// n2 receives the messages only from the test
+			Expect(nodes[0].peerBuffer.RemovePeer("n2")).To(BeTrue())
+			Expect(nodes[1].peerBuffer.RemovePeer("n2")).To(BeTrue())
+
+			Expect(nodes[0].AddMessage(context.Background(), "same", "my-callback")).To(Succeed())
+			Expect(nodes[1].AddMessage(context.Background(), "same", "my-callback")).To(Succeed())
+			Expect(nodes[1].AddMessage(context.Background(), "next", "my-callback")).To(Succeed())
+
+			fromN0 := nodes[0].messageBuffer.ElementsFromSequences(map[string][]uint64{"n0": {1}})
+			fromN1 := nodes[1].messageBuffer.ElementsFromSequences(map[string][]uint64{"n1": {1, 2}})
+			Expect(fromN0).To(HaveLen(1))
+			Expect(fromN1).To(HaveLen(2))
+			Expect(fromN1).ToNot(ContainElement(HaveField("ID", fromN0[0].ID)))
+
+			for _, el := range append(fromN0, fromN1...) {
+				nodes[2].synchronizeElement(context.Background(), el, el.Origin)
+			}
+
+			Eventually(deliveredFn("n2")).Should(ConsistOf("same", "same", "next"))
+			Expect(nodes[2].Pending()).To(BeEmpty())
+		})
+
+		It("delivers the messages from an origin that restarted", func() {
+			b := newOrderedCluster(1, FIFODelivery, func(cfg *Config) {
+				cfg.HoldBackTimeout = time.Hour
+			})[0]
+
+			synchronizeEpoch(b, "n8", 1, 1, nil)
+			synchronizeEpoch(b, "n8", 1, 3, nil)
+			synchronizeEpoch(b, "n8", 2, 2, nil)
+			Expect(deliveredFn("n0")()).To(Equal([]any{"n8-1-1", "n8-1-3"}))
+
+			synchronizeEpoch(b, "n8", 2, 1, nil)
+			Expect(deliveredFn("n0")()).To(Equal([]any{"n8-1-1", "n8-1-3", "n8-2-1", "n8-2-2"}))
+			Expect(b.Pending()).To(BeEmpty())
+
+			// the messages from the previous epoch are ignored
+			synchronizeEpoch(b, "n8", 1, 2, nil)
+			Expect(deliveredFn("n0")()).To(HaveLen(4))
+		})
+
+		It("numbers the messages of the host in a new epoch after a restart", func() {
+			first := newOrderedCluster(1, FIFODelivery, nil)[0]
+			restarted := newOrderedCluster(1, FIFODelivery, nil)[0]
+
+			Expect(first.AddMessage(context.Background(), "message", NOCALLBACK)).To(Succeed())
+			Expect(restarted.AddMessage(context.Background(), "message", NOCALLBACK)).To(Succeed())
+
+			before, after := first.messageBuffer.UserElements(), restarted.messageBuffer.UserElements()
+			Expect(before).To(HaveLen(1))
+			Expect(after).To(HaveLen(1))
+			Expect(after[0].Seq).To(Equal(before[0].Seq))
+			Expect(after[0].Epoch).To(BeNumerically(">", before[0].Epoch))
+			Expect(after[0].ID).ToNot(Equal(before[0].ID))
+		})
+
+		It("ignores the clock of messages", func() {
+			b := newOrderedCluster(1, FIFODelivery, nil)[0]
+
+			synchronize(b, "n8", 1, map[string]uint64{"n7": 1})
+			Expect(deliveredFn("n0")()).To(Equal([]any{"n8-1"}))
+		})
+
+		It("solicits the missing messages from the sender", func() {
+			nodes := newOrderedCluster(2, FIFODelivery, func(cfg *Config) {
+				// the messages are received only by solicitation
+				cfg.Beta = 0
+			})
+
+			for _, msg := range []string{"first", "second", "third"} {
+				_, err := nodes[0].addUserElement(context.Background(), userMessage{msg: msg, callbackType: "my-callback"})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			third := nodes[0].messageBuffer.ElementsFromSequences(map[string][]uint64{"n0": {3}})
+			Expect(third).To(HaveLen(1))
+
+			nodes[1].synchronizeElement(context.Background(), third[0], "n0")
+
+			Eventually(deliveredFn("n1")).Should(Equal([]any{"first", "second", "third"}))
+			Expect(nodes[1].Stats().SkippedMessages).To(BeZero())
+		})
+
+		It("skips the missing messages after the hold-back timeout", func() {
+			b := newOrderedCluster(1, FIFODelivery, func(cfg *Config) {
+				cfg.HoldBackTimeout = 50 * time.Millisecond
+			})[0]
+
+			synchronize(b, "n8", 2, nil)
+			Expect(deliveredFn("n0")()).To(BeEmpty())
+
+			Eventually(deliveredFn("n0")).Should(Equal([]any{"n8-2"}))
+			Expect(b.Stats().SkippedMessages).To(Equal(uint64(1)))
+		})
+
+		It("skips the missing messages when the hold-back limit is exceeded", func() {
+			b := newOrderedCluster(1, FIFODelivery, func(cfg *Config) {
+				cfg.HoldBackLimit = 1
+				cfg.HoldBackTimeout = time.Hour
+			})[0]
+
+			synchronize(b, "n8", 3, nil)
+			synchronize(b, "n8", 5, nil)
+			Expect(deliveredFn("n0")()).To(Equal([]any{"n8-3"}))
+			Expect(b.Stats().SkippedMessages).To(Equal(uint64(2)))
+
+			synchronize(b, "n8", 4, nil)
+			Expect(deliveredFn("n0")()).To(Equal([]any{"n8-3", "n8-4", "n8-5"}))
+		})
+	})
+
+	Context("in causal order", func() {
+		It("delivers the messages after their causal predecessors", func() {
+			b := newOrderedCluster(1, CausalDelivery, func(cfg *Config) {
+				cfg.HoldBackTimeout = time.Hour
+			})[0]
+
+			synchronize(b, "n8", 1, map[string]uint64{"n7": 2})
+			synchronize(b, "n7", 2, nil)
+			Expect(deliveredFn("n0")()).To(BeEmpty())
+
+			pending := b.Pending()
+			Expect(pending).To(HaveLen(2))
+			Expect(pending[0].ID).To(Equal("n7-2"))
+			Expect(pending[0].Seq).To(Equal(uint64(2)))
+			Expect(pending[0].Waiting).To(Equal(map[string]uint64{"n7": 1}))
+			Expect(pending[1].ID).To(Equal("n8-1"))
+			Expect(pending[1].Waiting).To(Equal(map[string]uint64{"n7": 2}))
+			Expect(b.Inspect().Pending).To(Equal(pending))
+
+			synchronize(b, "n7", 1, nil)
+			Expect(deliveredFn("n0")()).To(Equal([]any{"n7-1", "n7-2", "n8-1"}))
+			Expect(b.Pending()).To(BeEmpty())
+		})
+
+		It("captures the messages delivered by the origin", func() {
+			nodes := newOrderedCluster(3, CausalDelivery, nil)
+
+			// n2 receives the messages only by solicitation
+			Expect(nodes[0].peerBuffer.RemovePeer("n2")).To(BeTrue())
+			Expect(nodes[1].peerBuffer.RemovePeer("n2")).To(BeTrue())
+
+			Expect(nodes[0].AddMessage(context.Background(), "create", "my-callback")).To(Succeed())
+			Eventually(deliveredFn("n1")).Should(Equal([]any{"create"}))
+
+			Expect(nodes[1].AddMessage(context.Background(), "update", "my-callback")).To(Succeed())
+
+			update := nodes[1].messageBuffer.ElementsFromSequences(map[string][]uint64{"n1": {1}})
+			Expect(update).To(HaveLen(1))
+			Expect(update[0].Clock).To(Equal(map[string]uint64{"n0": 1}))
+
+			// n2 receives the update before the create
+			nodes[2].synchronizeElement(context.Background(), update[0], "n1")
+
+			Eventually(deliveredFn("n2")).Should(Equal([]any{"create", "update"}))
+			Expect(nodes[2].Stats().SkippedMessages).To(BeZero())
+		})
+
+		It("skips the missing predecessors after the hold-back timeout", func() {
+			b := newOrderedCluster(1, CausalDelivery, func(cfg *Config) {
+				cfg.HoldBackTimeout = 50 * time.Millisecond
+			})[0]
+
+			synchronize(b, "n7", 2, nil)
+			synchronize(b, "n8", 1, map[string]uint64{"n7": 3})
+
+			Eventually(deliveredFn("n0")).Should(Equal([]any{"n7-2", "n8-1"}))
+			Expect(b.Stats().SkippedMessages).To(Equal(uint64(2)))
+		})
+	})
+})
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentIDs contains the IDs of the elements recently removed from buffer,
+// with the removal time. They are not solicited again from the peers which
+// still gossip them.
+type recentIDs struct {
+	mux *sync.Mutex
+	ttl time.Duration
+	ids map[string]time.Time
+}
+
+func newRecentIDs(ttl time.Duration) *recentIDs {
+	return &recentIDs{
+		mux: &sync.Mutex{},
+		ttl: ttl,
+		ids: map[string]time.Time{},
+	}
+}
+
+// add records the given IDs as removed at the given time.
+func (r *recentIDs) add(now time.Time, ids ...string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for _, id := range ids {
+		r.ids[id] = now
+	}
+}
+
+// has returns true if the element with given ID was recently removed.
+func (r *recentIDs) has(id string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	_, ok := r.ids[id]
+
+	return ok
+}
+
+// len returns the number of elements recently removed.
+func (r *recentIDs) len() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return len(r.ids)
+}
+
+// list returns the sorted IDs of the elements recently removed.
+func (r *recentIDs) list() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	ids := make([]string, 0, len(r.ids))
+
+	for id := range r.ids {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// expire forgets the elements removed before the TTL, which are not gossiped
+// by peers anymore.
+func (r *recentIDs) expire(now time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for id, removedAt := range r.ids {
+		if now.Sub(removedAt) >= r.ttl {
+			delete(r.ids, id)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
+)
+
+const (
+	// replyRouteSuffix is appended to the route of a request to authenticate
+	// its reply, so a reply cannot be replayed as a request.
+	replyRouteSuffix = "/reply"
+
+	requestErrFmt        = "error at requesting %s from peer %s: %w"
+	requestDecodeErrFmt  = "%w: %w"
+	requestPayloadErrFmt = "%w: payload of %s from %q is not a string"
+)
+
+var errCannotRequest = errors.New("host must implement Request")
+
+// Request sends the given payload to the given peer on an application route
+// (e.g. a snapshot request) and returns the payload of the reply. The request
+// and the reply are sent like the elements of the protocol: they are signed by
+// their sender, encrypted and authenticated, when configured. The host must
+// implement Request and the host server of the peer must route the route to a
+// handler that calls HandleRequest. Replies larger than Limits.MaxBodyBytes are
+// rejected with ErrLimitExceeded.
+func (b *BMMC) Request(ctx context.Context, route string, peerToSend string, payload []byte) ([]byte, error) {
+	requester, ok := b.config.Host.(peer.Requester)
+	if !ok {
+		return nil, fmt.Errorf(requestErrFmt, route, peerToSend, errCannotRequest)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	body, err := b.sealPayload(route, payload)
+	if err != nil {
+		return nil, fmt.Errorf(requestErrFmt, route, peerToSend, err)
+	}
+
+	resp, err := requester.Request(body, route, peerToSend)
+	if err != nil {
+		return nil, fmt.Errorf(requestErrFmt, route, peerToSend, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	// the reply is bounded like the received messages
+	if err := b.checkBody(resp); err != nil {
+		return nil, fmt.Errorf(requestErrFmt, route, peerToSend, err)
+	}
+
+	el, err := b.openPayload(route+replyRouteSuffix, resp)
+	if err != nil {
+		return nil, fmt.Errorf(requestErrFmt, route, peerToSend, err)
+	}
+
+	if el.Origin != peerToSend {
+		// the reply must be created by the requested peer
+		err = fmt.Errorf(rejectedSenderErrFmt, ErrRejectedSender, el.Origin)
+
+		return nil, fmt.Errorf(requestErrFmt, route, peerToSend, err)
+	}
+
+	return b.payloadOf(route, el)
+}
+
+// HandleRequest handles a request received by the host server on an application
+// route, sent by the Request of a peer. The request is rejected like the messages
+// of the protocol (e.g. when it is not authenticated or its sender is rejected),
+// otherwise the handler is called with the sender and the payload of the request.
+// It returns the reply body that should be sent back to the sender.
+func (b *BMMC) HandleRequest(
+	ctx context.Context, route string, body []byte, handler func(sender string, payload []byte) ([]byte, error),
+) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	if err := b.checkBody(body); err != nil {
+		return nil, err
+	}
+
+	el, err := b.openPayload(route, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = b.validateSender(ctx, el.Origin); err != nil {
+		return nil, err
+	}
+
+	payload, err := b.payloadOf(route, el)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := handler(el.Origin, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.sealPayload(route+replyRouteSuffix, reply)
+}
+
+// sealPayload wraps the given payload in an element created by the host, which is
+// encrypted and signed, then in an authenticated envelope for the given route.
+func (b *BMMC) sealPayload(route string, payload []byte) ([]byte, error) {
+	el, err := b.newElement(string(payload), route, false, nil, elementOrder{})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(el)
+	if err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	return b.seal(route, data)
+}
+
+// openPayload authenticates the given body and returns the element wrapping the
+// payload, after verifying the signature of its origin.
+func (b *BMMC) openPayload(route string, body []byte) (buffer.Element, error) {
+	data, err := b.open(route, body)
+	if err != nil {
+		return buffer.Element{}, err
+	}
+
+	var el buffer.Element
+	if err = json.Unmarshal(data, &el); err != nil {
+		return buffer.Element{}, fmt.Errorf(requestDecodeErrFmt, ErrDecode, err)
+	}
+
+	if err = b.verifyElement(el); err != nil {
+		b.stats.rejectedElements.Add(1)
+
+		return buffer.Element{}, err
+	}
+
+	return el, nil
+}
+
+// payloadOf returns the decrypted payload of the given element.
+func (b *BMMC) payloadOf(route string, el buffer.Element) ([]byte, error) {
+	el, err := b.decryptElement(el)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, ok := el.Msg.(string)
+	if !ok {
+		return nil, fmt.Errorf(requestPayloadErrFmt, ErrDecode, route, el.Origin)
+	}
+
+	return []byte(payload), nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"bytes"
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Application requests", func() {
+	var nodes []*BMMC
+
+	BeforeEach(func() {
+		nodes = newTestCluster(2, func(cfg *Config) {
+			cfg.EncryptionKeys = map[string][]byte{"key-1": []byte("0123456789abcdef")}
+			cfg.EncryptionKeyID = "key-1"
+			cfg.AuthKeys = map[string][]byte{"auth-1": []byte("secret")}
+			cfg.AuthKeyID = "auth-1"
+		})
+	})
+
+	It("replies to the requests of peers", func() {
+		body, err := nodes[0].sealPayload("/app", []byte("ping"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bytes.Contains(body, []byte("ping"))).To(BeFalse())
+
+		reply, err := nodes[1].HandleRequest(context.Background(), "/app", body,
+			func(sender string, payload []byte) ([]byte, error) {
+				Expect(sender).To(Equal("n0"))
+				Expect(payload).To(Equal([]byte("ping")))
+
+				return []byte("pong"), nil
+			})
+		Expect(err).ToNot(HaveOccurred())
+
+		el, err := nodes[0].openPayload("/app"+replyRouteSuffix, reply)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(el.Origin).To(Equal("n1"))
+
+		payload, err := nodes[0].payloadOf("/app", el)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(payload).To(Equal([]byte("pong")))
+	})
+
+	It("rejects the replies sent as requests", func() {
+		body, err := nodes[0].sealPayload("/app"+replyRouteSuffix, []byte("pong"))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = nodes[1].HandleRequest(context.Background(), "/app", body,
+			func(string, []byte) ([]byte, error) { return nil, nil })
+		Expect(err).To(MatchError(ErrUnauthenticated))
+	})
+
+	It("rejects the requests from rejected senders", func() {
+		body, err := nodes[0].sealPayload("/app", []byte("ping"))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = nodes[0].HandleRequest(context.Background(), "/app", body,
+			func(string, []byte) ([]byte, error) { return nil, nil })
+		Expect(err).To(MatchError(ErrRejectedSender))
+	})
+
+	It("returns error when the host cannot send requests", func() {
+		b, err := New(&Config{Host: &fakeHost{}, BufferSize: 25})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = b.Request(context.Background(), "/app", "n1", nil)
+		Expect(err).To(MatchError(errCannotRequest))
+	})
+})
@@ -0,0 +1,240 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/callback"
+)
+
+const (
+	retractMessageErrFmt = "error at retracting the message %s: %w"
+	notOriginErrFmt      = "%w: %s is not the origin of the message %s"
+
+	// defaultRetractionRounds is the default number of gossip rounds during which
+	// the retracted messages are remembered after their tombstones were received.
+	defaultRetractionRounds = 100
+)
+
+var (
+	errEmptyMessageID      = errors.New("message ID must not be empty")
+	errInvalidRetraction   = errors.New("tombstone doesn't contain a message ID")
+	errCannotConvertToElem = errors.New("cannot convert the given data to element")
+	errNotOrigin           = errors.New("only the origin of a message can retract it")
+)
+
+// Retraction is the data passed to the retract callback.
+type Retraction struct {
+	// ID is the ID of the retracted message.
+	ID string
+	// Msg is the retracted message, or nil if it was not in buffer.
+	Msg any
+	// Removed is true if the retracted message was removed from buffer.
+	Removed bool
+	// Origin is the host that retracted the message, which is also its origin.
+	Origin string
+	// Timestamp is the time when the message was retracted by the origin.
+	Timestamp time.Time
+}
+
+// retractedMessage is a message retracted by a tombstone.
+type retractedMessage struct {
+	// tombstone is the ID of the tombstone and origin is the host that retracted the message
+	tombstone string
+	origin    string
+	// expires is the time after which the message is forgotten, once the tombstone
+	// is not in buffer
+	expires time.Time
+}
+
+// retractionTracker tracks the retracted messages, indexed by message ID. A message
+// is retracted as long as its tombstone is in buffer, and at least for the TTL after
+// the tombstone was received, so it is not accepted again from peers which still
+// have it after the tombstone was removed from buffer (e.g. when the buffer is full).
+type retractionTracker struct {
+	mux       *sync.RWMutex
+	ttl       time.Duration
+	retracted map[string]retractedMessage
+}
+
+func newRetractionTracker(ttl time.Duration) *retractionTracker {
+	return &retractionTracker{
+		mux:       &sync.RWMutex{},
+		ttl:       ttl,
+		retracted: map[string]retractedMessage{},
+	}
+}
+
+// add records the message retracted by the given tombstone.
+func (t *retractionTracker) add(id string, tombstone buffer.Element, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.retracted[id] = retractedMessage{
+		tombstone: tombstone.ID,
+		origin:    tombstone.Origin,
+		expires:   now.Add(t.ttl),
+	}
+}
+
+// has returns true if the message with given ID is retracted.
+func (t *retractionTracker) has(id string) bool {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	_, ok := t.retracted[id]
+
+	return ok
+}
+
+// retracts returns true if the given element is retracted by its origin.
+func (t *retractionTracker) retracts(el buffer.Element) bool {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	r, ok := t.retracted[el.ID]
+
+	return ok && r.origin == el.Origin
+}
+
+// ids returns the IDs of the retracted messages.
+func (t *retractionTracker) ids() []string {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	ids := make([]string, 0, len(t.retracted))
+
+	for id := range t.retracted {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// retain forgets the retracted messages whose tombstones are not in buffer and
+// whose TTL expired. The digest is computed under lock, after the tombstones
+// being added are in buffer.
+func (t *retractionTracker) retain(digest func() []string, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	ids := digest()
+
+	inBuffer := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		inBuffer[id] = struct{}{}
+	}
+
+	for id, r := range t.retracted {
+		if _, ok := inBuffer[r.tombstone]; !ok && now.After(r.expires) {
+			delete(t.retracted, id)
+		}
+	}
+}
+
+// RetractMessage retracts the message with given ID. A tombstone is added in
+// messages buffer and propagated like any other message: each host removes the
+// message from its buffer, doesn't accept it again and calls the retract callback.
+// Only the origin of a message can retract it.
+func (b *BMMC) RetractMessage(id string) error {
+	if id == "" {
+		return fmt.Errorf(retractMessageErrFmt, id, errEmptyMessageID)
+	}
+
+	if err := b.checkRetractOrigin(id, b.config.Host.String()); err != nil {
+		return fmt.Errorf(retractMessageErrFmt, id, err)
+	}
+
+	tombstone, err := b.newElement(id, callback.RETRACT, true, nil, elementOrder{})
+	if err != nil {
+		return fmt.Errorf(retractMessageErrFmt, id, err)
+	}
+
+	if err = b.addElement(tombstone); err != nil {
+		return fmt.Errorf(retractMessageErrFmt, id, err)
+	}
+
+	b.multicastSynchronization([]buffer.Element{tombstone})
+
+	b.runCallbacks(context.Background(), tombstone)
+
+	return nil
+}
+
+// checkRetractOrigin returns error if the message with given ID is in buffer
+// and the given host is not its origin. Messages not received yet are retracted
+// only if they are received from the same origin.
+func (b *BMMC) checkRetractOrigin(id string, origin string) error {
+	elements := b.messageBuffer.ElementsFromIDs([]string{id})
+	if len(elements) > 0 && elements[0].Origin != origin {
+		return fmt.Errorf(notOriginErrFmt, errNotOrigin, origin, id)
+	}
+
+	return nil
+}
+
+// retractCallback is the callback of tombstones. It removes the retracted
+// message from buffer and calls the retract callback from config.
+// Tombstones from other hosts than the origin of the message are rejected.
+func (b *BMMC) retractCallback(data any, logger *slog.Logger) error {
+	tombstone, ok := data.(buffer.Element)
+	if !ok {
+		return errCannotConvertToElem
+	}
+
+	id, ok := tombstone.Msg.(string)
+	if !ok || id == "" {
+		return errInvalidRetraction
+	}
+
+	if err := b.checkRetractOrigin(id, tombstone.Origin); err != nil {
+		return err
+	}
+
+	b.retractions.add(id, tombstone, time.Now())
+
+	retraction := Retraction{
+		ID:        id,
+		Origin:    tombstone.Origin,
+		Timestamp: tombstone.Timestamp,
+	}
+
+	if removed := b.messageBuffer.Remove([]string{id}); len(removed) > 0 {
+		retraction.Removed = true
+
+		if el, err := b.decryptElement(removed[0]); err == nil {
+			retraction.Msg = el.Msg
+		}
+
+		b.config.Metrics.SetGauge(MetricBufferSize, float64(b.messageBuffer.Length()))
+	}
+
+	logger.Debug("message retracted", "id", id, "removed", retraction.Removed)
+
+	if b.config.RetractCallback == nil {
+		return nil
+	}
+
+	return b.config.RetractCallback(retraction, logger)
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/callback"
+)
+
+var _ = Describe("Retraction", func() {
+	var (
+		nodes       []*BMMC
+		retractions map[string][]Retraction
+		mux         *sync.Mutex
+	)
+
+	BeforeEach(func() {
+		clusterRetractions, clusterMux := map[string][]Retraction{}, &sync.Mutex{}
+		retractions, mux = clusterRetractions, clusterMux
+
+		nodes = newTestCluster(3, func(cfg *Config) {
+			host := cfg.Host.String()
+
+			cfg.RetractCallback = func(data any, _ *slog.Logger) error {
+				clusterMux.Lock()
+				defer clusterMux.Unlock()
+
+				clusterRetractions[host] = append(clusterRetractions[host], data.(Retraction))
+
+				return nil
+			}
+		})
+	})
+
+	retractionsOf := func(host string) []Retraction {
+		mux.Lock()
+		defer mux.Unlock()
+
+		return append([]Retraction{}, retractions[host]...)
+	}
+
+	It("removes the retracted message from all buffers", func() {
+		id, err := nodes[0].AddMessageWithID(context.Background(), "message", NOCALLBACK)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, b := range nodes {
+			Eventually(b.messageBuffer.Digest).Should(ContainElement(id))
+		}
+
+		Expect(nodes[0].RetractMessage(id)).To(Succeed())
+
+		for _, b := range nodes {
+			host := b.config.Host.String()
+
+			Eventually(b.messageBuffer.Digest).ShouldNot(ContainElement(id))
+			Eventually(func() []Retraction { return retractionsOf(host) }).Should(HaveLen(1))
+
+			retraction := retractionsOf(host)[0]
+			Expect(retraction.ID).To(Equal(id))
+			Expect(retraction.Msg).To(Equal("message"))
+			Expect(retraction.Removed).To(BeTrue())
+			Expect(retraction.Origin).To(Equal("n0"))
+		}
+
+		Expect(nodes[0].GetMessages()).To(BeEmpty())
+	})
+
+	It("doesn't accept the retracted message again", func() {
+		id, err := nodes[0].AddMessageWithID(context.Background(), "message", NOCALLBACK)
+		Expect(err).ToNot(HaveOccurred())
+
+		elements := nodes[0].messageBuffer.ElementsFromIDs([]string{id})
+		Expect(elements).To(HaveLen(1))
+
+		Expect(nodes[0].RetractMessage(id)).To(Succeed())
+		Eventually(func() []Retraction { return retractionsOf("n1") }).Should(HaveLen(1))
+
+		Expect(nodes[1].retractions.has(id)).To(BeTrue())
+		Expect(nodes[1].synchronizeElement(context.Background(), elements[0], "n0")).To(BeFalse())
+		Expect(nodes[1].messageBuffer.Digest()).ToNot(ContainElement(id))
+	})
+
+	It("retracts a message not received yet", func() {
+		Expect(nodes[1].RetractMessage("unknown-id")).To(Succeed())
+
+		Eventually(func() []Retraction { return retractionsOf("n2") }).Should(HaveLen(1))
+		Expect(retractionsOf("n2")[0].Removed).To(BeFalse())
+		Expect(nodes[2].retractions.has("unknown-id")).To(BeTrue())
+	})
+
+	It("rejects the tombstones from other hosts than the origin", func() {
+		id, err := nodes[0].AddMessageWithID(context.Background(), "message", NOCALLBACK)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, b := range nodes {
+			Eventually(b.messageBuffer.Digest).Should(ContainElement(id))
+		}
+
+		Expect(nodes[1].RetractMessage(id)).To(MatchError(errNotOrigin))
+
+		// a tombstone created by n1 for the message of n0
+		tombstone, err := nodes[1].newElement(id, callback.RETRACT, true, nil, elementOrder{})
+		Expect(err).ToNot(HaveOccurred())
+
+		nodes[2].synchronizeElement(context.Background(), tombstone, "n1")
+
+		Consistently(nodes[2].messageBuffer.Digest, 100*time.Millisecond).Should(ContainElement(id))
+		Expect(nodes[2].retractions.has(id)).To(BeFalse())
+		Expect(retractionsOf("n2")).To(BeEmpty())
+	})
+
+	It("returns error when the message ID is empty", func() {
+		Expect(nodes[0].RetractMessage("")).To(MatchError(errEmptyMessageID))
+	})
+
+	Describe("retractionTracker", func() {
+		var (
+			tracker   *retractionTracker
+			now       time.Time
+			tombstone buffer.Element
+		)
+
+		BeforeEach(func() {
+			tracker = newRetractionTracker(time.Second)
+			now = time.Now()
+			tombstone = buffer.Element{ID: "tombstone", Origin: "n1", Internal: true}
+		})
+
+		It("retracts only the messages of the tombstone origin", func() {
+			tracker.add("a", tombstone, now)
+
+			Expect(tracker.has("a")).To(BeTrue())
+			Expect(tracker.retracts(buffer.Element{ID: "a", Origin: "n1"})).To(BeTrue())
+			Expect(tracker.retracts(buffer.Element{ID: "a", Origin: "n2"})).To(BeFalse())
+			Expect(tracker.retracts(buffer.Element{ID: "b", Origin: "n1"})).To(BeFalse())
+		})
+
+		It("remembers the retracted messages for the TTL after the tombstone left the buffer", func() {
+			tracker.add("a", tombstone, now)
+
+			tracker.retain(func() []string { return nil }, now.Add(time.Second/2))
+			Expect(tracker.has("a")).To(BeTrue())
+
+			tracker.retain(func() []string { return []string{"tombstone"} }, now.Add(2*time.Second))
+			Expect(tracker.has("a")).To(BeTrue())
+
+			tracker.retain(func() []string { return nil }, now.Add(2*time.Second))
+			Expect(tracker.has("a")).To(BeFalse())
+			Expect(tracker.ids()).To(BeEmpty())
+		})
+	})
+
+	It("returns error when the retraction TTL is negative", func() {
+		_, err := New(&Config{Host: &fakeHost{}, BufferSize: 25, RetractionTTL: -time.Second})
+		Expect(err).To(MatchError(errInvalidRetractionTTL))
+	})
+})
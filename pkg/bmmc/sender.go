@@ -0,0 +1,244 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+const (
+	// sourceLookupTTL is the duration for which MatchSourceHost caches the IPs of a host.
+	sourceLookupTTL = time.Minute
+
+	// maxSourceLookups is the maximum number of hosts cached by MatchSourceHost.
+	maxSourceLookups = 1024
+)
+
+// sourceResolver resolves the hosts of peers for MatchSourceHost.
+var sourceResolver = newHostResolver(net.LookupIP, sourceLookupTTL) //nolint: gochecknoglobals
+
+// sourceContextKey is the context key for the source address of a message.
+type sourceContextKey struct{}
+
+// ContextWithSource returns a copy of the context with the source address of a
+// received message, as reported by the transport (e.g. the remote address of an
+// HTTP request). The source is checked by Config.VerifySource in Handle.
+func ContextWithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceContextKey{}, source)
+}
+
+// requestedPeerContextKey is the context key for the peer whose reply to a
+// request of the host is handled.
+type requestedPeerContextKey struct{}
+
+// contextWithRequestedPeer returns a copy of the context for handling the reply
+// of the given peer to a request of the host, which has no source address.
+func contextWithRequestedPeer(ctx context.Context, peer string) context.Context {
+	return context.WithValue(ctx, requestedPeerContextKey{}, peer)
+}
+
+// SourceFromContext returns the source address of a received message.
+func SourceFromContext(ctx context.Context) (string, bool) {
+	source, ok := ctx.Value(sourceContextKey{}).(string)
+
+	return source, ok && source != ""
+}
+
+// MatchSourceHost returns true if the host of the given peer resolves to the host
+// of the given source address. Ports are ignored, because requests are usually sent
+// from ephemeral ports. It can be used as Config.VerifySource for IP based transports.
+// The IPs of a host are cached for a minute, so hosts are not resolved for each message.
+func MatchSourceHost(peer string, source string) bool {
+	peerHost, sourceHost := peerHost(peer), peerHost(source)
+
+	if peerHost == sourceHost {
+		return true
+	}
+
+	sourceIP := net.ParseIP(sourceHost)
+	if sourceIP == nil {
+		return false
+	}
+
+	if ip := net.ParseIP(peerHost); ip != nil {
+		return ip.Equal(sourceIP)
+	}
+
+	for _, ip := range sourceResolver.resolve(peerHost) {
+		if ip.Equal(sourceIP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostLookup is a cached result of a host lookup.
+type hostLookup struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// hostResolver resolves hosts and caches the results, including failed lookups.
+type hostResolver struct {
+	lookup func(host string) ([]net.IP, error)
+	ttl    time.Duration
+	cache  map[string]hostLookup
+	now    func() time.Time
+	mux    *sync.Mutex
+}
+
+func newHostResolver(lookup func(host string) ([]net.IP, error), ttl time.Duration) *hostResolver {
+	return &hostResolver{
+		lookup: lookup,
+		ttl:    ttl,
+		cache:  map[string]hostLookup{},
+		now:    time.Now,
+		mux:    &sync.Mutex{},
+	}
+}
+
+// resolve returns the IPs of the given host.
+func (r *hostResolver) resolve(host string) []net.IP {
+	r.mux.Lock()
+	cached, ok := r.cache[host]
+	now := r.now()
+	r.mux.Unlock()
+
+	if ok && now.Before(cached.expires) {
+		return cached.ips
+	}
+
+	// the lookup is done without lock, so a slow lookup doesn't block other hosts
+	ips, err := r.lookup(host)
+	if err != nil {
+		ips = nil
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if len(r.cache) >= maxSourceLookups {
+		r.pruneExpired(now)
+	}
+
+	r.cache[host] = hostLookup{ips: ips, expires: now.Add(r.ttl)}
+
+	return ips
+}
+
+// pruneExpired removes the expired lookups. When all lookups are fresh,
+// the cache is cleared, since the hosts of messages are not trusted.
+// Important! Whoever calls this function must LOCK the resolver.
+func (r *hostResolver) pruneExpired(now time.Time) {
+	for host, cached := range r.cache {
+		if !now.Before(cached.expires) {
+			delete(r.cache, host)
+		}
+	}
+
+	if len(r.cache) >= maxSourceLookups {
+		clear(r.cache)
+	}
+}
+
+// checkSender returns true if the host of a received message is accepted,
+// according to the config, as sender.
+func (b *BMMC) checkSender(ctx context.Context, host string) bool {
+	if b.config.RequireKnownSender && !b.peerBuffer.Contains(host) {
+		return false
+	}
+
+	if b.config.VerifySource == nil {
+		return true
+	}
+
+	source, ok := SourceFromContext(ctx)
+	if !ok {
+		// the replies to own requests are received from the requested peers,
+		// while the other messages without source cannot be verified
+		requested, _ := ctx.Value(requestedPeerContextKey{}).(string)
+
+		return requested != "" && requested == host
+	}
+
+	return b.config.VerifySource(host, source)
+}
+
+// limitSynchronization returns the elements that can be sent to the given peer
+// without exceeding its synchronization rate limit.
+// The budget is reserved at once, so concurrent synchronizations sent to the
+// same peer don't exceed the limit together.
+func (b *BMMC) limitSynchronization(peerToSend string, elements []buffer.Element) []buffer.Element {
+	if b.syncLimiter == nil {
+		return elements
+	}
+
+	sizes := make([]int, len(elements))
+	total := 0
+
+	for i, el := range elements {
+		encoded, err := json.Marshal(el)
+		if err != nil {
+			sizes[i] = -1
+
+			continue
+		}
+
+		sizes[i] = len(encoded)
+		total += len(encoded)
+	}
+
+	granted := b.syncLimiter.Reserve(peerToSend, total)
+	allowed := []buffer.Element{}
+	size := 0
+
+	for i, el := range elements {
+		if sizes[i] < 0 {
+			continue
+		}
+
+		if size+sizes[i] > granted {
+			// an element larger than the burst is sent alone, when the burst
+			// is available, so it is not skipped forever
+			if size > 0 || granted < b.config.SyncBurst || sizes[i] <= granted {
+				continue
+			}
+
+			sizes[i] = granted
+		}
+
+		size += sizes[i]
+		allowed = append(allowed, el)
+	}
+
+	// give back the budget of skipped elements
+	b.syncLimiter.Release(peerToSend, granted-size)
+
+	if limited := len(elements) - len(allowed); limited > 0 {
+		b.stats.rateLimitedElements.Add(uint64(limited))
+		b.config.Logger.Debug("synchronization rate limit exceeded", "peer", peerToSend, "limited", limited)
+	}
+
+	return allowed
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/ratelimit"
+)
+
+var _ = Describe("Sender validation", func() {
+	DescribeTable("MatchSourceHost function", func(p, source string, expected bool) {
+		Expect(MatchSourceHost(p, source)).To(Equal(expected))
+	},
+		Entry("same IP, different ports", "10.0.0.1:19999", "10.0.0.1:43210", true),
+		Entry("different IPs", "10.0.0.1:19999", "10.0.0.2:19999", false),
+		Entry("hostname resolving to source IP", "localhost:19999", "127.0.0.1:43210", true),
+		Entry("same names", "n1", "n1", true),
+		Entry("different names", "n1", "n2", false),
+	)
+
+	Describe("Handle function", func() {
+		var nodes []*BMMC
+
+		BeforeEach(func() {
+			nodes = newTestCluster(2, func(cfg *Config) {
+				cfg.RequireKnownSender = true
+				cfg.VerifySource = func(host, source string) bool {
+					return host == source
+				}
+			})
+		})
+
+		It("rejects messages from unknown senders", func() {
+			_, err := nodes[0].Handle(context.Background(), GossipRoute, []byte(`{"host":"n9","digest":["id"]}`))
+			Expect(err).To(MatchError(ErrRejectedSender))
+			Expect(nodes[0].Stats().RejectedSenders).To(Equal(uint64(1)))
+		})
+
+		It("rejects messages with spoofed hosts", func() {
+			ctx := ContextWithSource(context.Background(), "n9")
+
+			_, err := nodes[0].Handle(ctx, SolicitationRoute, []byte(`{"host":"n1","digest":["id"]}`))
+			Expect(err).To(MatchError(ErrRejectedSender))
+		})
+
+		It("rejects messages without source", func() {
+			_, err := nodes[0].Handle(context.Background(), GossipRoute, []byte(`{"host":"n1","digest":[]}`))
+			Expect(err).To(MatchError(ErrRejectedSender))
+
+			nodes[0].GossipHandler([]byte(`{"host":"n1","digest":[]}`))
+			Expect(nodes[0].Stats().RejectedSenders).To(Equal(uint64(2)))
+		})
+
+		It("accepts the replies of the requested peers without source", func() {
+			ctx := contextWithRequestedPeer(context.Background(), "n1")
+
+			_, err := nodes[0].Handle(ctx, GossipRoute, []byte(`{"host":"n1","digest":[]}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx = contextWithRequestedPeer(context.Background(), "n9")
+
+			_, err = nodes[0].Handle(ctx, GossipRoute, []byte(`{"host":"n1","digest":[]}`))
+			Expect(err).To(MatchError(ErrRejectedSender))
+		})
+
+		It("accepts messages from known senders with matching source", func() {
+			ctx := ContextWithSource(context.Background(), "n1")
+
+			_, err := nodes[0].Handle(ctx, GossipRoute, []byte(`{"host":"n1","digest":[]}`))
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("limitSynchronization function", func() {
+		var (
+			b       *BMMC
+			largest int
+		)
+
+		BeforeEach(func() {
+			nodes := newTestCluster(1, func(cfg *Config) {
+				cfg.SyncRateLimit = 10
+				cfg.SyncBurst = 1024
+			})
+			b = nodes[0]
+
+			for i := 0; i < 8; i++ {
+				Expect(b.AddMessage(context.Background(), fmt.Sprintf("message-%d-%s", i, strings.Repeat("x", 100)), NOCALLBACK)).To(Succeed())
+			}
+
+			for _, el := range b.messageBuffer.UserElements() {
+				encoded, err := json.Marshal(el)
+				Expect(err).ToNot(HaveOccurred())
+
+				largest = max(largest, len(encoded))
+			}
+		})
+
+		It("limits the size of synchronization messages sent to a peer", func() {
+			elements := b.messageBuffer.UserElements()
+
+			allowed := b.limitSynchronization("n1", elements)
+			Expect(len(allowed)).To(BeNumerically(">=", 1024/largest))
+			Expect(len(allowed)).To(BeNumerically("<", len(elements)))
+			Expect(b.Stats().RateLimitedElements).To(Equal(uint64(len(elements) - len(allowed))))
+
+			// the budget of the peer is consumed
+			Expect(len(b.limitSynchronization("n1", elements))).To(BeNumerically("<", len(allowed)))
+
+			// other peers have their own budget
+			Expect(b.limitSynchronization("n2", elements)).To(HaveLen(len(allowed)))
+		})
+
+		It("sends an element larger than the burst alone when the burst is available", func() {
+			b.config.SyncBurst = largest - 1
+			b.syncLimiter = ratelimit.NewLimiter(10, largest-1)
+
+			elements := b.messageBuffer.UserElements()
+
+			Expect(b.limitSynchronization("n1", elements)).To(HaveLen(1))
+			// the burst is consumed
+			Expect(b.limitSynchronization("n1", elements)).To(BeEmpty())
+		})
+
+		It("rejects bursts smaller than the maximum element size", func() {
+			_, err := New(&Config{
+				Host:          &fakeHost{},
+				BufferSize:    25,
+				SyncRateLimit: 10,
+				Limits:        Limits{MaxElementBytes: 64},
+			})
+			Expect(err).To(MatchError(errSyncBurstTooSmall))
+
+			_, err = New(&Config{
+				Host:          &fakeHost{},
+				BufferSize:    25,
+				SyncRateLimit: 10,
+				SyncBurst:     64,
+				Limits:        Limits{MaxElementBytes: 64},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("doesn't exceed the limit with concurrent solicitations", func() {
+			elements := b.messageBuffer.UserElements()
+
+			var (
+				wg   sync.WaitGroup
+				mux  sync.Mutex
+				sent int
+			)
+
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+
+					size := 0
+
+					for _, el := range b.limitSynchronization("n1", elements) {
+						encoded, err := json.Marshal(el)
+						Expect(err).ToNot(HaveOccurred())
+
+						size += len(encoded)
+					}
+
+					mux.Lock()
+					defer mux.Unlock()
+
+					sent += size
+				}()
+			}
+
+			wg.Wait()
+
+			// the limiter is refilled with 10 bytes per second during the test
+			Expect(sent).To(BeNumerically("<=", 1024+largest))
+			Expect(sent).To(BeNumerically(">=", 1024-largest))
+		})
+	})
+
+	Describe("hostResolver", func() {
+		var (
+			r       *hostResolver
+			lookups int
+			now     time.Time
+		)
+
+		BeforeEach(func() {
+			lookups = 0
+			now = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			r = newHostResolver(func(host string) ([]net.IP, error) {
+				lookups++
+
+				if host == "unknown" {
+					return nil, errors.New("no such host")
+				}
+
+				return []net.IP{net.ParseIP("10.0.0.1")}, nil
+			}, time.Minute)
+			r.now = func() time.Time {
+				return now
+			}
+		})
+
+		It("caches the IPs of hosts", func() {
+			Expect(r.resolve("node")).To(Equal([]net.IP{net.ParseIP("10.0.0.1")}))
+			Expect(r.resolve("node")).To(Equal([]net.IP{net.ParseIP("10.0.0.1")}))
+			Expect(lookups).To(Equal(1))
+
+			now = now.Add(time.Minute)
+			Expect(r.resolve("node")).To(Equal([]net.IP{net.ParseIP("10.0.0.1")}))
+			Expect(lookups).To(Equal(2))
+		})
+
+		It("caches failed lookups", func() {
+			Expect(r.resolve("unknown")).To(BeEmpty())
+			Expect(r.resolve("unknown")).To(BeEmpty())
+			Expect(lookups).To(Equal(1))
+		})
+
+		It("limits the number of cached hosts", func() {
+			for i := 0; i < maxSourceLookups+10; i++ {
+				r.resolve(fmt.Sprintf("node-%d", i))
+			}
+
+			Expect(len(r.cache)).To(BeNumerically("<=", maxSourceLookups))
+		})
+	})
+})
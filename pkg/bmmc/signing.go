@@ -0,0 +1,160 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+const (
+	signElementErrFmt   = "error at signing the element: %w"
+	verifyElementErrFmt = "error at verifying the element from origin %q: %w"
+)
+
+var (
+	errInvalidPrivateKey = errors.New("invalid ed25519 private key")
+	errUnsignedElement   = errors.New("element is not signed")
+	errUntrustedOrigin   = errors.New("origin is not trusted")
+	errInvalidSignature  = errors.New("invalid signature")
+)
+
+// KeyStore returns the public keys used to verify the messages from each origin.
+type KeyStore interface {
+	// PublicKey returns the public key of the given origin and
+	// false when the origin is not trusted.
+	PublicKey(origin string) (ed25519.PublicKey, bool)
+}
+
+// StaticKeys is a KeyStore with a fixed set of public keys, indexed by origin.
+type StaticKeys map[string]ed25519.PublicKey
+
+// PublicKey returns the public key of the given origin.
+func (k StaticKeys) PublicKey(origin string) (ed25519.PublicKey, bool) {
+	key, ok := k[origin]
+
+	return key, ok
+}
+
+// KeyRing is a KeyStore that can be updated at runtime, e.g. with
+// keys distributed by an external service.
+type KeyRing struct {
+	keys map[string]ed25519.PublicKey
+	mux  *sync.RWMutex
+}
+
+// NewKeyRing creates a KeyRing with the given public keys, indexed by origin.
+func NewKeyRing(keys map[string]ed25519.PublicKey) *KeyRing {
+	r := &KeyRing{
+		keys: map[string]ed25519.PublicKey{},
+		mux:  &sync.RWMutex{},
+	}
+
+	for origin, key := range keys {
+		r.keys[origin] = key
+	}
+
+	return r
+}
+
+// PublicKey returns the public key of the given origin.
+func (r *KeyRing) PublicKey(origin string) (ed25519.PublicKey, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	key, ok := r.keys[origin]
+
+	return key, ok
+}
+
+// Set adds or replaces the public key of the given origin.
+func (r *KeyRing) Set(origin string, key ed25519.PublicKey) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.keys[origin] = key
+}
+
+// Delete removes the public key of the given origin.
+// Messages from the origin will be rejected.
+func (r *KeyRing) Delete(origin string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	delete(r.keys, origin)
+}
+
+// signElement signs the element with the private key of the host.
+// It does nothing if the host has no private key.
+func (b *BMMC) signElement(el *buffer.Element) error {
+	if b.config.PrivateKey == nil {
+		return nil
+	}
+
+	data, err := el.SigningBytes()
+	if err != nil {
+		return fmt.Errorf(signElementErrFmt, err)
+	}
+
+	el.Signature = ed25519.Sign(b.config.PrivateKey, data)
+
+	return nil
+}
+
+// publicKey returns the public key of the given origin.
+// The host always trusts its own key.
+func (b *BMMC) publicKey(origin string) (ed25519.PublicKey, bool) {
+	if origin == b.config.Host.String() && b.config.PrivateKey != nil {
+		key, ok := b.config.PrivateKey.Public().(ed25519.PublicKey)
+
+		return key, ok
+	}
+
+	return b.config.TrustedKeys.PublicKey(origin)
+}
+
+// verifyElement verifies the origin signature of a received element.
+// It does nothing if the host has no trusted keys.
+func (b *BMMC) verifyElement(el buffer.Element) error {
+	if b.config.TrustedKeys == nil {
+		return nil
+	}
+
+	if len(el.Signature) == 0 {
+		return fmt.Errorf(verifyElementErrFmt, el.Origin, errUnsignedElement)
+	}
+
+	key, ok := b.publicKey(el.Origin)
+	if !ok {
+		return fmt.Errorf(verifyElementErrFmt, el.Origin, errUntrustedOrigin)
+	}
+
+	data, err := el.SigningBytes()
+	if err != nil {
+		return fmt.Errorf(verifyElementErrFmt, el.Origin, err)
+	}
+
+	if !ed25519.Verify(key, data, el.Signature) {
+		return fmt.Errorf(verifyElementErrFmt, el.Origin, errInvalidSignature)
+	}
+
+	return nil
+}
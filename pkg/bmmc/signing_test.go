@@ -0,0 +1,157 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+var _ = Describe("Signed messages", func() {
+	var (
+		nodes      []*BMMC
+		privateKey map[string]ed25519.PrivateKey
+	)
+
+	syncBody := func(elements ...buffer.Element) []byte {
+		body, err := json.Marshal(Synchronization{
+			Host:     "n2",
+			Elements: elements,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		return body
+	}
+
+	BeforeEach(func() {
+		privateKey = map[string]ed25519.PrivateKey{}
+		trustedKeys := StaticKeys{}
+
+		for i := 0; i < 3; i++ {
+			pub, priv, err := ed25519.GenerateKey(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			privateKey[fmt.Sprintf("n%d", i)] = priv
+			trustedKeys[fmt.Sprintf("n%d", i)] = pub
+		}
+
+		nodes = newTestCluster(3, func(cfg *Config) {
+			cfg.PrivateKey = privateKey[cfg.Host.String()]
+			cfg.TrustedKeys = trustedKeys
+		})
+	})
+
+	It("returns error when private key is invalid", func() {
+		_, err := New(&Config{
+			Host:       &fakeHost{},
+			BufferSize: 25,
+			PrivateKey: ed25519.PrivateKey("short"),
+		})
+		Expect(err).To(MatchError(errInvalidPrivateKey))
+	})
+
+	It("synchronizes signed messages", func() {
+		Expect(nodes[0].AddMessage(context.Background(), "signed-message", NOCALLBACK)).To(Succeed())
+
+		for _, b := range nodes {
+			Eventually(b.GetMessages).Should(ConsistOf("signed-message"))
+		}
+	})
+
+	It("rejects unsigned elements", func() {
+		el, err := buffer.NewElement("unsigned-message", NOCALLBACK, false)
+		Expect(err).ToNot(HaveOccurred())
+
+		el.Origin = "n2"
+
+		_, err = nodes[0].Handle(context.Background(), SynchronizationRoute, syncBody(el))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(nodes[0].GetMessages()).To(BeEmpty())
+		Expect(nodes[0].Stats().RejectedElements).To(Equal(uint64(1)))
+	})
+
+	It("rejects elements with invalid signatures", func() {
+		el, err := buffer.NewElement("forged-message", NOCALLBACK, false)
+		Expect(err).ToNot(HaveOccurred())
+
+		// signed by n1, but claims to be created by n2
+		el.Origin = "n2"
+		data, err := el.SigningBytes()
+		Expect(err).ToNot(HaveOccurred())
+
+		el.Signature = ed25519.Sign(privateKey["n1"], data)
+
+		_, err = nodes[0].Handle(context.Background(), SynchronizationRoute, syncBody(el))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(nodes[0].GetMessages()).To(BeEmpty())
+		Expect(nodes[0].Stats().RejectedElements).To(Equal(uint64(1)))
+	})
+
+	It("rejects elements from untrusted origins", func() {
+		_, priv, err := ed25519.GenerateKey(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		el, err := buffer.NewElement("untrusted-message", NOCALLBACK, false)
+		Expect(err).ToNot(HaveOccurred())
+
+		el.Origin = "intruder"
+		data, err := el.SigningBytes()
+		Expect(err).ToNot(HaveOccurred())
+
+		el.Signature = ed25519.Sign(priv, data)
+
+		_, err = nodes[0].Handle(context.Background(), SynchronizationRoute, syncBody(el))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(nodes[0].GetMessages()).To(BeEmpty())
+		Expect(nodes[0].Stats().RejectedElements).To(Equal(uint64(1)))
+	})
+
+	It("rejects elements from origins removed from key ring", func() {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		keyRing := NewKeyRing(map[string]ed25519.PublicKey{"n2": pub})
+		nodes[0].config.TrustedKeys = keyRing
+
+		el, err := buffer.NewElement("key-ring-message", NOCALLBACK, false)
+		Expect(err).ToNot(HaveOccurred())
+
+		el.Origin = "n2"
+		data, err := el.SigningBytes()
+		Expect(err).ToNot(HaveOccurred())
+
+		el.Signature = ed25519.Sign(priv, data)
+
+		Expect(nodes[0].verifyElement(el)).To(Succeed())
+
+		keyRing.Delete("n2")
+		Expect(nodes[0].verifyElement(el)).To(MatchError(errUntrustedOrigin))
+
+		keyRing.Set("n2", pub)
+		Expect(nodes[0].verifyElement(el)).To(Succeed())
+	})
+})
@@ -0,0 +1,406 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+// defaultStabilityRounds is the default number of gossip rounds after which the
+// missing elements are skipped by stability detection, and during which the IDs
+// of the stable elements removed from buffer are remembered.
+const defaultStabilityRounds = 10
+
+// seqWindow contains the sequence numbers of the elements received from an origin.
+type seqWindow struct {
+	// upTo is the sequence number up to which all elements were received
+	upTo uint64
+	// beyond are the sequence numbers received after a missing element
+	beyond map[uint64]struct{}
+}
+
+func newSeqWindow() *seqWindow {
+	return &seqWindow{beyond: map[uint64]struct{}{}}
+}
+
+// has returns true if the element with given sequence number was received.
+func (w *seqWindow) has(seq uint64) bool {
+	if seq <= w.upTo {
+		return true
+	}
+
+	_, ok := w.beyond[seq]
+
+	return ok
+}
+
+// add records the element with given sequence number as received.
+func (w *seqWindow) add(seq uint64) {
+	if w.has(seq) {
+		return
+	}
+
+	w.beyond[seq] = struct{}{}
+	w.advance()
+}
+
+// advance moves upTo over the contiguous sequence numbers received.
+func (w *seqWindow) advance() {
+	for {
+		if _, ok := w.beyond[w.upTo+1]; !ok {
+			return
+		}
+
+		delete(w.beyond, w.upTo+1)
+		w.upTo++
+	}
+}
+
+// skipTo gives up on the missing elements up to the given sequence number
+// and returns their number.
+func (w *seqWindow) skipTo(seq uint64) uint64 {
+	if seq <= w.upTo {
+		return 0
+	}
+
+	skipped := seq - w.upTo
+
+	for s := range w.beyond {
+		if s <= seq {
+			delete(w.beyond, s)
+			skipped--
+		}
+	}
+
+	w.upTo = seq
+	w.advance()
+
+	return skipped
+}
+
+// lowestBeyond returns the lowest sequence number received after a missing element.
+func (w *seqWindow) lowestBeyond() uint64 {
+	lowest := uint64(0)
+
+	for seq := range w.beyond {
+		if lowest == 0 || seq < lowest {
+			lowest = seq
+		}
+	}
+
+	return lowest
+}
+
+// missing returns at most limit missing sequence numbers up to the given one.
+func (w *seqWindow) missing(to uint64, limit int) []uint64 {
+	var seqs []uint64
+
+	for seq := w.upTo + 1; seq <= to && len(seqs) < limit; seq++ {
+		if _, ok := w.beyond[seq]; !ok {
+			seqs = append(seqs, seq)
+		}
+	}
+
+	return seqs
+}
+
+// stabilityTracker tracks the elements received by all members. Members report
+// the sequence numbers up to which they received all elements from each origin,
+// and the elements up to the lowest reported sequence number are stable.
+type stabilityTracker struct {
+	mux     *sync.Mutex
+	timeout time.Duration
+	// epochs are the current epochs of the origins
+	epochs epochs
+	// received are the elements received by the host, per origin
+	received map[string]*seqWindow
+	// gaps are the times since elements are missing, per origin
+	gaps map[string]time.Time
+	// reported are the summaries reported by members
+	reported map[string]map[string]uint64
+	// frontier is the sequence number up to which the elements are stable, per origin
+	frontier map[string]uint64
+	// evicted are the stable elements removed from buffer
+	evicted *recentIDs
+}
+
+func newStabilityTracker(timeout time.Duration) *stabilityTracker {
+	return &stabilityTracker{
+		mux:      &sync.Mutex{},
+		timeout:  timeout,
+		epochs:   epochs{},
+		received: map[string]*seqWindow{},
+		gaps:     map[string]time.Time{},
+		reported: map[string]map[string]uint64{},
+		frontier: map[string]uint64{},
+		evicted:  newRecentIDs(timeout),
+	}
+}
+
+// receive records an element received by the host. Elements from a previous
+// epoch of their origin are ignored.
+func (t *stabilityTracker) receive(origin string, epoch uint64, seq uint64, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	switch t.epochs.observe(origin, epoch) {
+	case -1:
+		return
+	case 1:
+		t.restart(origin)
+	}
+
+	w, ok := t.received[origin]
+	if !ok {
+		w = newSeqWindow()
+		t.received[origin] = w
+	}
+
+	w.add(seq)
+
+	switch _, ok = t.gaps[origin]; {
+	case len(w.beyond) == 0:
+		delete(t.gaps, origin)
+	case !ok:
+		t.gaps[origin] = now
+	}
+}
+
+// restart forgets the elements received from the given origin, since it restarted
+// and numbers its elements from 1 again.
+func (t *stabilityTracker) restart(origin string) {
+	delete(t.received, origin)
+	delete(t.gaps, origin)
+	delete(t.frontier, origin)
+
+	for _, reported := range t.reported {
+		delete(reported, origin)
+	}
+}
+
+// hasReceived returns true if the host already received the given element.
+// Elements from a previous epoch of their origin are treated as received, since
+// they can no longer be delivered in order.
+func (t *stabilityTracker) hasReceived(origin string, epoch uint64, seq uint64) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if current, ok := t.epochs[origin]; ok && epoch != current {
+		return epoch < current
+	}
+
+	w, ok := t.received[origin]
+
+	return ok && w.has(seq)
+}
+
+// summary returns the sequence number up to which the host received all
+// elements, per origin, and the epochs of the origins.
+func (t *stabilityTracker) summary() (map[string]uint64, map[string]uint64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	summary := make(map[string]uint64, len(t.received))
+	epochs := make(map[string]uint64, len(t.received))
+
+	for origin, w := range t.received {
+		if w.upTo > 0 {
+			summary[origin] = w.upTo
+			epochs[origin] = t.epochs[origin]
+		}
+	}
+
+	return summary, epochs
+}
+
+// report records the summary reported by a member. Sequence numbers from
+// other epochs than the current epochs of the origins and from origins without
+// elements received by the host are ignored.
+func (t *stabilityTracker) report(member string, summary map[string]uint64, epochs map[string]uint64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	reported, ok := t.reported[member]
+	if !ok {
+		reported = map[string]uint64{}
+		t.reported[member] = reported
+	}
+
+	for origin, seq := range summary {
+		if _, ok := t.received[origin]; !ok {
+			continue
+		}
+
+		if epoch, ok := epochs[origin]; ok && !t.epochs.current(origin, epoch) {
+			continue
+		}
+
+		if seq > reported[origin] {
+			reported[origin] = seq
+		}
+	}
+}
+
+// update skips the elements missing for longer than the timeout and computes
+// the stable frontier for given members (without the host). The summaries of
+// former members are forgotten. It returns the previous frontier of the origins
+// whose frontier advanced.
+func (t *stabilityTracker) update(members []string, now time.Time) map[string]uint64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for member := range t.reported {
+		if !slices.Contains(members, member) {
+			delete(t.reported, member)
+		}
+	}
+
+	// give up on the elements missing for too long, which were probably
+	// removed from the buffers of members before being received
+	for origin, since := range t.gaps {
+		if now.Sub(since) >= t.timeout {
+			w := t.received[origin]
+			w.skipTo(w.lowestBeyond() - 1)
+			delete(t.gaps, origin)
+		}
+	}
+
+	advanced := map[string]uint64{}
+
+	for origin, w := range t.received {
+		frontier := w.upTo
+
+		for _, member := range members {
+			if seq := t.reported[member][origin]; seq < frontier {
+				frontier = seq
+			}
+		}
+
+		if prev := t.frontier[origin]; frontier > prev {
+			advanced[origin] = prev
+			t.frontier[origin] = frontier
+		}
+	}
+
+	t.evicted.expire(now)
+
+	return advanced
+}
+
+// isStable returns true if the element with given sequence number is stable.
+func (t *stabilityTracker) isStable(origin string, epoch uint64, seq uint64) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return seq > 0 && seq <= t.frontier[origin] && t.epochs.current(origin, epoch)
+}
+
+// stableFrontier returns a copy of the stable frontier.
+func (t *stabilityTracker) stableFrontier() map[string]uint64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	frontier := make(map[string]uint64, len(t.frontier))
+
+	for origin, seq := range t.frontier {
+		frontier[origin] = seq
+	}
+
+	return frontier
+}
+
+// receivedBefore returns true if the given element was already received.
+// Elements without sequence number are not tracked.
+func (b *BMMC) receivedBefore(el buffer.Element) bool {
+	if el.Internal || el.Seq == 0 {
+		return false
+	}
+
+	return b.stability.hasReceived(el.Origin, el.Epoch, el.Seq)
+}
+
+// recordReceived records an element received from a peer.
+func (b *BMMC) recordReceived(el buffer.Element) {
+	if el.Internal || el.Seq == 0 {
+		return
+	}
+
+	b.stability.receive(el.Origin, el.Epoch, el.Seq, time.Now())
+}
+
+// updateStability computes the stable frontier, notifies the observer about the
+// elements that became stable and removes them from buffer if configured.
+func (b *BMMC) updateStability() {
+	advanced := b.stability.update(b.peerBuffer.GetPeers(), time.Now())
+	if len(advanced) == 0 {
+		return
+	}
+
+	var stable []buffer.Element
+
+	for _, el := range b.messageBuffer.UserElements() {
+		prev, ok := advanced[el.Origin]
+		if ok && el.Seq > prev && b.stability.isStable(el.Origin, el.Epoch, el.Seq) {
+			stable = append(stable, el)
+		}
+	}
+
+	for _, el := range stable {
+		b.config.Observer.OnElementStable(elementInfo(el))
+	}
+
+	if !b.config.EvictStable || len(stable) == 0 {
+		return
+	}
+
+	ids := make([]string, len(stable))
+
+	for i, el := range stable {
+		ids[i] = el.ID
+	}
+
+	b.stability.evicted.add(time.Now(), ids...)
+
+	for _, el := range b.messageBuffer.Remove(ids) {
+		b.incCounter(MetricBufferEvictions)
+		b.config.Observer.OnElementEvicted(elementInfo(el))
+	}
+
+	b.config.Metrics.SetGauge(MetricBufferSize, float64(b.messageBuffer.Length()))
+}
+
+// IsStable returns true if the message with given ID is known to be received
+// by all members of the cluster. Messages that are no longer in buffer are not
+// known to be stable, unless they were recently removed because they were stable.
+func (b *BMMC) IsStable(id string) bool {
+	elements := b.messageBuffer.ElementsFromIDs([]string{id})
+	if len(elements) == 0 {
+		return b.stability.evicted.has(id)
+	}
+
+	return b.stability.isStable(elements[0].Origin, elements[0].Epoch, elements[0].Seq)
+}
+
+// StableFrontier returns the sequence number up to which the messages from
+// each origin are known to be received by all members of the cluster.
+func (b *BMMC) StableFrontier() map[string]uint64 {
+	return b.stability.stableFrontier()
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stability", func() {
+	It("detects the messages received by all members", func() {
+		observer := newRecordingObserver()
+
+		nodes := newTestCluster(3, func(cfg *Config) {
+			if cfg.Host.String() == "n0" {
+				cfg.Observer = observer
+			}
+		})
+
+		id, err := nodes[0].AddMessageWithID(context.Background(), "message", NOCALLBACK)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, b := range nodes {
+			Eventually(func() bool { return b.IsStable(id) }).Should(BeTrue())
+			Expect(b.StableFrontier()).To(HaveKeyWithValue("n0", uint64(1)))
+		}
+
+		Expect(observer.snapshot().stable).To(Equal([]string{id}))
+		Expect(nodes[0].IsStable("unknown-id")).To(BeFalse())
+	})
+
+	It("evicts the stable messages from buffer", func() {
+		mux := &sync.Mutex{}
+		delivered := map[string]int{}
+
+		nodes := newTestCluster(3, func(cfg *Config) {
+			host := cfg.Host.String()
+
+			cfg.EvictStable = true
+			cfg.Callbacks = map[string]func(any, *slog.Logger) error{
+				"count": func(any, *slog.Logger) error {
+					mux.Lock()
+					defer mux.Unlock()
+
+					delivered[host]++
+
+					return nil
+				},
+			}
+		})
+
+		var ids []string
+
+		for i, b := range nodes {
+			id, err := b.AddMessageWithID(context.Background(), i, "count")
+			Expect(err).ToNot(HaveOccurred())
+
+			ids = append(ids, id)
+		}
+
+		for _, b := range nodes {
+			Eventually(b.messageBuffer.Length).Should(BeZero())
+
+			for _, id := range ids {
+				Expect(b.IsStable(id)).To(BeTrue())
+			}
+		}
+
+		// the evicted messages are not delivered again
+		Consistently(func() map[string]int {
+			mux.Lock()
+			defer mux.Unlock()
+
+			return map[string]int{"n0": delivered["n0"], "n1": delivered["n1"], "n2": delivered["n2"]}
+		}, 100*time.Millisecond).Should(Equal(map[string]int{"n0": 3, "n1": 3, "n2": 3}))
+	})
+
+	It("remembers the removed messages independently of the hold-back timeout", func() {
+		b, err := New(&Config{
+			Host:             &fakeHost{},
+			BufferSize:       25,
+			HoldBackTimeout:  time.Hour,
+			StabilityTimeout: time.Minute,
+			SupersededTTL:    time.Second,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(b.stability.timeout).To(Equal(time.Minute))
+		Expect(b.stability.evicted.ttl).To(Equal(time.Minute))
+		Expect(b.superseded.ttl).To(Equal(time.Second))
+	})
+
+	It("keeps the stability summaries of members only", func() {
+		b := newTestCluster(2, nil)[0]
+
+		gossip := func(host string) []byte {
+			return []byte(fmt.Sprintf(`{"host":%q,"digest":[],"received":{"n0":1},"epochs":{"n0":1}}`, host))
+		}
+
+		b.stability.receive("n0", 1, 1, time.Now())
+
+		for _, host := range []string{"n1", "n9"} {
+			_, err := b.Handle(context.Background(), GossipRoute, gossip(host))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		b.stability.mux.Lock()
+		defer b.stability.mux.Unlock()
+
+		Expect(b.stability.reported).To(Equal(map[string]map[string]uint64{"n1": {"n0": 1}}))
+	})
+
+	It("uses 10 gossip rounds as default stability timeout and superseded TTL", func() {
+		b, err := New(&Config{
+			Host:            &fakeHost{},
+			BufferSize:      25,
+			RoundDuration:   time.Second,
+			HoldBackTimeout: time.Hour,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(b.stability.timeout).To(Equal(10 * time.Second))
+		Expect(b.superseded.ttl).To(Equal(10 * time.Second))
+	})
+
+	It("returns error when the stability timeout or the superseded TTL is negative", func() {
+		_, err := New(&Config{Host: &fakeHost{}, BufferSize: 25, StabilityTimeout: -time.Second})
+		Expect(err).To(MatchError(errInvalidStability))
+
+		_, err = New(&Config{Host: &fakeHost{}, BufferSize: 25, SupersededTTL: -time.Second})
+		Expect(err).To(MatchError(errInvalidSupersededTTL))
+	})
+
+	Describe("stabilityTracker", func() {
+		var (
+			tracker *stabilityTracker
+			now     time.Time
+		)
+
+		received := func() map[string]uint64 {
+			summary, _ := tracker.summary()
+
+			return summary
+		}
+
+		BeforeEach(func() {
+			tracker = newStabilityTracker(time.Second)
+			now = time.Now()
+		})
+
+		It("advances the frontier when all members received the messages", func() {
+			for _, seq := range []uint64{1, 3, 2} {
+				tracker.receive("n1", 1, seq, now)
+			}
+
+			Expect(tracker.hasReceived("n1", 1, 2)).To(BeTrue())
+			Expect(tracker.hasReceived("n1", 1, 4)).To(BeFalse())
+			Expect(received()).To(Equal(map[string]uint64{"n1": 3}))
+
+			tracker.report("n1", map[string]uint64{"n1": 3}, map[string]uint64{"n1": 1})
+			Expect(tracker.update([]string{"n1", "n2"}, now)).To(BeEmpty())
+			Expect(tracker.isStable("n1", 1, 1)).To(BeFalse())
+
+			tracker.report("n2", map[string]uint64{"n1": 2}, map[string]uint64{"n1": 1})
+			Expect(tracker.update([]string{"n1", "n2"}, now)).To(Equal(map[string]uint64{"n1": 0}))
+			Expect(tracker.isStable("n1", 1, 2)).To(BeTrue())
+			Expect(tracker.isStable("n1", 1, 3)).To(BeFalse())
+			Expect(tracker.stableFrontier()).To(Equal(map[string]uint64{"n1": 2}))
+		})
+
+		It("gives up on the messages missing longer than the timeout", func() {
+			tracker.receive("n1", 1, 3, now)
+			Expect(received()).To(BeEmpty())
+
+			tracker.update(nil, now.Add(time.Second/2))
+			Expect(received()).To(BeEmpty())
+
+			tracker.update(nil, now.Add(time.Second))
+			Expect(received()).To(Equal(map[string]uint64{"n1": 3}))
+			Expect(tracker.hasReceived("n1", 1, 1)).To(BeTrue())
+		})
+
+		It("starts again when the origin restarts", func() {
+			for _, seq := range []uint64{1, 2} {
+				tracker.receive("n1", 1, seq, now)
+			}
+
+			tracker.report("n2", map[string]uint64{"n1": 2}, map[string]uint64{"n1": 1})
+			tracker.update([]string{"n2"}, now)
+			Expect(tracker.isStable("n1", 1, 2)).To(BeTrue())
+
+			tracker.receive("n1", 2, 1, now)
+			Expect(tracker.hasReceived("n1", 2, 1)).To(BeTrue())
+			Expect(tracker.hasReceived("n1", 2, 2)).To(BeFalse())
+			Expect(tracker.isStable("n1", 1, 2)).To(BeFalse())
+
+			summary, epochs := tracker.summary()
+			Expect(summary).To(Equal(map[string]uint64{"n1": 1}))
+			Expect(epochs).To(Equal(map[string]uint64{"n1": 2}))
+
+			// the elements and reports from the previous epoch are ignored
+			Expect(tracker.hasReceived("n1", 1, 3)).To(BeTrue())
+			tracker.report("n2", map[string]uint64{"n1": 2}, map[string]uint64{"n1": 1})
+			Expect(tracker.update([]string{"n2"}, now)).To(BeEmpty())
+
+			tracker.report("n2", map[string]uint64{"n1": 1}, map[string]uint64{"n1": 2})
+			Expect(tracker.update([]string{"n2"}, now)).To(Equal(map[string]uint64{"n1": 0}))
+			Expect(tracker.isStable("n1", 2, 1)).To(BeTrue())
+		})
+
+		It("ignores the reports about unknown origins and forgets the former members", func() {
+			tracker.receive("n1", 1, 1, now)
+
+			tracker.report("n2", map[string]uint64{"n1": 1, "n3": 5}, nil)
+			tracker.report("n4", map[string]uint64{"n1": 1}, nil)
+			Expect(tracker.reported).To(Equal(map[string]map[string]uint64{
+				"n2": {"n1": 1},
+				"n4": {"n1": 1},
+			}))
+
+			Expect(tracker.update([]string{"n2"}, now)).To(Equal(map[string]uint64{"n1": 0}))
+			Expect(tracker.reported).To(Equal(map[string]map[string]uint64{"n2": {"n1": 1}}))
+		})
+
+		It("forgets the evicted messages after the timeout", func() {
+			tracker.evicted.add(now, "b", "a")
+			Expect(tracker.evicted.list()).To(Equal([]string{"a", "b"}))
+			Expect(tracker.evicted.has("a")).To(BeTrue())
+
+			tracker.update(nil, now.Add(time.Second))
+			Expect(tracker.evicted.len()).To(BeZero())
+		})
+	})
+})
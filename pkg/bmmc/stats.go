@@ -0,0 +1,60 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"sync/atomic"
+)
+
+// Stats are the statistics of the protocol.
+type Stats struct {
+	// RejectedElements is the number of received elements rejected
+	// because they failed verification (e.g. invalid signature) or were
+	// rejected by the membership policy.
+	RejectedElements uint64
+	// RejectedSenders is the number of received messages rejected because of their sender.
+	RejectedSenders uint64
+	// RejectedMessages is the number of received messages rejected because
+	// they cannot be decoded or they exceed the configured limits.
+	RejectedMessages uint64
+	// RateLimitedElements is the number of elements not sent in synchronization
+	// messages because of the synchronization rate limit.
+	RateLimitedElements uint64
+	// SkippedMessages is the number of missing messages skipped by the delivery
+	// order, because they were not received before the hold-back limit or timeout.
+	SkippedMessages uint64
+}
+
+// stats holds the counters of the protocol.
+type stats struct {
+	rejectedElements    atomic.Uint64
+	rejectedSenders     atomic.Uint64
+	rejectedMessages    atomic.Uint64
+	rateLimitedElements atomic.Uint64
+	skippedMessages     atomic.Uint64
+}
+
+// Stats returns the statistics of the protocol.
+func (b *BMMC) Stats() Stats {
+	return Stats{
+		RejectedElements:    b.stats.rejectedElements.Load(),
+		RejectedSenders:     b.stats.rejectedSenders.Load(),
+		RejectedMessages:    b.stats.rejectedMessages.Load(),
+		RateLimitedElements: b.stats.rateLimitedElements.Load(),
+		SkippedMessages:     b.stats.skippedMessages.Load(),
+	}
+}
@@ -0,0 +1,573 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
+)
+
+const (
+	// TopicRoutePrefix is the prefix of the routes of topic messages.
+	// The route of a topic message is TopicRoutePrefix + topic + protocol route,
+	// e.g. /topic/news/gossip.
+	TopicRoutePrefix = "/topic/"
+
+	// subscriptionsCallback is the callback type of the subscriptions of hosts
+	subscriptionsCallback = "topic-subscriptions"
+
+	// topicLabel is the label of the logs and of the metrics of a topic
+	topicLabel = "topic"
+	// topicAttribute is the attribute of the spans of a topic
+	topicAttribute = "bmmc.topic"
+
+	subscribeErrFmt   = "error at subscribing to topic %q: %w"
+	unsubscribeErrFmt = "error at unsubscribing from topic %q: %w"
+	notSubscribedFmt  = "%w: %q"
+	subscribersErrFmt = "%w: %w"
+)
+
+var (
+	// ErrNotSubscribed is returned when the host is not subscribed to the topic.
+	ErrNotSubscribed = errors.New("not subscribed to topic")
+
+	errInvalidTopic       = errors.New("topic must not be empty or contain /")
+	errAlreadySubscribed  = errors.New("already subscribed to topic")
+	errInvalidSubscribers = errors.New("invalid subscriptions message")
+)
+
+// TopicRoute returns the route of the given protocol route for the given topic.
+func TopicRoute(topic, route string) string {
+	return TopicRoutePrefix + topic + route
+}
+
+// TopicConfig is the config of a topic. The empty fields are taken from the
+// config of the topics.
+type TopicConfig struct {
+	// BufferSize is the size of the messages buffer of the topic.
+	BufferSize int
+	// Beta is the expected fanout for gossip rounds of the topic.
+	Beta float64
+	// RoundDuration is the duration of the gossip rounds of the topic.
+	RoundDuration time.Duration
+	// Callbacks are the callbacks of the messages of the topic.
+	Callbacks map[string]func(any, *slog.Logger) error
+}
+
+// Topics is a group of named topics. Each topic is an independent instance of
+// the protocol, with its own messages buffer, gossip rounds and peers, which
+// are the hosts subscribed to the topic. The hosts only gossip the topics
+// they are subscribed to.
+// The subscriptions of the hosts are disseminated by a control instance, whose
+// peers are all hosts.
+type Topics struct {
+	// config of the topics, without the fields filled by New
+	config  Config
+	control *BMMC
+	mux     *sync.Mutex
+	// pub serializes the subscriptions messages, so the last one contains all subscriptions
+	pub    *sync.Mutex
+	topics map[string]*BMMC
+	// subscriptions of the other hosts, by host
+	subscriptions map[string][]string
+	started       bool
+}
+
+// NewTopics creates a group of topics with the given config.
+// The callbacks of the config are the default callbacks of the topics.
+func NewTopics(cfg *Config) (*Topics, error) {
+	t := &Topics{
+		config:        *cfg,
+		mux:           &sync.Mutex{},
+		pub:           &sync.Mutex{},
+		topics:        map[string]*BMMC{},
+		subscriptions: map[string][]string{},
+	}
+
+	controlCfg := *cfg
+	controlCfg.Callbacks = map[string]func(any, *slog.Logger) error{
+		subscriptionsCallback: t.subscriptionsCallback,
+	}
+	controlCfg.OrderedCallbacks = nil
+	controlCfg.RetractCallback = nil
+
+	if cfg.Observer != nil {
+		controlCfg.Observer = Observers{cfg.Observer, topicsObserver{t: t}}
+	} else {
+		controlCfg.Observer = topicsObserver{t: t}
+	}
+
+	control, err := New(&controlCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	t.control = control
+
+	return t, nil
+}
+
+// Start starts the control instance and the subscribed topics.
+func (t *Topics) Start() error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if err := t.control.Start(); err != nil {
+		return err
+	}
+
+	for _, b := range t.topics {
+		if err := b.Start(); err != nil {
+			return err
+		}
+	}
+
+	t.started = true
+
+	return nil
+}
+
+// Stop stops the control instance and the subscribed topics.
+func (t *Topics) Stop() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if !t.started {
+		return
+	}
+
+	t.control.Stop()
+
+	for _, b := range t.topics {
+		b.Stop()
+	}
+
+	t.started = false
+}
+
+// AddPeer adds new host, which can subscribe to topics.
+func (t *Topics) AddPeer(p string) error {
+	return t.control.AddPeer(p)
+}
+
+// RemovePeer removes given host from all topics.
+func (t *Topics) RemovePeer(p string) error {
+	return t.control.RemovePeer(p)
+}
+
+// Subscribe subscribes the host to the given topic, so it receives the messages
+// of the topic from the other subscribed hosts.
+func (t *Topics) Subscribe(ctx context.Context, topic string, topicCfg TopicConfig) error {
+	if topic == "" || strings.Contains(topic, "/") {
+		return fmt.Errorf(subscribeErrFmt, topic, errInvalidTopic)
+	}
+
+	t.mux.Lock()
+
+	if _, ok := t.topics[topic]; ok {
+		t.mux.Unlock()
+
+		return fmt.Errorf(subscribeErrFmt, topic, errAlreadySubscribed)
+	}
+
+	b, err := New(t.topicConfig(topic, topicCfg))
+	if err != nil {
+		t.mux.Unlock()
+
+		return fmt.Errorf(subscribeErrFmt, topic, err)
+	}
+
+	for host, topics := range t.subscriptions {
+		if slices.Contains(topics, topic) {
+			setSubscriber(b, host, true)
+		}
+	}
+
+	if t.started {
+		if err = b.Start(); err != nil {
+			t.mux.Unlock()
+
+			return fmt.Errorf(subscribeErrFmt, topic, err)
+		}
+	}
+
+	t.topics[topic] = b
+	t.mux.Unlock()
+
+	if err = t.publishSubscriptions(ctx); err != nil {
+		return fmt.Errorf(subscribeErrFmt, topic, err)
+	}
+
+	return nil
+}
+
+// Unsubscribe unsubscribes the host from the given topic and removes its messages.
+func (t *Topics) Unsubscribe(ctx context.Context, topic string) error {
+	t.mux.Lock()
+
+	b, ok := t.topics[topic]
+	if !ok {
+		t.mux.Unlock()
+
+		return fmt.Errorf(unsubscribeErrFmt, topic, fmt.Errorf(notSubscribedFmt, ErrNotSubscribed, topic))
+	}
+
+	if t.started {
+		b.Stop()
+	}
+
+	delete(t.topics, topic)
+	t.mux.Unlock()
+
+	if err := t.publishSubscriptions(ctx); err != nil {
+		return fmt.Errorf(unsubscribeErrFmt, topic, err)
+	}
+
+	return nil
+}
+
+// Subscriptions returns the sorted topics the host is subscribed to.
+func (t *Topics) Subscriptions() []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	topics := make([]string, 0, len(t.topics))
+	for topic := range t.topics {
+		topics = append(topics, topic)
+	}
+
+	sort.Strings(topics)
+
+	return topics
+}
+
+// Subscribers returns the other hosts subscribed to the given topic.
+func (t *Topics) Subscribers(topic string) []string {
+	b, err := t.topic(topic)
+	if err != nil {
+		return nil
+	}
+
+	return b.GetPeers()
+}
+
+// Topic returns the protocol instance of the given topic, e.g. for inspection.
+func (t *Topics) Topic(topic string) (*BMMC, bool) {
+	b, err := t.topic(topic)
+
+	return b, err == nil
+}
+
+// AddMessage adds new message in the messages buffer of the given topic.
+func (t *Topics) AddMessage(ctx context.Context, topic string, msg any, callbackType string) error {
+	b, err := t.topic(topic)
+	if err != nil {
+		return err
+	}
+
+	return b.AddMessage(ctx, msg, callbackType)
+}
+
+// GetMessages returns the user messages from the messages buffer of the given
+// topic, or nil if the host is not subscribed to the topic.
+func (t *Topics) GetMessages(topic string) []any {
+	b, err := t.topic(topic)
+	if err != nil {
+		return nil
+	}
+
+	return b.GetMessages()
+}
+
+// Handle handles a message received by the host server on the given route.
+// The messages of topics are handled by the instances of their topics, and
+// the other messages by the control instance.
+func (t *Topics) Handle(ctx context.Context, route string, body []byte) ([]byte, error) {
+	rest, ok := strings.CutPrefix(route, TopicRoutePrefix)
+	if !ok {
+		return t.control.Handle(ctx, route, body)
+	}
+
+	topic, topicRoute, ok := strings.Cut(rest, "/")
+	if !ok {
+		return nil, fmt.Errorf(unknownRouteErrFmt, ErrUnknownRoute, route)
+	}
+
+	b, err := t.topic(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Handle(ctx, "/"+topicRoute, body)
+}
+
+// topic returns the instance of the given topic.
+func (t *Topics) topic(topic string) (*BMMC, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	b, ok := t.topics[topic]
+	if !ok {
+		return nil, fmt.Errorf(notSubscribedFmt, ErrNotSubscribed, topic)
+	}
+
+	return b, nil
+}
+
+// topicConfig returns the config of the instance of the given topic.
+func (t *Topics) topicConfig(topic string, topicCfg TopicConfig) *Config {
+	cfg := t.config
+
+	cfg.Host = newTopicHost(cfg.Host, topic)
+
+	if topicCfg.BufferSize != 0 {
+		cfg.BufferSize = topicCfg.BufferSize
+	}
+
+	if topicCfg.Beta != 0 {
+		cfg.Beta = topicCfg.Beta
+	}
+
+	if topicCfg.RoundDuration != 0 {
+		cfg.RoundDuration = topicCfg.RoundDuration
+	}
+
+	if topicCfg.Callbacks != nil {
+		cfg.Callbacks = topicCfg.Callbacks
+	}
+
+	cfg.Callbacks = maps.Clone(cfg.Callbacks)
+
+	// the logs, the metrics, the events and the spans of the topic are labeled with the topic
+	if cfg.Logger != nil {
+		cfg.Logger = cfg.Logger.With(topicLabel, topic)
+	}
+
+	if metrics, ok := cfg.Metrics.(LabeledMetrics); ok {
+		cfg.Metrics = metrics.WithLabel(topicLabel, topic)
+	}
+
+	if observer, ok := cfg.Observer.(TopicObserver); ok {
+		cfg.Observer = observer.Topic(topic)
+	}
+
+	if cfg.Tracer != nil {
+		cfg.Tracer = topicTracer{Tracer: cfg.Tracer, topic: topic}
+	}
+
+	return &cfg
+}
+
+// publishSubscriptions disseminates the topics the host is subscribed to.
+// The newer subscriptions of the host replace the older ones in the buffers.
+func (t *Topics) publishSubscriptions(ctx context.Context) error {
+	t.pub.Lock()
+	defer t.pub.Unlock()
+
+	data, err := json.Marshal(t.Subscriptions())
+	if err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	return t.control.AddKeyedMessage(ctx, subscriptionsCallback+"/"+t.config.Host.String(), string(data), subscriptionsCallback)
+}
+
+// subscriptionsCallback updates the subscribers of the topics with the subscriptions of a host.
+func (t *Topics) subscriptionsCallback(data any, _ *slog.Logger) error {
+	delivery, ok := data.(Delivery)
+	if !ok {
+		return errInvalidSubscribers
+	}
+
+	raw, ok := delivery.Msg.(string)
+	if !ok {
+		return errInvalidSubscribers
+	}
+
+	var topics []string
+	if err := json.Unmarshal([]byte(raw), &topics); err != nil {
+		return fmt.Errorf(subscribersErrFmt, errInvalidSubscribers, err)
+	}
+
+	if delivery.Origin == t.config.Host.String() {
+		return nil
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.subscriptions[delivery.Origin] = topics
+
+	for topic, b := range t.topics {
+		setSubscriber(b, delivery.Origin, slices.Contains(topics, topic))
+	}
+
+	return nil
+}
+
+// removeSubscriber removes the given host from all topics.
+func (t *Topics) removeSubscriber(p string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.subscriptions, p)
+
+	for _, b := range t.topics {
+		setSubscriber(b, p, false)
+	}
+}
+
+// setSubscriber adds the given host to the peers of the instance of a topic,
+// or removes it. The peers are changed like with AddPeer and RemovePeer, so the
+// observer and the membership policy of the topic apply, but the changes are not
+// disseminated in the topic, since every host follows the subscriptions itself.
+func setSubscriber(b *BMMC, host string, subscribed bool) {
+	if subscribed == b.peerBuffer.Contains(host) {
+		return
+	}
+
+	var err error
+
+	if subscribed {
+		_, err = b.addLocalPeer(host, "")
+	} else {
+		err = b.removeLocalPeer(host)
+	}
+
+	if err != nil {
+		b.config.Logger.Error("cannot update the subscribers of the topic", "err", err, "subscriber", host)
+	}
+}
+
+// topicsObserver removes the hosts removed from the control instance from all topics.
+type topicsObserver struct {
+	BaseObserver
+
+	t *Topics
+}
+
+// OnPeerRemoved removes the host from all topics.
+func (o topicsObserver) OnPeerRemoved(p string) {
+	o.t.removeSubscriber(p)
+}
+
+// topicHost sends the messages of a topic on the routes of the topic.
+type topicHost struct {
+	peer.Peer
+
+	topic string
+}
+
+// topicRequester is a topicHost which can send requests.
+type topicRequester struct {
+	topicHost
+
+	requester peer.Requester
+}
+
+// topicMulticaster is a topicHost which can multicast messages.
+type topicMulticaster struct {
+	topicHost
+
+	multicaster peer.Multicaster
+}
+
+// topicRequesterMulticaster is a topicHost which can send requests and multicast messages.
+type topicRequesterMulticaster struct {
+	topicRequester
+
+	multicaster peer.Multicaster
+}
+
+// routedHost is a host which sends the messages on other routes than the
+// protocol routes, e.g. the host of a topic.
+type routedHost interface {
+	route(route string) string
+}
+
+// newTopicHost returns the host of the given topic.
+func newTopicHost(host peer.Peer, topic string) peer.Peer {
+	h := topicHost{Peer: host, topic: topic}
+
+	requester, canRequest := host.(peer.Requester)
+	multicaster, canMulticast := host.(peer.Multicaster)
+
+	switch {
+	case canRequest && canMulticast:
+		return topicRequesterMulticaster{
+			topicRequester: topicRequester{topicHost: h, requester: requester},
+			multicaster:    multicaster,
+		}
+	case canRequest:
+		return topicRequester{topicHost: h, requester: requester}
+	case canMulticast:
+		return topicMulticaster{topicHost: h, multicaster: multicaster}
+	}
+
+	return h
+}
+
+// route returns the route of the given protocol route for the topic.
+func (h topicHost) route(route string) string {
+	return TopicRoute(h.topic, route)
+}
+
+// Send sends a message of the topic.
+func (h topicHost) Send(msg []byte, route string, peerToSend string) error {
+	return h.Peer.Send(msg, h.route(route), peerToSend) //nolint: wrapcheck
+}
+
+// Request sends a message of the topic and returns the response body.
+func (h topicRequester) Request(msg []byte, route string, peerToSend string) ([]byte, error) {
+	return h.requester.Request(msg, h.route(route), peerToSend) //nolint: wrapcheck
+}
+
+// Multicast sends a message of the topic to all hosts at once.
+func (h topicMulticaster) Multicast(msg []byte, route string) error {
+	return h.multicaster.Multicast(msg, h.route(route)) //nolint: wrapcheck
+}
+
+// Multicast sends a message of the topic to all hosts at once.
+func (h topicRequesterMulticaster) Multicast(msg []byte, route string) error {
+	return h.multicaster.Multicast(msg, h.route(route)) //nolint: wrapcheck
+}
+
+// topicTracer sets the topic attribute of the spans of a topic.
+type topicTracer struct {
+	Tracer
+
+	topic string
+}
+
+// Start starts a span of the topic.
+func (t topicTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := t.Tracer.Start(ctx, name)
+	span.SetAttribute(topicAttribute, t.topic)
+
+	return ctx, span
+}
@@ -0,0 +1,373 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+// newTopicsCluster creates a full mesh of started topics, named "n0", "n1", etc.
+// The routes received by each host are recorded in the returned function.
+// The given function, if any, customizes the config of each host.
+func newTopicsCluster(size int, customize func(*Config)) ([]*Topics, func(host string) []string) {
+	network := memory.NewNetwork()
+
+	var mux sync.Mutex
+
+	routes := map[string][]string{}
+
+	nodes, stop, err := memory.FullMesh(network, size, func(name string) (*Topics, memory.MeshNode, error) {
+		cfg := &Config{
+			Host:          network.Peer(name),
+			BufferSize:    64,
+			RoundDuration: 10 * time.Millisecond,
+			Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+
+		if customize != nil {
+			customize(cfg)
+		}
+
+		t, err := NewTopics(cfg)
+		if err != nil {
+			return nil, memory.MeshNode{}, err
+		}
+
+		handler := func(ctx context.Context, route string, body []byte) ([]byte, error) {
+			mux.Lock()
+			routes[name] = append(routes[name], route)
+			mux.Unlock()
+
+			return t.Handle(ctx, route, body)
+		}
+
+		return t, memory.MeshNode{Handler: handler, Member: t}, nil
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	DeferCleanup(stop)
+
+	return nodes, func(host string) []string {
+		mux.Lock()
+		defer mux.Unlock()
+
+		return append([]string{}, routes[host]...)
+	}
+}
+
+// labeledMetrics records the reported metrics by label.
+type labeledMetrics struct {
+	*fakeMetrics
+
+	mux    *sync.Mutex
+	labels map[string]*fakeMetrics
+}
+
+func newLabeledMetrics() *labeledMetrics {
+	return &labeledMetrics{
+		fakeMetrics: newFakeMetrics(),
+		mux:         &sync.Mutex{},
+		labels:      map[string]*fakeMetrics{},
+	}
+}
+
+func (m *labeledMetrics) WithLabel(key, value string) Metrics {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	label := key + "=" + value
+	if m.labels[label] == nil {
+		m.labels[label] = newFakeMetrics()
+	}
+
+	return m.labels[label]
+}
+
+func (m *labeledMetrics) label(label string) *fakeMetrics {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.labels[label]
+}
+
+// topicObservers records the events of each topic.
+type topicObservers struct {
+	*recordingObserver
+
+	mux    *sync.Mutex
+	topics map[string]*recordingObserver
+}
+
+func (o *topicObservers) Topic(topic string) Observer {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	if o.topics[topic] == nil {
+		o.topics[topic] = newRecordingObserver()
+	}
+
+	return o.topics[topic]
+}
+
+func (o *topicObservers) topic(topic string) *recordingObserver {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	return o.topics[topic]
+}
+
+var _ = Describe("Topics", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("disseminates the messages of a topic only to its subscribers", func() {
+		nodes, routesOf := newTopicsCluster(3, nil)
+
+		Expect(nodes[0].Subscribe(ctx, "news", TopicConfig{})).To(Succeed())
+		Expect(nodes[1].Subscribe(ctx, "news", TopicConfig{})).To(Succeed())
+		Expect(nodes[2].Subscribe(ctx, "sports", TopicConfig{})).To(Succeed())
+
+		Eventually(func() []string { return nodes[0].Subscribers("news") }).Should(ConsistOf("n1"))
+		Eventually(func() []string { return nodes[1].Subscribers("news") }).Should(ConsistOf("n0"))
+		Expect(nodes[2].Subscribers("sports")).To(BeEmpty())
+
+		Expect(nodes[0].AddMessage(ctx, "news", "headline", NOCALLBACK)).To(Succeed())
+		Eventually(func() []any { return nodes[1].GetMessages("news") }).Should(ConsistOf("headline"))
+
+		Expect(nodes[2].GetMessages("news")).To(BeNil())
+		Expect(nodes[2].AddMessage(ctx, "news", "headline", NOCALLBACK)).To(MatchError(ErrNotSubscribed))
+
+		Consistently(func() []string {
+			var topicRoutes []string
+
+			for _, route := range routesOf("n2") {
+				if strings.HasPrefix(route, TopicRoute("news", "")) {
+					topicRoutes = append(topicRoutes, route)
+				}
+			}
+
+			return topicRoutes
+		}, 100*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("keeps independent buffers for each topic", func() {
+		nodes, _ := newTopicsCluster(2, nil)
+
+		for _, t := range nodes {
+			Expect(t.Subscribe(ctx, "chatty", TopicConfig{BufferSize: 2})).To(Succeed())
+			Expect(t.Subscribe(ctx, "quiet", TopicConfig{RoundDuration: 20 * time.Millisecond})).To(Succeed())
+		}
+
+		Expect(nodes[0].AddMessage(ctx, "quiet", "important", NOCALLBACK)).To(Succeed())
+
+		for i := 0; i < 5; i++ {
+			Expect(nodes[0].AddMessage(ctx, "chatty", fmt.Sprintf("msg-%d", i), NOCALLBACK)).To(Succeed())
+		}
+
+		Expect(nodes[0].GetMessages("chatty")).To(HaveLen(2))
+		Expect(nodes[0].GetMessages("quiet")).To(ConsistOf("important"))
+		Eventually(func() []any { return nodes[1].GetMessages("quiet") }).Should(ConsistOf("important"))
+
+		chatty, ok := nodes[0].Topic("chatty")
+		Expect(ok).To(BeTrue())
+		Expect(chatty.Inspect().Config.BufferSize).To(Equal(2))
+		Expect(nodes[0].Subscriptions()).To(Equal([]string{"chatty", "quiet"}))
+	})
+
+	It("removes the unsubscribed hosts from the subscribers", func() {
+		nodes, _ := newTopicsCluster(2, nil)
+
+		for _, t := range nodes {
+			Expect(t.Subscribe(ctx, "news", TopicConfig{})).To(Succeed())
+		}
+
+		Eventually(func() []string { return nodes[0].Subscribers("news") }).Should(ConsistOf("n1"))
+
+		Expect(nodes[1].Unsubscribe(ctx, "news")).To(Succeed())
+		Eventually(func() []string { return nodes[0].Subscribers("news") }).Should(BeEmpty())
+		Expect(nodes[1].Subscriptions()).To(BeEmpty())
+
+		Expect(nodes[1].Unsubscribe(ctx, "news")).To(MatchError(ErrNotSubscribed))
+	})
+
+	It("changes the subscribers like the peers of the topic", func() {
+		observer := &topicObservers{
+			recordingObserver: newRecordingObserver(),
+			mux:               &sync.Mutex{},
+			topics:            map[string]*recordingObserver{},
+		}
+
+		nodes, _ := newTopicsCluster(3, func(cfg *Config) {
+			if cfg.Host.String() == "n0" {
+				cfg.Observer = observer
+			}
+		})
+
+		// the membership policy of the topics allows only n1
+		nodes[0].config.MembershipPolicy = &AllowlistPolicy{Peers: []string{"n1"}}
+
+		for _, t := range nodes {
+			Expect(t.Subscribe(ctx, "news", TopicConfig{})).To(Succeed())
+		}
+
+		Eventually(func() []string { return nodes[0].Subscribers("news") }).Should(ConsistOf("n1"))
+		Eventually(func() []string { return nodes[2].Subscribers("news") }).Should(ConsistOf("n0", "n1"))
+		Consistently(func() []string { return nodes[0].Subscribers("news") }, 100*time.Millisecond).Should(ConsistOf("n1"))
+
+		Expect(nodes[1].Unsubscribe(ctx, "news")).To(Succeed())
+		Eventually(func() []string { return nodes[0].Subscribers("news") }).Should(BeEmpty())
+
+		Expect(observer.topic("news").snapshot().peers).To(Equal([]string{"+n1", "-n1"}))
+	})
+
+	It("rejects the messages of a topic replayed on other topics", func() {
+		nodes, _ := newTopicsCluster(2, func(cfg *Config) {
+			cfg.AuthKeys = map[string][]byte{"auth-1": []byte("secret")}
+			cfg.AuthKeyID = "auth-1"
+		})
+
+		for _, t := range nodes {
+			Expect(t.Subscribe(ctx, "news", TopicConfig{})).To(Succeed())
+			Expect(t.Subscribe(ctx, "sports", TopicConfig{})).To(Succeed())
+		}
+
+		news, ok := nodes[0].Topic("news")
+		Expect(ok).To(BeTrue())
+
+		body, err := news.seal(GossipRoute, []byte(`{"host":"n0","digest":[]}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = nodes[1].Handle(ctx, TopicRoute("sports", GossipRoute), body)
+		Expect(err).To(MatchError(ErrUnauthenticated))
+
+		_, err = nodes[1].Handle(ctx, GossipRoute, body)
+		Expect(err).To(MatchError(ErrUnauthenticated))
+
+		_, err = nodes[1].Handle(ctx, TopicRoute("news", GossipRoute), body)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("multicasts the messages of a topic on the routes of the topic", func() {
+		host := &fakeMulticastHost{multicasts: make(chan multicastMsg, 16)}
+
+		t, err := NewTopics(&Config{Host: host, BufferSize: 16})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(t.Subscribe(ctx, "news", TopicConfig{})).To(Succeed())
+		Expect(t.AddMessage(ctx, "news", "headline", NOCALLBACK)).To(Succeed())
+
+		var routes []string
+
+		Eventually(func() []string {
+			for len(host.multicasts) > 0 {
+				routes = append(routes, (<-host.multicasts).route)
+			}
+
+			return routes
+		}).Should(ContainElement(TopicRoute("news", SynchronizationRoute)))
+	})
+
+	It("calls the callbacks of the topic", func() {
+		nodes, _ := newTopicsCluster(2, nil)
+
+		var (
+			mux      sync.Mutex
+			received []any
+		)
+
+		callbacks := map[string]func(any, *slog.Logger) error{
+			"alert": func(data any, _ *slog.Logger) error {
+				mux.Lock()
+				defer mux.Unlock()
+
+				received = append(received, data.(Delivery).Msg)
+
+				return nil
+			},
+		}
+
+		Expect(nodes[0].Subscribe(ctx, "alerts", TopicConfig{})).To(Succeed())
+		Expect(nodes[1].Subscribe(ctx, "alerts", TopicConfig{Callbacks: callbacks})).To(Succeed())
+		Eventually(func() []string { return nodes[0].Subscribers("alerts") }).Should(ConsistOf("n1"))
+
+		Expect(nodes[0].AddMessage(ctx, "alerts", "fire", "alert")).To(Succeed())
+
+		Eventually(func() []any {
+			mux.Lock()
+			defer mux.Unlock()
+
+			return append([]any{}, received...)
+		}).Should(ConsistOf("fire"))
+	})
+
+	It("returns error for invalid topics", func() {
+		nodes, _ := newTopicsCluster(1, nil)
+
+		Expect(nodes[0].Subscribe(ctx, "", TopicConfig{})).To(MatchError(errInvalidTopic))
+		Expect(nodes[0].Subscribe(ctx, "a/b", TopicConfig{})).To(MatchError(errInvalidTopic))
+
+		Expect(nodes[0].Subscribe(ctx, "news", TopicConfig{})).To(Succeed())
+		Expect(nodes[0].Subscribe(ctx, "news", TopicConfig{})).To(MatchError(errAlreadySubscribed))
+
+		_, err := nodes[0].Handle(ctx, TopicRoute("sports", GossipRoute), nil)
+		Expect(err).To(MatchError(ErrNotSubscribed))
+	})
+
+	It("labels the metrics, the events and the spans of the topics", func() {
+		metrics := newLabeledMetrics()
+		tracer := newFakeTracer()
+		observer := &topicObservers{
+			recordingObserver: newRecordingObserver(),
+			mux:               &sync.Mutex{},
+			topics:            map[string]*recordingObserver{},
+		}
+
+		t, err := NewTopics(&Config{
+			Host:       &fakeHost{},
+			BufferSize: 16,
+			Metrics:    metrics,
+			Tracer:     tracer,
+			Observer:   observer,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(t.Subscribe(ctx, "news", TopicConfig{})).To(Succeed())
+		Expect(t.AddMessage(ctx, "news", "headline", NOCALLBACK)).To(Succeed())
+
+		Expect(metrics.label("topic=news").gauge(MetricBufferSize)).To(Equal(float64(1)))
+		Expect(observer.topic("news").added).To(HaveLen(1))
+		Expect(observer.added).ToNot(ContainElement(observer.topic("news").added[0]))
+
+		spans := tracer.ended(SpanAddMessage)
+		Expect(spans).ToNot(BeEmpty())
+		Expect(spans[len(spans)-1].attributes).To(HaveKeyWithValue(topicAttribute, "news"))
+	})
+})
@@ -0,0 +1,420 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+// defaultSilenceRounds is the default number of gossip rounds after which
+// a silent member no longer holds back the elements of ordered callbacks.
+const defaultSilenceRounds = 50
+
+// orderKey is the position of an element in the total order.
+type orderKey struct {
+	lamport uint64
+	origin  string
+}
+
+func keyOf(el buffer.Element) orderKey {
+	return orderKey{lamport: el.Lamport, origin: el.Origin}
+}
+
+// less returns true if k is before o in the total order.
+func (k orderKey) less(o orderKey) bool {
+	if k.lamport != o.lamport {
+		return k.lamport < o.lamport
+	}
+
+	return k.origin < o.origin
+}
+
+// memberMark is what the host knows about the elements created by a member.
+type memberMark struct {
+	// lamport and seq are the last Lamport clock and sequence number reported by the member
+	lamport uint64
+	seq     uint64
+	// received are the elements received from the member
+	received *seqWindow
+	// stable is the Lamport clock up to which all elements from the member were received
+	stable uint64
+	// gapSince is the time since the elements up to the reported sequence number are missing
+	gapSince time.Time
+	// lastSeen is the last time the member reported its clock or an element from it was received
+	lastSeen time.Time
+}
+
+// totalOrderQueue holds back the elements of ordered callbacks until they are
+// stable, and releases them by Lamport timestamp and origin. An element is stable
+// when every member reported a Lamport clock not lower than its timestamp and
+// the host received all elements created by the member before the report,
+// since the next elements of the member will have greater timestamps.
+// Members silent for longer than the silence timeout (e.g. crashed members) are
+// not waited for, so their elements received afterwards may be skipped.
+type totalOrderQueue struct {
+	mux     *sync.Mutex
+	timeout time.Duration
+	silence time.Duration
+	epochs  epochs
+	marks   map[string]*memberMark
+	// held are the elements held back, sorted by their position in the total order
+	held []heldElement
+	// last is the position of the last released element
+	last orderKey
+	// draining is true while an element released from queue is delivered
+	draining bool
+}
+
+func newTotalOrderQueue(timeout time.Duration, silence time.Duration) *totalOrderQueue {
+	return &totalOrderQueue{
+		mux:     &sync.Mutex{},
+		timeout: timeout,
+		silence: silence,
+		epochs:  epochs{},
+		marks:   map[string]*memberMark{},
+	}
+}
+
+// mark returns the mark of the given member. Members are seen for the first
+// time when they get a mark.
+func (q *totalOrderQueue) mark(member string, now time.Time) *memberMark {
+	m, ok := q.marks[member]
+	if !ok {
+		m = &memberMark{received: newSeqWindow(), lastSeen: now}
+		q.marks[member] = m
+	}
+
+	return m
+}
+
+// silent returns true if the given member was not seen for longer than the
+// silence timeout.
+func (q *totalOrderQueue) silent(m *memberMark, now time.Time) bool {
+	return now.Sub(m.lastSeen) >= q.silence
+}
+
+// observe records the epoch of a member. It returns false if the epoch is a
+// previous epoch of the member, and forgets the mark of the member if it restarted.
+func (q *totalOrderQueue) observe(member string, epoch uint64) bool {
+	switch q.epochs.observe(member, epoch) {
+	case -1:
+		return false
+	case 1:
+		delete(q.marks, member)
+	}
+
+	return true
+}
+
+// report records the Lamport clock and the last sequence number reported by a member.
+func (q *totalOrderQueue) report(member string, epoch uint64, lamport uint64, seq uint64, now time.Time) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if !q.observe(member, epoch) {
+		return
+	}
+
+	m := q.mark(member, now)
+	m.lastSeen = now
+
+	if lamport > m.lamport {
+		m.lamport = lamport
+	}
+
+	if seq > m.seq {
+		m.seq = seq
+	}
+
+	q.refresh(m, now)
+}
+
+// receive records an element received from the given origin.
+func (q *totalOrderQueue) receive(origin string, epoch uint64, seq uint64, now time.Time) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.receiveLocked(origin, epoch, seq, now)
+}
+
+func (q *totalOrderQueue) receiveLocked(origin string, epoch uint64, seq uint64, now time.Time) {
+	if !q.observe(origin, epoch) {
+		return
+	}
+
+	m := q.mark(origin, now)
+	m.lastSeen = now
+
+	m.received.add(seq)
+	q.refresh(m, now)
+}
+
+// refresh updates the stable clock of a member.
+func (q *totalOrderQueue) refresh(m *memberMark, now time.Time) {
+	if m.received.upTo >= m.seq {
+		m.stable = m.lamport
+		m.gapSince = time.Time{}
+
+		return
+	}
+
+	if m.gapSince.IsZero() {
+		m.gapSince = now
+	}
+}
+
+// push adds an element in queue and records it as received.
+// It returns the number of skipped elements: the element is skipped if elements
+// after it were already released.
+func (q *totalOrderQueue) push(h heldElement) uint64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.receiveLocked(h.el.Origin, h.el.Epoch, h.el.Seq, h.received)
+
+	key := keyOf(h.el)
+	if !q.last.less(key) {
+		return 1
+	}
+
+	i := sort.Search(len(q.held), func(i int) bool {
+		return key.less(keyOf(q.held[i].el))
+	})
+
+	q.held = append(q.held, heldElement{})
+	copy(q.held[i+1:], q.held[i:])
+	q.held[i] = h
+
+	return 0
+}
+
+// frontier returns the Lamport clock up to which the elements are stable,
+// for the given members and the clock of the host. Silent members are ignored.
+func (q *totalOrderQueue) frontier(members []string, clock uint64, now time.Time) uint64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	frontier := clock
+
+	for _, member := range members {
+		m := q.mark(member, now)
+
+		if !q.silent(m, now) && m.stable < frontier {
+			frontier = m.stable
+		}
+	}
+
+	return frontier
+}
+
+// startDraining returns true if the caller must deliver the released elements,
+// i.e. no other caller is delivering them.
+func (q *totalOrderQueue) startDraining() bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.draining {
+		return false
+	}
+
+	q.draining = true
+
+	return true
+}
+
+// pop releases the first element if it is stable. When there is no stable
+// element, the caller stops draining the queue.
+func (q *totalOrderQueue) pop(frontier uint64) (heldElement, bool) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if len(q.held) == 0 || q.held[0].el.Lamport > frontier {
+		q.draining = false
+
+		return heldElement{}, false
+	}
+
+	h := q.held[0]
+	q.held = q.held[1:]
+	q.last = keyOf(h.el)
+
+	return h, true
+}
+
+// expire skips the missing elements of the members with elements missing
+// longer than the timeout. It returns the number of skipped elements.
+func (q *totalOrderQueue) expire(now time.Time) uint64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	var skipped uint64
+
+	for _, m := range q.marks {
+		if m.gapSince.IsZero() || now.Sub(m.gapSince) < q.timeout {
+			continue
+		}
+
+		skipped += m.received.skipTo(m.seq)
+
+		q.refresh(m, now)
+	}
+
+	return skipped
+}
+
+// missing returns the missing sequence numbers of each member, indexed by member.
+func (q *totalOrderQueue) missing() map[string]map[string][]uint64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	missing := map[string]map[string][]uint64{}
+
+	for member, m := range q.marks {
+		if seqs := m.received.missing(m.seq, maxSolicitedSequences); len(seqs) > 0 {
+			missing[member] = map[string][]uint64{member: seqs}
+		}
+	}
+
+	return missing
+}
+
+// pending returns the elements held back, in total order, with the members
+// that are not known to have advanced past their timestamps, and the time
+// until which each of them is waited for.
+func (q *totalOrderQueue) pending(members []string, clock uint64, self string, now time.Time) []PendingMessage {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	pending := make([]PendingMessage, len(q.held))
+
+	for i, h := range q.held {
+		unstable := []string{}
+		waitingUntil := map[string]time.Time{}
+
+		if clock < h.el.Lamport {
+			unstable = append(unstable, self)
+		}
+
+		for _, member := range members {
+			m := q.mark(member, now)
+
+			if !q.silent(m, now) && m.stable < h.el.Lamport {
+				unstable = append(unstable, member)
+				waitingUntil[member] = m.lastSeen.Add(q.silence)
+			}
+		}
+
+		pending[i] = PendingMessage{
+			ElementInfo:  elementInfo(h.el),
+			Seq:          h.el.Seq,
+			Waiting:      map[string]uint64{},
+			HeldSince:    h.received,
+			Lamport:      h.el.Lamport,
+			Unstable:     unstable,
+			WaitingUntil: waitingUntil,
+		}
+	}
+
+	return pending
+}
+
+// orderedCallback returns true if the messages with given callback type are
+// delivered in total order.
+func (b *BMMC) orderedCallback(callbackType string) bool {
+	_, ok := b.config.OrderedCallbacks[callbackType]
+
+	return ok
+}
+
+// dispatch runs the callbacks of a received element, or holds it back in the
+// total order queue if it is an element of an ordered callback.
+func (b *BMMC) dispatch(ctx context.Context, el buffer.Element) {
+	if b.totalOrder == nil || el.Seq == 0 {
+		b.runCallbacks(ctx, el)
+
+		return
+	}
+
+	if el.Lamport == 0 || !b.orderedCallback(el.CallbackType) {
+		b.totalOrder.receive(el.Origin, el.Epoch, el.Seq, time.Now())
+		b.runCallbacks(ctx, el)
+		b.releaseOrdered()
+
+		return
+	}
+
+	if skipped := b.totalOrder.push(b.holdElement(ctx, el, "")); skipped > 0 {
+		b.stats.skippedMessages.Add(skipped)
+		b.config.Logger.Warn("skipped message received after the next messages in total order", "id", el.ID)
+	}
+
+	b.releaseOrdered()
+}
+
+// releaseOrdered runs the callbacks of the stable elements, in total order.
+// The elements are delivered by a single caller at a time, so callbacks can
+// add new messages.
+func (b *BMMC) releaseOrdered() {
+	if b.totalOrder == nil || !b.totalOrder.startDraining() {
+		return
+	}
+
+	for {
+		frontier := b.totalOrder.frontier(b.peerBuffer.GetPeers(), b.sequencer.clock(), time.Now())
+
+		h, ok := b.totalOrder.pop(frontier)
+		if !ok {
+			return
+		}
+
+		b.runCallbacks(b.heldContext(h), h.el)
+	}
+}
+
+// reportOrder records the Lamport clock and the last sequence number
+// reported by a peer in a gossip message.
+func (b *BMMC) reportOrder(gossip Gossip) {
+	if b.totalOrder == nil {
+		return
+	}
+
+	b.sequencer.witness(gossip.Lamport)
+	b.totalOrder.report(gossip.Host, gossip.Epoch, gossip.Lamport, gossip.LastSeq, time.Now())
+	b.releaseOrdered()
+}
+
+// releaseOrderedBack skips the elements missing from the total order for longer
+// than the hold-back timeout, solicits the missing elements and delivers the
+// stable elements.
+func (b *BMMC) releaseOrderedBack() {
+	if b.totalOrder == nil {
+		return
+	}
+
+	if skipped := b.totalOrder.expire(time.Now()); skipped > 0 {
+		b.stats.skippedMessages.Add(skipped)
+		b.config.Logger.Warn("skipped missing messages", "count", skipped)
+	}
+
+	b.solicitSequences(b.totalOrder.missing())
+	b.releaseOrdered()
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Total order delivery", func() {
+	var (
+		delivered map[string][]any
+		mux       *sync.Mutex
+	)
+
+	deliveredFn := func(host string) func() []any {
+		return func() []any {
+			mux.Lock()
+			defer mux.Unlock()
+
+			return append([]any{}, delivered[host]...)
+		}
+	}
+
+	newOrderedCluster := func(size int, customize func(*Config)) []*BMMC {
+		// late callbacks of a previous cluster must not change the messages of this cluster
+		clusterDelivered, clusterMux := delivered, mux
+
+		return newTestCluster(size, func(cfg *Config) {
+			host := cfg.Host.String()
+
+			appendMsg := func(data any, _ *slog.Logger) error {
+				clusterMux.Lock()
+				defer clusterMux.Unlock()
+
+				clusterDelivered[host] = append(clusterDelivered[host], data.(Delivery).Msg)
+
+				return nil
+			}
+
+			cfg.Callbacks = map[string]func(any, *slog.Logger) error{"unordered": appendMsg}
+			cfg.OrderedCallbacks = map[string]func(any, *slog.Logger) error{"ordered": appendMsg}
+
+			if customize != nil {
+				customize(cfg)
+			}
+		})
+	}
+
+	BeforeEach(func() {
+		delivered = map[string][]any{}
+		mux = &sync.Mutex{}
+	})
+
+	It("returns error when a callback type is both ordered and unordered", func() {
+		cb := map[string]func(any, *slog.Logger) error{
+			"my-callback": func(any, *slog.Logger) error { return nil },
+		}
+
+		_, err := New(&Config{
+			Host:             &fakeHost{},
+			BufferSize:       25,
+			Callbacks:        cb,
+			OrderedCallbacks: cb,
+		})
+		Expect(err).To(MatchError(errOrderedCallbackType))
+	})
+
+	It("delivers the messages in the same order on all hosts", func() {
+		nodes := newOrderedCluster(3, nil)
+
+		var wg sync.WaitGroup
+
+		for _, b := range nodes {
+			wg.Add(1)
+
+			go func(b *BMMC) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for i := 0; i < 5; i++ {
+					msg := fmt.Sprintf("%s-%d", b.config.Host.String(), i)
+					Expect(b.AddMessage(context.Background(), msg, "ordered")).To(Succeed())
+				}
+			}(b)
+		}
+
+		wg.Wait()
+
+		Eventually(deliveredFn("n0")).Should(HaveLen(15))
+
+		order := deliveredFn("n0")()
+		for _, host := range []string{"n1", "n2"} {
+			Eventually(deliveredFn(host)).Should(Equal(order))
+		}
+
+		// the messages from each origin are delivered in the order they were added
+		Expect(order).To(ContainElements("n1-0", "n1-4"))
+		Expect(indexOf(order, "n1-0")).To(BeNumerically("<", indexOf(order, "n1-4")))
+
+		for _, b := range nodes {
+			Expect(b.Pending()).To(BeEmpty())
+		}
+	})
+
+	It("holds back the messages until all members advanced past them", func() {
+		b := newOrderedCluster(1, func(cfg *Config) {
+			cfg.SilenceTimeout = time.Hour
+		})[0]
+
+		// n9 is not registered in the network, so it never reports its clock
+		Expect(b.AddPeer("n9")).To(Succeed())
+
+		Expect(b.AddMessage(context.Background(), "first", "ordered")).To(Succeed())
+		Expect(b.AddMessage(context.Background(), "second", "unordered")).To(Succeed())
+
+		Expect(deliveredFn("n0")()).To(Equal([]any{"second"}))
+
+		pending := b.Pending()
+		Expect(pending).To(HaveLen(1))
+		Expect(pending[0].Lamport).To(Equal(uint64(1)))
+		Expect(pending[0].Unstable).To(Equal([]string{"n9"}))
+		Expect(pending[0].WaitingUntil).To(HaveKeyWithValue("n9", BeTemporally(">", time.Now().Add(time.Minute))))
+
+		Expect(b.RemovePeer("n9")).To(Succeed())
+
+		Eventually(deliveredFn("n0")).Should(Equal([]any{"second", "first"}))
+	})
+
+	It("stops waiting for the members silent longer than the silence timeout", func() {
+		b := newOrderedCluster(1, func(cfg *Config) {
+			cfg.SilenceTimeout = 200 * time.Millisecond
+		})[0]
+
+		// n9 is not registered in the network, so it is silent
+		Expect(b.AddPeer("n9")).To(Succeed())
+		Expect(b.AddMessage(context.Background(), "first", "ordered")).To(Succeed())
+
+		Consistently(deliveredFn("n0"), 100*time.Millisecond).Should(BeEmpty())
+		Expect(b.Pending()).To(HaveLen(1))
+
+		Eventually(deliveredFn("n0")).Should(Equal([]any{"first"}))
+		Expect(b.Pending()).To(BeEmpty())
+		Expect(b.peerBuffer.GetPeers()).To(ContainElement("n9"))
+	})
+
+	It("returns error when the silence timeout is negative", func() {
+		_, err := New(&Config{
+			Host:           &fakeHost{},
+			BufferSize:     25,
+			SilenceTimeout: -time.Second,
+		})
+		Expect(err).To(MatchError(errInvalidSilence))
+	})
+})
+
+func indexOf(a []any, x any) int {
+	for i, v := range a {
+		if v == x {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"maps"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/buffer"
+)
+
+// Names of the spans started by the protocol.
+const (
+	// SpanAddMessage is the span of a message added on the origin host.
+	SpanAddMessage = "bmmc.add"
+	// SpanSolicitation is the span of an element sent as reply to a solicitation message.
+	SpanSolicitation = "bmmc.solicitation"
+	// SpanSynchronization is the span of an element received in a synchronization message.
+	SpanSynchronization = "bmmc.synchronization"
+	// SpanCallback is the span of the callback run for an element.
+	SpanCallback = "bmmc.callback"
+)
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute of the span.
+	SetAttribute(key, value string)
+	// RecordError records the given error in the span.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// Tracer starts spans and propagates the span context through message headers.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span as child of the span from the given context.
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Inject writes the span context from the given context into headers.
+	Inject(ctx context.Context, headers map[string]string)
+	// Extract returns a context with the span context from headers.
+	Extract(ctx context.Context, headers map[string]string) context.Context
+}
+
+// noopTracer doesn't trace anything.
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopTracer) Inject(context.Context, map[string]string) {}
+
+func (noopTracer) Extract(ctx context.Context, _ map[string]string) context.Context {
+	return ctx
+}
+
+// noopSpan is the span of noopTracer.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, string) {}
+
+func (noopSpan) RecordError(error) {}
+
+func (noopSpan) End() {}
+
+// traceHeaders returns the headers with the span context from the given context,
+// or nil if there is no span context.
+func (b *BMMC) traceHeaders(ctx context.Context) map[string]string {
+	headers := map[string]string{}
+
+	b.config.Tracer.Inject(ctx, headers)
+
+	if len(headers) == 0 {
+		return nil
+	}
+
+	return headers
+}
+
+// elementHeaders returns the given headers with the span context from the
+// given context, or nil if there are no headers.
+func (b *BMMC) elementHeaders(ctx context.Context, headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return b.traceHeaders(ctx)
+	}
+
+	headers = maps.Clone(headers)
+	b.config.Tracer.Inject(ctx, headers)
+
+	return headers
+}
+
+// startElementSpan starts a span for the given element.
+func (b *BMMC) startElementSpan(ctx context.Context, name string, el buffer.Element) (context.Context, Span) {
+	ctx, span := b.config.Tracer.Start(ctx, name)
+
+	span.SetAttribute("bmmc.host", b.config.Host.String())
+	span.SetAttribute("bmmc.element.id", el.ID)
+	span.SetAttribute("bmmc.element.origin", el.Origin)
+	span.SetAttribute("bmmc.element.callback_type", el.CallbackType)
+
+	return ctx, span
+}
+
+// traceSolicitation records a span for each element sent to the given peer
+// as reply to a solicitation message, as child of the span context from the
+// element headers.
+func (b *BMMC) traceSolicitation(ctx context.Context, elements []buffer.Element, p string) {
+	for _, el := range elements {
+		_, span := b.startElementSpan(b.config.Tracer.Extract(ctx, el.Headers), SpanSolicitation, el)
+		span.SetAttribute("bmmc.peer", p)
+		span.End()
+	}
+}
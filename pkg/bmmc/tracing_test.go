@@ -0,0 +1,211 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmmc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSpanKey struct{}
+
+// fakeSpanContext identifies a span of fakeTracer.
+type fakeSpanContext struct {
+	traceID string
+	spanID  string
+}
+
+// fakeSpan is a span recorded by fakeTracer.
+type fakeSpan struct {
+	name       string
+	parent     fakeSpanContext
+	sc         fakeSpanContext
+	attributes map[string]string
+	err        error
+	ended      bool
+	mux        *sync.Mutex
+}
+
+func (s *fakeSpan) SetAttribute(key, value string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.attributes[key] = value
+}
+
+func (s *fakeSpan) RecordError(err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.err = err
+}
+
+func (s *fakeSpan) End() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.ended = true
+}
+
+// fakeTracer records the started spans and propagates the span context
+// in the `trace` header.
+type fakeTracer struct {
+	mux   *sync.Mutex
+	spans []*fakeSpan
+}
+
+func newFakeTracer() *fakeTracer {
+	return &fakeTracer{mux: &sync.Mutex{}}
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	parent, _ := ctx.Value(fakeSpanKey{}).(fakeSpanContext)
+
+	sc := fakeSpanContext{traceID: parent.traceID, spanID: fmt.Sprintf("span-%d", len(t.spans))}
+	if sc.traceID == "" {
+		sc.traceID = "trace-" + sc.spanID
+	}
+
+	span := &fakeSpan{name: name, parent: parent, sc: sc, attributes: map[string]string{}, mux: t.mux}
+	t.spans = append(t.spans, span)
+
+	return context.WithValue(ctx, fakeSpanKey{}, sc), span
+}
+
+func (t *fakeTracer) Inject(ctx context.Context, headers map[string]string) {
+	if sc, ok := ctx.Value(fakeSpanKey{}).(fakeSpanContext); ok {
+		headers["trace"] = sc.traceID + "/" + sc.spanID
+	}
+}
+
+func (t *fakeTracer) Extract(ctx context.Context, headers map[string]string) context.Context {
+	traceID, spanID, ok := strings.Cut(headers["trace"], "/")
+	if !ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, fakeSpanKey{}, fakeSpanContext{traceID: traceID, spanID: spanID})
+}
+
+// ended returns the ended spans with the given name.
+func (t *fakeTracer) ended(name string) []fakeSpan {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	spans := []fakeSpan{}
+
+	for _, s := range t.spans {
+		if s.name == name && s.ended {
+			spans = append(spans, *s)
+		}
+	}
+
+	return spans
+}
+
+var _ = Describe("Tracing", func() {
+	It("propagates the span context through synchronization messages", func() {
+		tracer := newFakeTracer()
+
+		nodes := newTestCluster(3, func(cfg *Config) {
+			cfg.Tracer = tracer
+			cfg.Callbacks = map[string]func(any, *slog.Logger) error{
+				"cb": func(any, *slog.Logger) error { return nil },
+			}
+		})
+
+		ctx, root := tracer.Start(context.Background(), "request")
+		root.End()
+
+		Expect(nodes[0].AddMessage(ctx, "message", "cb")).To(Succeed())
+
+		for _, b := range nodes {
+			Eventually(b.GetMessages).Should(ConsistOf("message"))
+		}
+
+		add := tracer.ended(SpanAddMessage)
+		Expect(add).To(HaveLen(1))
+		Expect(add[0].parent.spanID).To(Equal("span-0"))
+		Expect(add[0].attributes).To(HaveKeyWithValue("bmmc.host", "n0"))
+
+		hosts := func(name string) func() []string {
+			return func() []string {
+				h := []string{}
+
+				for _, span := range tracer.ended(name) {
+					h = append(h, span.attributes["bmmc.host"])
+				}
+
+				return h
+			}
+		}
+
+		Eventually(hosts(SpanSynchronization)).Should(ContainElements("n1", "n2"))
+		Eventually(hosts(SpanCallback)).Should(ContainElements("n0", "n1", "n2"))
+
+		receivers := map[string]string{}
+
+		for _, span := range tracer.ended(SpanSynchronization) {
+			Expect(span.parent).To(Equal(add[0].sc))
+			Expect(span.attributes).To(HaveKeyWithValue("bmmc.element.origin", "n0"))
+
+			receivers[span.sc.spanID] = span.attributes["bmmc.host"]
+		}
+
+		for _, span := range tracer.ended(SpanCallback) {
+			Expect(span.sc.traceID).To(Equal(add[0].sc.traceID))
+
+			if span.attributes["bmmc.host"] == "n0" {
+				Expect(span.parent).To(Equal(add[0].sc))
+			} else {
+				Expect(receivers).To(HaveKeyWithValue(span.parent.spanID, span.attributes["bmmc.host"]))
+			}
+		}
+
+		for _, span := range tracer.ended(SpanSolicitation) {
+			Expect(span.parent).To(Equal(add[0].sc))
+		}
+	})
+
+	It("ignores messages that were already added", func() {
+		tracer := newFakeTracer()
+
+		b, err := New(&Config{
+			Host:       &fakeHost{},
+			BufferSize: 25,
+			Tracer:     tracer,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(b.AddMessage(context.Background(), "message", NOCALLBACK)).To(Succeed())
+		Expect(b.AddMessage(context.Background(), "message", NOCALLBACK)).To(Succeed())
+		Expect(b.GetMessages()).To(ConsistOf("message"))
+
+		for _, span := range tracer.ended(SpanAddMessage) {
+			Expect(span.err).ToNot(HaveOccurred())
+		}
+	})
+})
@@ -0,0 +1,217 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crdt
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+)
+
+const (
+	gCounterType  = "g-counter"
+	pnCounterType = "pn-counter"
+)
+
+// GCounter is a grow-only counter. Each host counts its increments, and the
+// value of the counter is the sum of the counts of all hosts.
+type GCounter struct {
+	replica *Replica
+	name    string
+	// pub serializes the increments, so the deltas are published in order
+	pub    *sync.Mutex
+	mux    *sync.RWMutex
+	counts map[string]uint64
+}
+
+func newGCounter(r *Replica, name string) *GCounter {
+	return &GCounter{
+		replica: r,
+		name:    name,
+		pub:     &sync.Mutex{},
+		mux:     &sync.RWMutex{},
+		counts:  map[string]uint64{},
+	}
+}
+
+func (*GCounter) typeName() string {
+	return gCounterType
+}
+
+// Increment increments the counter with the given value.
+func (c *GCounter) Increment(ctx context.Context, n uint64) error {
+	c.pub.Lock()
+	defer c.pub.Unlock()
+
+	host := c.replica.host.String()
+
+	c.mux.RLock()
+	delta := map[string]uint64{host: c.counts[host] + n}
+	c.mux.RUnlock()
+
+	// the delta contains the count of the host, so it replaces the older deltas of the host
+	return c.replica.publish(ctx, c, c.name, host, delta)
+}
+
+// Value returns the value of the counter.
+func (c *GCounter) Value() uint64 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	var value uint64
+
+	for _, count := range c.counts {
+		value += count
+	}
+
+	return value
+}
+
+func (c *GCounter) merge(d bmmc.Delivery, delta json.RawMessage) error {
+	var counts map[string]uint64
+	if err := json.Unmarshal(delta, &counts); err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	if err := checkOrigin(d, counts); err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for host, count := range counts {
+		c.counts[host] = max(c.counts[host], count)
+	}
+
+	return nil
+}
+
+// pnCount contains the increments and the decrements of a host.
+type pnCount struct {
+	P uint64 `json:"p,omitempty"`
+	N uint64 `json:"n,omitempty"`
+}
+
+// PNCounter is a counter which can be incremented and decremented. Each host
+// counts its increments and its decrements, and the value of the counter is
+// the difference between the sums of the increments and of the decrements.
+type PNCounter struct {
+	replica *Replica
+	name    string
+	// pub serializes the updates, so the deltas are published in order
+	pub    *sync.Mutex
+	mux    *sync.RWMutex
+	counts map[string]pnCount
+}
+
+func newPNCounter(r *Replica, name string) *PNCounter {
+	return &PNCounter{
+		replica: r,
+		name:    name,
+		pub:     &sync.Mutex{},
+		mux:     &sync.RWMutex{},
+		counts:  map[string]pnCount{},
+	}
+}
+
+func (*PNCounter) typeName() string {
+	return pnCounterType
+}
+
+// Increment adds the given value, which can be negative, to the counter.
+func (c *PNCounter) Increment(ctx context.Context, n int64) error {
+	if n >= 0 {
+		return c.update(ctx, uint64(n), 0)
+	}
+
+	return c.update(ctx, 0, magnitude(n))
+}
+
+// Decrement subtracts the given value, which can be negative, from the counter.
+func (c *PNCounter) Decrement(ctx context.Context, n int64) error {
+	if n >= 0 {
+		return c.update(ctx, 0, uint64(n))
+	}
+
+	return c.update(ctx, magnitude(n), 0)
+}
+
+// update adds the given increments and decrements to the counts of the host.
+func (c *PNCounter) update(ctx context.Context, p, n uint64) error {
+	c.pub.Lock()
+	defer c.pub.Unlock()
+
+	host := c.replica.host.String()
+
+	c.mux.RLock()
+	count := c.counts[host]
+	c.mux.RUnlock()
+
+	count.P += p
+	count.N += n
+
+	// the delta contains the counts of the host, so it replaces the older deltas of the host
+	return c.replica.publish(ctx, c, c.name, host, map[string]pnCount{host: count})
+}
+
+// magnitude returns the absolute value of the given negative value,
+// without overflowing for math.MinInt64.
+func magnitude(n int64) uint64 {
+	return uint64(-(n + 1)) + 1
+}
+
+// Value returns the value of the counter.
+func (c *PNCounter) Value() int64 {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	var value int64
+
+	for _, count := range c.counts {
+		value += int64(count.P) - int64(count.N)
+	}
+
+	return value
+}
+
+func (c *PNCounter) merge(d bmmc.Delivery, delta json.RawMessage) error {
+	var counts map[string]pnCount
+	if err := json.Unmarshal(delta, &counts); err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	if err := checkOrigin(d, counts); err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for host, count := range counts {
+		current := c.counts[host]
+
+		c.counts[host] = pnCount{
+			P: max(current.P, count.P),
+			N: max(current.N, count.N),
+		}
+	}
+
+	return nil
+}
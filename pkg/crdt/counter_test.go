@@ -0,0 +1,114 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crdt
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+var _ = Describe("Counters", func() {
+	It("converges the grow-only counter with concurrent increments", func() {
+		replicas := newTestCluster(3)
+
+		var wg sync.WaitGroup
+
+		for _, r := range replicas {
+			counter, err := r.GCounter("visits")
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for i := 0; i < 20; i++ {
+					Expect(counter.Increment(context.Background(), 2)).To(Succeed())
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		for _, r := range replicas {
+			counter, err := r.GCounter("visits")
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(counter.Value).Should(Equal(uint64(120)))
+		}
+	})
+
+	It("converges the counter with increments and decrements", func() {
+		replicas := newTestCluster(3)
+
+		for i, r := range replicas {
+			counter, err := r.PNCounter("balance")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(counter.Increment(context.Background(), 10)).To(Succeed())
+			Expect(counter.Decrement(context.Background(), int64(i))).To(Succeed())
+			Expect(counter.Increment(context.Background(), -1)).To(Succeed())
+		}
+
+		for _, r := range replicas {
+			counter, err := r.PNCounter("balance")
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(counter.Value).Should(Equal(int64(24)))
+		}
+	})
+
+	It("doesn't overflow for the minimum value", func() {
+		replicas := newTestCluster(1)
+
+		counter, err := replicas[0].PNCounter("balance")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(counter.Increment(context.Background(), math.MinInt64)).To(Succeed())
+		Expect(counter.counts["n0"]).To(Equal(pnCount{N: 1 << 63}))
+		Expect(counter.Value()).To(Equal(int64(math.MinInt64)))
+
+		Expect(counter.Decrement(context.Background(), math.MinInt64)).To(Succeed())
+		Expect(counter.counts["n0"]).To(Equal(pnCount{P: 1 << 63, N: 1 << 63}))
+		Expect(counter.Value()).To(BeZero())
+	})
+
+	It("merges the counts idempotently", func() {
+		counter := newGCounter(&Replica{host: memory.NewNetwork().Peer("n0")}, "visits")
+
+		Expect(counter.merge(deliveryFrom("n1"), []byte(`{"n1":3}`))).To(Succeed())
+		Expect(counter.merge(deliveryFrom("n2"), []byte(`{"n2":1}`))).To(Succeed())
+		Expect(counter.merge(deliveryFrom("n1"), []byte(`{"n1":2}`))).To(Succeed())
+		Expect(counter.merge(deliveryFrom("n1"), []byte(`{"n1":3}`))).To(Succeed())
+		Expect(counter.Value()).To(Equal(uint64(4)))
+	})
+
+	It("rejects the counts of other hosts than the origin", func() {
+		gCounter := newGCounter(&Replica{host: memory.NewNetwork().Peer("n0")}, "visits")
+		Expect(gCounter.merge(deliveryFrom("n1"), []byte(`{"n1":3,"n2":100}`))).To(MatchError(errForeignDelta))
+		Expect(gCounter.Value()).To(BeZero())
+
+		pnCounter := newPNCounter(&Replica{host: memory.NewNetwork().Peer("n0")}, "balance")
+		Expect(pnCounter.merge(deliveryFrom("n1"), []byte(`{"n0":{"n":100}}`))).To(MatchError(errForeignDelta))
+		Expect(pnCounter.Value()).To(BeZero())
+	})
+})
@@ -0,0 +1,268 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crdt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+)
+
+// CallbackType is the callback type of the messages used by the replica.
+const CallbackType = "crdt"
+
+const (
+	createReplicaErrFmt  = "error at creating the replica: %w"
+	objectErrFmt         = "error at getting the %s %q: %w"
+	typeMismatchErrFmt   = "%w: it is a %s"
+	updateErrFmt         = "error at updating the %s %q: %w"
+	unknownTypeErrFmt    = "%w: %s"
+	invalidMessageErrFmt = "%w: %w"
+	foreignDeltaErrFmt   = "%w: %q in the delta of %q"
+)
+
+var (
+	errNilBMMCConfig           = errors.New("bmmc config must not be nil")
+	errReservedCallback        = errors.New("callback type " + CallbackType + " is reserved for the replica")
+	errEmptyName               = errors.New("name must not be empty")
+	errTypeMismatch            = errors.New("type mismatch")
+	errUnknownType             = errors.New("unknown type")
+	errCannotConvertToDelivery = errors.New("cannot convert the given data to delivery")
+	errInvalidMessage          = errors.New("invalid replica message")
+	errForeignDelta            = errors.New("delta contains the updates of another host")
+)
+
+// object is a CRDT of a replica.
+type object interface {
+	// typeName returns the type of the CRDT.
+	typeName() string
+	// merge merges the given delta, delivered by the protocol, in the CRDT.
+	merge(d bmmc.Delivery, delta json.RawMessage) error
+}
+
+// newObjects are the constructors of the CRDTs, by type.
+var newObjects = map[string]func(r *Replica, name string) object{ //nolint: gochecknoglobals
+	gCounterType:    func(r *Replica, name string) object { return newGCounter(r, name) },
+	pnCounterType:   func(r *Replica, name string) object { return newPNCounter(r, name) },
+	gSetType:        func(r *Replica, name string) object { return newGSet(r, name) },
+	orSetType:       func(r *Replica, name string) object { return newORSet(r, name) },
+	lwwRegisterType: func(r *Replica, name string) object { return newLWWRegister(r, name) },
+}
+
+// Config is the config of the replica.
+type Config struct {
+	// BMMC is the config of the bimodal multicast instance used to disseminate
+	// the deltas. The replica adds its callback in a copy of the callbacks.
+	// Required.
+	BMMC *bmmc.Config
+}
+
+// message is the message disseminated for a delta of a CRDT.
+type message struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Delta json.RawMessage `json:"delta"`
+}
+
+// Replica contains CRDTs replicated with the bimodal multicast protocol.
+// The mutations of a CRDT are disseminated as deltas, which are merged by the
+// callbacks of all replicas. The CRDTs are identified by name and are created
+// when they are first used or when their first delta is received.
+type Replica struct {
+	bmmc *bmmc.BMMC
+	// host is read when it is needed, since its name can be set after the replica is created
+	host    fmt.Stringer
+	mux     *sync.Mutex
+	objects map[string]object
+}
+
+// New creates a replica and its bimodal multicast instance.
+// The instance must be started with BMMC().Start().
+func New(cfg Config) (*Replica, error) {
+	if cfg.BMMC == nil {
+		return nil, fmt.Errorf(createReplicaErrFmt, errNilBMMCConfig)
+	}
+
+	if _, ok := cfg.BMMC.Callbacks[CallbackType]; ok {
+		return nil, fmt.Errorf(createReplicaErrFmt, errReservedCallback)
+	}
+
+	r := &Replica{
+		mux:     &sync.Mutex{},
+		objects: map[string]object{},
+	}
+
+	bmmcCfg := *cfg.BMMC
+	bmmcCfg.Callbacks = maps.Clone(cfg.BMMC.Callbacks)
+
+	if bmmcCfg.Callbacks == nil {
+		bmmcCfg.Callbacks = map[string]func(any, *slog.Logger) error{}
+	}
+
+	bmmcCfg.Callbacks[CallbackType] = r.callback
+
+	b, err := bmmc.New(&bmmcCfg)
+	if err != nil {
+		return nil, fmt.Errorf(createReplicaErrFmt, err)
+	}
+
+	r.bmmc = b
+	r.host = bmmcCfg.Host
+
+	return r, nil
+}
+
+// BMMC returns the bimodal multicast instance of the replica.
+func (r *Replica) BMMC() *bmmc.BMMC {
+	return r.bmmc
+}
+
+// GCounter returns the grow-only counter with given name.
+func (r *Replica) GCounter(name string) (*GCounter, error) {
+	return getObject[*GCounter](r, name, gCounterType)
+}
+
+// PNCounter returns the counter with given name.
+func (r *Replica) PNCounter(name string) (*PNCounter, error) {
+	return getObject[*PNCounter](r, name, pnCounterType)
+}
+
+// GSet returns the grow-only set with given name.
+func (r *Replica) GSet(name string) (*GSet, error) {
+	return getObject[*GSet](r, name, gSetType)
+}
+
+// ORSet returns the observed-remove set with given name.
+func (r *Replica) ORSet(name string) (*ORSet, error) {
+	return getObject[*ORSet](r, name, orSetType)
+}
+
+// LWWRegister returns the last-writer-wins register with given name.
+func (r *Replica) LWWRegister(name string) (*LWWRegister, error) {
+	return getObject[*LWWRegister](r, name, lwwRegisterType)
+}
+
+// getObject returns the CRDT with given name and type, and creates it if it doesn't exist.
+func getObject[T object](r *Replica, name, typ string) (T, error) {
+	var zero T
+
+	obj, err := r.object(name, typ)
+	if err != nil {
+		return zero, fmt.Errorf(objectErrFmt, typ, name, err)
+	}
+
+	return obj.(T), nil //nolint: forcetypeassert
+}
+
+// object returns the CRDT with given name and type, and creates it if it doesn't exist.
+func (r *Replica) object(name, typ string) (object, error) {
+	if name == "" {
+		return nil, errEmptyName
+	}
+
+	newObject, ok := newObjects[typ]
+	if !ok {
+		return nil, fmt.Errorf(unknownTypeErrFmt, errUnknownType, typ)
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	obj, ok := r.objects[name]
+	if !ok {
+		obj = newObject(r, name)
+		r.objects[name] = obj
+	}
+
+	if obj.typeName() != typ {
+		return nil, fmt.Errorf(typeMismatchErrFmt, errTypeMismatch, obj.typeName())
+	}
+
+	return obj, nil
+}
+
+// publish disseminates the delta of the given CRDT. The delta is merged in
+// the CRDT by the callback, before publish returns. Deltas with a key replace
+// the older deltas with the same key in the buffers.
+func (r *Replica) publish(ctx context.Context, obj object, name, key string, delta any) error {
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf(updateErrFmt, obj.typeName(), name, err)
+	}
+
+	msg, err := json.Marshal(message{Name: name, Type: obj.typeName(), Delta: data})
+	if err != nil {
+		return fmt.Errorf(updateErrFmt, obj.typeName(), name, err)
+	}
+
+	// the message is sent as string, so it is the same on all hosts
+	if key == "" {
+		err = r.bmmc.AddMessage(ctx, string(msg), CallbackType)
+	} else {
+		err = r.bmmc.AddKeyedMessage(ctx, CallbackType+"/"+name+"/"+key, string(msg), CallbackType)
+	}
+
+	if err != nil {
+		return fmt.Errorf(updateErrFmt, obj.typeName(), name, err)
+	}
+
+	return nil
+}
+
+// callback merges the delivered delta in its CRDT.
+func (r *Replica) callback(data any, _ *slog.Logger) error {
+	delivery, ok := data.(bmmc.Delivery)
+	if !ok {
+		return errCannotConvertToDelivery
+	}
+
+	raw, ok := delivery.Msg.(string)
+	if !ok {
+		return errInvalidMessage
+	}
+
+	var msg message
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		return fmt.Errorf(invalidMessageErrFmt, errInvalidMessage, err)
+	}
+
+	obj, err := r.object(msg.Name, msg.Type)
+	if err != nil {
+		return fmt.Errorf(objectErrFmt, msg.Type, msg.Name, err)
+	}
+
+	return obj.merge(delivery, msg.Delta)
+}
+
+// checkOrigin returns an error if the given deltas, indexed by host, contain
+// the updates of other hosts than the origin of the delivery, since each host
+// publishes only its own updates.
+func checkOrigin[T any](d bmmc.Delivery, deltas map[string]T) error {
+	for host := range deltas {
+		if host != d.Origin {
+			return fmt.Errorf(foreignDeltaErrFmt, errForeignDelta, host, d.Origin)
+		}
+	}
+
+	return nil
+}
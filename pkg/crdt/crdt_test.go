@@ -0,0 +1,118 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crdt
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+// newTestCluster creates a full mesh of started replicas, named "n0", "n1", etc.
+func newTestCluster(size int) []*Replica {
+	network := memory.NewNetwork()
+
+	replicas, stop, err := memory.FullMesh(network, size, func(name string) (*Replica, memory.MeshNode, error) {
+		r, err := New(Config{
+			BMMC: &bmmc.Config{
+				Host:          network.Peer(name),
+				BufferSize:    64,
+				RoundDuration: 10 * time.Millisecond,
+				Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+			},
+		})
+		if err != nil {
+			return nil, memory.MeshNode{}, err
+		}
+
+		return r, memory.MeshNode{Handler: r.BMMC().Handle, Member: r.BMMC()}, nil
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	DeferCleanup(stop)
+
+	return replicas
+}
+
+var _ = Describe("Replica", func() {
+	It("creates the CRDTs of the received deltas", func() {
+		replicas := newTestCluster(2)
+
+		counter, err := replicas[0].GCounter("visits")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(counter.Increment(context.Background(), 1)).To(Succeed())
+
+		Eventually(func() bool {
+			replicas[1].mux.Lock()
+			defer replicas[1].mux.Unlock()
+
+			_, ok := replicas[1].objects["visits"]
+
+			return ok
+		}).Should(BeTrue())
+
+		_, err = replicas[1].ORSet("visits")
+		Expect(err).To(MatchError(errTypeMismatch))
+
+		received, err := replicas[1].GCounter("visits")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(received.Value()).To(Equal(uint64(1)))
+	})
+
+	It("returns the same CRDT for the same name", func() {
+		replicas := newTestCluster(1)
+
+		first, err := replicas[0].GSet("users")
+		Expect(err).ToNot(HaveOccurred())
+
+		second, err := replicas[0].GSet("users")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+	})
+
+	It("returns error when the name is empty", func() {
+		replicas := newTestCluster(1)
+
+		_, err := replicas[0].PNCounter("")
+		Expect(err).To(MatchError(errEmptyName))
+	})
+
+	It("returns error when the callback type is reserved", func() {
+		_, err := New(Config{
+			BMMC: &bmmc.Config{
+				Host:       memory.NewNetwork().Peer("n0"),
+				BufferSize: 25,
+				Callbacks: map[string]func(any, *slog.Logger) error{
+					CallbackType: func(any, *slog.Logger) error { return nil },
+				},
+			},
+		})
+		Expect(err).To(MatchError(errReservedCallback))
+	})
+
+	It("returns error when the bmmc config is missing", func() {
+		_, err := New(Config{})
+		Expect(err).To(MatchError(errNilBMMCConfig))
+	})
+})
@@ -0,0 +1,100 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crdt
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+)
+
+const lwwRegisterType = "lww-register"
+
+// LWWRegister is a last-writer-wins register. The writes are ordered like
+// the keyed messages of the protocol: by time, then by origin and message ID.
+type LWWRegister struct {
+	replica *Replica
+	name    string
+	mux     *sync.RWMutex
+	value   []byte
+	set     bool
+	// version of the value
+	timestamp time.Time
+	origin    string
+	id        string
+}
+
+func newLWWRegister(r *Replica, name string) *LWWRegister {
+	return &LWWRegister{
+		replica: r,
+		name:    name,
+		mux:     &sync.RWMutex{},
+	}
+}
+
+func (*LWWRegister) typeName() string {
+	return lwwRegisterType
+}
+
+// Set sets the value of the register.
+func (r *LWWRegister) Set(ctx context.Context, value []byte) error {
+	// the newer value replaces the older ones in the buffers
+	return r.replica.publish(ctx, r, r.name, "value", value)
+}
+
+// Get returns the value of the register and true if the value was set.
+func (r *LWWRegister) Get() ([]byte, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return r.value, r.set
+}
+
+func (r *LWWRegister) merge(d bmmc.Delivery, delta json.RawMessage) error {
+	var value []byte
+	if err := json.Unmarshal(delta, &value); err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.set && !newerVersion(d, r.timestamp, r.origin, r.id) {
+		return nil
+	}
+
+	r.value, r.set = value, true
+	r.timestamp, r.origin, r.id = d.OriginTime, d.Origin, d.ID
+
+	return nil
+}
+
+// newerVersion returns true if the given delivery is newer than the given version.
+func newerVersion(d bmmc.Delivery, timestamp time.Time, origin, id string) bool {
+	if !d.OriginTime.Equal(timestamp) {
+		return d.OriginTime.After(timestamp)
+	}
+
+	if d.Origin != origin {
+		return d.Origin > origin
+	}
+
+	return d.ID > id
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crdt
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+// deliveryOf returns the delivery of a message originated at the given time, if any.
+func deliveryOf(at ...time.Time) bmmc.Delivery {
+	d := bmmc.Delivery{Origin: "n1", OriginTime: time.Now()}
+
+	if len(at) > 0 {
+		d.OriginTime = at[0]
+	}
+
+	return d
+}
+
+// deliveryFrom returns the delivery of a message originated by the given host.
+func deliveryFrom(origin string) bmmc.Delivery {
+	return bmmc.Delivery{Origin: origin, OriginTime: time.Now()}
+}
+
+var _ = Describe("LWWRegister", func() {
+	It("replicates the last written value", func() {
+		replicas := newTestCluster(3)
+
+		register, err := replicas[0].LWWRegister("leader")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, ok := register.Get()
+		Expect(ok).To(BeFalse())
+
+		Expect(register.Set(context.Background(), []byte("n0"))).To(Succeed())
+
+		other, err := replicas[2].LWWRegister("leader")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other.Set(context.Background(), []byte("n2"))).To(Succeed())
+
+		for _, r := range replicas {
+			register, err := r.LWWRegister("leader")
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(func() string {
+				value, _ := register.Get()
+
+				return string(value)
+			}).Should(Equal("n2"))
+		}
+	})
+
+	It("ignores the older values", func() {
+		register := newLWWRegister(&Replica{host: memory.NewNetwork().Peer("n0")}, "leader")
+
+		now := time.Now()
+
+		Expect(register.merge(deliveryOf(now), []byte(`"bmV3"`))).To(Succeed())
+		Expect(register.merge(deliveryOf(now.Add(-time.Second)), []byte(`"b2xk"`))).To(Succeed())
+
+		value, ok := register.Get()
+		Expect(ok).To(BeTrue())
+		Expect(string(value)).To(Equal("new"))
+	})
+})
@@ -0,0 +1,345 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crdt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+)
+
+const (
+	gSetType  = "g-set"
+	orSetType = "or-set"
+)
+
+// GSet is a grow-only set of strings. Each host publishes all the elements
+// it added, so a lost delta is recovered from the next deltas of the host.
+// The deltas grow with the elements added by the host, and the deltas larger
+// than the Limits.MaxElementBytes of the peers are rejected by them.
+type GSet struct {
+	replica *Replica
+	name    string
+	// pub serializes the additions, so the deltas are published in order
+	pub      *sync.Mutex
+	mux      *sync.RWMutex
+	elements map[string]struct{}
+	// added are the elements added by each host
+	added map[string]map[string]struct{}
+}
+
+func newGSet(r *Replica, name string) *GSet {
+	return &GSet{
+		replica:  r,
+		name:     name,
+		pub:      &sync.Mutex{},
+		mux:      &sync.RWMutex{},
+		elements: map[string]struct{}{},
+		added:    map[string]map[string]struct{}{},
+	}
+}
+
+func (*GSet) typeName() string {
+	return gSetType
+}
+
+// Add adds the given elements in the set.
+func (s *GSet) Add(ctx context.Context, elements ...string) error {
+	if len(elements) == 0 {
+		return nil
+	}
+
+	s.pub.Lock()
+	defer s.pub.Unlock()
+
+	host := s.replica.host.String()
+
+	s.mux.RLock()
+	added := maps.Clone(s.added[host])
+	s.mux.RUnlock()
+
+	if added == nil {
+		added = map[string]struct{}{}
+	}
+
+	for _, element := range elements {
+		added[element] = struct{}{}
+	}
+
+	// the delta contains the elements added by the host, so it replaces the older deltas of the host
+	return s.replica.publish(ctx, s, s.name, host, map[string][]string{host: sortedKeys(added)})
+}
+
+// Contains returns true if the set contains the given element.
+func (s *GSet) Contains(element string) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	_, ok := s.elements[element]
+
+	return ok
+}
+
+// Elements returns the sorted elements of the set.
+func (s *GSet) Elements() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return sortedKeys(s.elements)
+}
+
+func (s *GSet) merge(d bmmc.Delivery, delta json.RawMessage) error {
+	var added map[string][]string
+	if err := json.Unmarshal(delta, &added); err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	if err := checkOrigin(d, added); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for host, elements := range added {
+		if s.added[host] == nil {
+			s.added[host] = map[string]struct{}{}
+		}
+
+		for _, element := range elements {
+			s.added[host][element] = struct{}{}
+			s.elements[element] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// orSetDelta contains the additions and the removals of a host in an observed-remove set.
+type orSetDelta struct {
+	// Adds are the added elements, with their unique tags
+	Adds map[string][]string `json:"adds,omitempty"`
+	// Removes are the observed tags of the removed elements
+	Removes []string `json:"removes,omitempty"`
+}
+
+// orSetUpdates are the additions and the removals of a host in an observed-remove set.
+type orSetUpdates struct {
+	adds    map[string]map[string]struct{}
+	removes map[string]struct{}
+}
+
+// ORSet is an observed-remove set of strings. Each addition of an element has
+// an unique tag, and a removal removes only the tags observed by the host, so
+// a concurrent addition of the same element wins. Each host publishes all its
+// additions and removals, so a lost delta is recovered from the next deltas of
+// the host. The deltas grow with the updates of the host, and the deltas larger
+// than the Limits.MaxElementBytes of the peers are rejected by them.
+type ORSet struct {
+	replica *Replica
+	name    string
+	// pub serializes the updates, so a removal observes all previous additions of the host
+	pub  *sync.Mutex
+	mux  *sync.RWMutex
+	adds map[string]map[string]struct{}
+	// removed are the tags of the removed elements
+	removed map[string]struct{}
+	// updates are the additions and the removals of each host
+	updates map[string]orSetUpdates
+	// nextTag is used for unique tags
+	nextTag *atomic.Uint64
+}
+
+func newORSet(r *Replica, name string) *ORSet {
+	return &ORSet{
+		replica: r,
+		name:    name,
+		pub:     &sync.Mutex{},
+		mux:     &sync.RWMutex{},
+		adds:    map[string]map[string]struct{}{},
+		removed: map[string]struct{}{},
+		updates: map[string]orSetUpdates{},
+		nextTag: &atomic.Uint64{},
+	}
+}
+
+func (*ORSet) typeName() string {
+	return orSetType
+}
+
+// Add adds the given element in the set.
+func (s *ORSet) Add(ctx context.Context, element string) error {
+	s.pub.Lock()
+	defer s.pub.Unlock()
+
+	host := s.replica.host.String()
+
+	// the time makes the tags unique after the host is restarted
+	tag := fmt.Sprintf("%s/%d/%d", host, time.Now().UnixNano(), s.nextTag.Add(1))
+
+	s.mux.RLock()
+	delta := s.hostDelta(host)
+	s.mux.RUnlock()
+
+	delta.Adds[element] = append(delta.Adds[element], tag)
+
+	// the delta contains the updates of the host, so it replaces the older deltas of the host
+	return s.replica.publish(ctx, s, s.name, host, map[string]orSetDelta{host: delta})
+}
+
+// Remove removes the given element from the set.
+// The additions of the element not received yet by the host are not removed.
+func (s *ORSet) Remove(ctx context.Context, element string) error {
+	s.pub.Lock()
+	defer s.pub.Unlock()
+
+	host := s.replica.host.String()
+
+	s.mux.RLock()
+	tags := s.liveTags(element)
+	delta := s.hostDelta(host)
+	s.mux.RUnlock()
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	delta.Removes = append(delta.Removes, tags...)
+
+	// the delta contains the updates of the host, so it replaces the older deltas of the host
+	return s.replica.publish(ctx, s, s.name, host, map[string]orSetDelta{host: delta})
+}
+
+// Contains returns true if the set contains the given element.
+func (s *ORSet) Contains(element string) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return len(s.liveTags(element)) > 0
+}
+
+// Elements returns the sorted elements of the set.
+func (s *ORSet) Elements() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	elements := map[string]struct{}{}
+
+	for element := range s.adds {
+		if len(s.liveTags(element)) > 0 {
+			elements[element] = struct{}{}
+		}
+	}
+
+	return sortedKeys(elements)
+}
+
+// liveTags returns the sorted tags of the given element which were not removed.
+func (s *ORSet) liveTags(element string) []string {
+	tags := make([]string, 0, len(s.adds[element]))
+
+	for tag := range s.adds[element] {
+		if _, ok := s.removed[tag]; !ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
+
+// hostDelta returns the delta with all the additions and the removals of the given host.
+func (s *ORSet) hostDelta(host string) orSetDelta {
+	updates := s.updates[host]
+	delta := orSetDelta{Adds: make(map[string][]string, len(updates.adds))}
+
+	for element, tags := range updates.adds {
+		delta.Adds[element] = sortedKeys(tags)
+	}
+
+	delta.Removes = sortedKeys(updates.removes)
+
+	return delta
+}
+
+func (s *ORSet) merge(d bmmc.Delivery, delta json.RawMessage) error {
+	var deltas map[string]orSetDelta
+	if err := json.Unmarshal(delta, &deltas); err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	if err := checkOrigin(d, deltas); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for host, hostDelta := range deltas {
+		updates, ok := s.updates[host]
+		if !ok {
+			updates = orSetUpdates{
+				adds:    map[string]map[string]struct{}{},
+				removes: map[string]struct{}{},
+			}
+			s.updates[host] = updates
+		}
+
+		for element, tags := range hostDelta.Adds {
+			if s.adds[element] == nil {
+				s.adds[element] = map[string]struct{}{}
+			}
+
+			if updates.adds[element] == nil {
+				updates.adds[element] = map[string]struct{}{}
+			}
+
+			for _, tag := range tags {
+				s.adds[element][tag] = struct{}{}
+				updates.adds[element][tag] = struct{}{}
+			}
+		}
+
+		for _, tag := range hostDelta.Removes {
+			s.removed[tag] = struct{}{}
+			updates.removes[tag] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// sortedKeys returns the sorted keys of the given set.
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crdt
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+var _ = Describe("Sets", func() {
+	It("replicates the grow-only set", func() {
+		replicas := newTestCluster(3)
+
+		for i, r := range replicas {
+			set, err := r.GSet("users")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(set.Add(context.Background(), "common", string(rune('a'+i)))).To(Succeed())
+		}
+
+		for _, r := range replicas {
+			set, err := r.GSet("users")
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(set.Elements).Should(Equal([]string{"a", "b", "c", "common"}))
+			Expect(set.Contains("a")).To(BeTrue())
+		}
+	})
+
+	It("replicates the removals of the observed-remove set", func() {
+		replicas := newTestCluster(2)
+
+		set, err := replicas[0].ORSet("users")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(set.Add(context.Background(), "alice")).To(Succeed())
+		Expect(set.Add(context.Background(), "bob")).To(Succeed())
+
+		other, err := replicas[1].ORSet("users")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(other.Elements).Should(Equal([]string{"alice", "bob"}))
+
+		Expect(other.Remove(context.Background(), "alice")).To(Succeed())
+		Expect(other.Contains("alice")).To(BeFalse())
+		Eventually(set.Elements).Should(Equal([]string{"bob"}))
+
+		Expect(set.Add(context.Background(), "alice")).To(Succeed())
+		Eventually(other.Elements).Should(Equal([]string{"alice", "bob"}))
+	})
+
+	It("recovers the lost deltas of the grow-only set from the next deltas", func() {
+		set := newGSet(&Replica{host: memory.NewNetwork().Peer("n0")}, "users")
+
+		// the first delta of n1, with alice, was lost
+		Expect(set.merge(deliveryFrom("n1"), []byte(`{"n1":["alice","bob"]}`))).To(Succeed())
+		Expect(set.merge(deliveryFrom("n2"), []byte(`{"n2":["alice"]}`))).To(Succeed())
+
+		Expect(set.Elements()).To(Equal([]string{"alice", "bob"}))
+	})
+
+	It("keeps the concurrent additions of removed elements", func() {
+		set := newORSet(&Replica{host: memory.NewNetwork().Peer("n0")}, "users")
+
+		Expect(set.merge(deliveryFrom("n0"), []byte(`{"n0":{"adds":{"alice":["n0/1"]}}}`))).To(Succeed())
+		// n1 removes the addition observed from n0, while n2 adds alice again
+		Expect(set.merge(deliveryFrom("n2"), []byte(`{"n2":{"adds":{"alice":["n2/1"]}}}`))).To(Succeed())
+		Expect(set.merge(deliveryFrom("n1"), []byte(`{"n1":{"removes":["n0/1"]}}`))).To(Succeed())
+
+		Expect(set.Elements()).To(Equal([]string{"alice"}))
+
+		Expect(set.merge(deliveryFrom("n1"), []byte(`{"n1":{"removes":["n0/1","n2/1"]}}`))).To(Succeed())
+		Expect(set.Elements()).To(BeEmpty())
+	})
+
+	It("recovers the lost deltas of the observed-remove set from the next deltas", func() {
+		set := newORSet(&Replica{host: memory.NewNetwork().Peer("n0")}, "users")
+
+		// the first delta of n1, which adds alice, was lost
+		Expect(set.merge(deliveryFrom("n1"), []byte(`{"n1":{"adds":{"alice":["n1/1"],"bob":["n1/2"]}}}`))).To(Succeed())
+		Expect(set.Elements()).To(Equal([]string{"alice", "bob"}))
+
+		// the delta of n1 which removes bob was lost
+		Expect(set.merge(deliveryFrom("n1"), []byte(
+			`{"n1":{"adds":{"alice":["n1/1"],"bob":["n1/2"],"carol":["n1/3"]},"removes":["n1/2"]}}`,
+		))).To(Succeed())
+		Expect(set.Elements()).To(Equal([]string{"alice", "carol"}))
+	})
+
+	It("rejects the updates of other hosts than the origin", func() {
+		gSet := newGSet(&Replica{host: memory.NewNetwork().Peer("n0")}, "users")
+		Expect(gSet.merge(deliveryFrom("n1"), []byte(`{"n1":["alice"],"n2":["bob"]}`))).To(MatchError(errForeignDelta))
+		Expect(gSet.Elements()).To(BeEmpty())
+
+		orSet := newORSet(&Replica{host: memory.NewNetwork().Peer("n0")}, "users")
+		Expect(orSet.merge(deliveryFrom("n0"), []byte(`{"n0":{"adds":{"alice":["n0/1"]}}}`))).To(Succeed())
+		Expect(orSet.merge(deliveryFrom("n1"), []byte(`{"n0":{"removes":["n0/1"]}}`))).To(MatchError(errForeignDelta))
+		Expect(orSet.Elements()).To(Equal([]string{"alice"}))
+	})
+
+	It("publishes all the updates of the host", func() {
+		replicas := newTestCluster(1)
+
+		set, err := replicas[0].ORSet("users")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(set.Add(context.Background(), "alice")).To(Succeed())
+		Expect(set.Add(context.Background(), "bob")).To(Succeed())
+		Expect(set.Remove(context.Background(), "alice")).To(Succeed())
+
+		delta := set.hostDelta("n0")
+		Expect(delta.Adds).To(HaveKey("alice"))
+		Expect(delta.Adds).To(HaveKey("bob"))
+		Expect(delta.Removes).To(Equal(delta.Adds["alice"]))
+	})
+})
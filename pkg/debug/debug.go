@@ -0,0 +1,255 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+)
+
+// Paths of the debug pages, relative to the path where the handler is mounted.
+const (
+	StatePath    = "/state"
+	BufferPath   = "/buffer"
+	PeersPath    = "/peers"
+	RoundPath    = "/round"
+	ConfigPath   = "/config"
+	StatsPath    = "/stats"
+	ErrorsPath   = "/errors"
+	OutboundPath = "/outbound"
+	PendingPath  = "/pending"
+	PprofPath    = "/pprof/"
+)
+
+// Element is the JSON view of an element from the messages buffer.
+type Element struct {
+	ID           string    `json:"id"`
+	Origin       string    `json:"origin,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	CallbackType string    `json:"callbackType"`
+	Key          string    `json:"key,omitempty"`
+	Internal     bool      `json:"internal"`
+	Encrypted    bool      `json:"encrypted"`
+	GossipCount  int64     `json:"gossipCount"`
+	Hops         int       `json:"hops"`
+	Stable       bool      `json:"stable"`
+}
+
+// PendingMessage is the JSON view of a received message held back by the delivery order.
+type PendingMessage struct {
+	ID           string            `json:"id"`
+	Origin       string            `json:"origin"`
+	Seq          uint64            `json:"seq"`
+	CallbackType string            `json:"callbackType"`
+	Waiting      map[string]uint64 `json:"waiting"`
+	HeldSince    time.Time         `json:"heldSince"`
+	Lamport      uint64            `json:"lamport,omitempty"`
+	Unstable     []string          `json:"unstable,omitempty"`
+}
+
+// Limits is the JSON view of the limits of received messages.
+type Limits struct {
+	MaxBodyBytes       int `json:"maxBodyBytes"`
+	MaxDigestLen       int `json:"maxDigestLen"`
+	MaxSyncElements    int `json:"maxSyncElements"`
+	MaxElementBytes    int `json:"maxElementBytes"`
+	MaxCallbackTypeLen int `json:"maxCallbackTypeLen"`
+}
+
+// Config is the JSON view of the config, without secrets.
+type Config struct {
+	Beta               float64 `json:"beta"`
+	RoundDuration      string  `json:"roundDuration"`
+	BufferSize         int     `json:"bufferSize"`
+	EvictStable        bool    `json:"evictStable"`
+	Exchange           string  `json:"exchange"`
+	Delivery           string  `json:"delivery"`
+	Limits             Limits  `json:"limits"`
+	SyncRateLimit      int     `json:"syncRateLimit"`
+	SyncBurst          int     `json:"syncBurst"`
+	MaxMessageAge      string  `json:"maxMessageAge"`
+	RequireKnownSender bool    `json:"requireKnownSender"`
+	Signing            bool    `json:"signing"`
+	Authentication     bool    `json:"authentication"`
+	Encryption         bool    `json:"encryption"`
+	MembershipPolicy   bool    `json:"membershipPolicy"`
+}
+
+// Stats is the JSON view of the statistics.
+type Stats struct {
+	RejectedElements    uint64 `json:"rejectedElements"`
+	RejectedSenders     uint64 `json:"rejectedSenders"`
+	RejectedMessages    uint64 `json:"rejectedMessages"`
+	RateLimitedElements uint64 `json:"rateLimitedElements"`
+	SkippedMessages     uint64 `json:"skippedMessages"`
+}
+
+// SendError is the JSON view of a message that could not be sent.
+type SendError struct {
+	Time  time.Time `json:"time"`
+	Peer  string    `json:"peer"`
+	Route string    `json:"route"`
+	Err   string    `json:"err"`
+}
+
+// State is the JSON view of the whole protocol state.
+type State struct {
+	Host       string           `json:"host"`
+	Round      int64            `json:"round"`
+	Elements   []Element        `json:"elements"`
+	Peers      []string         `json:"peers"`
+	Config     Config           `json:"config"`
+	Stats      Stats            `json:"stats"`
+	SendErrors []SendError      `json:"sendErrors"`
+	Pending    []PendingMessage `json:"pending"`
+	Outbound   map[string]int64 `json:"outbound"`
+
+	StableFrontier map[string]uint64 `json:"stableFrontier"`
+}
+
+// NewState returns the JSON view of given inspection.
+func NewState(in bmmc.Inspection) State {
+	elements := make([]Element, len(in.Elements))
+
+	for i, el := range in.Elements {
+		elements[i] = Element{
+			ID:           el.ID,
+			Origin:       el.Origin,
+			Timestamp:    el.Timestamp,
+			CallbackType: el.CallbackType,
+			Key:          el.Key,
+			Internal:     el.Internal,
+			Encrypted:    el.Encrypted,
+			GossipCount:  el.GossipCount,
+			Hops:         el.Hops,
+			Stable:       el.Stable,
+		}
+	}
+
+	sendErrors := make([]SendError, len(in.SendErrors))
+
+	for i, sendErr := range in.SendErrors {
+		sendErrors[i] = SendError(sendErr)
+	}
+
+	pending := make([]PendingMessage, len(in.Pending))
+
+	for i, msg := range in.Pending {
+		pending[i] = PendingMessage{
+			ID:           msg.ID,
+			Origin:       msg.Origin,
+			Seq:          msg.Seq,
+			CallbackType: msg.CallbackType,
+			Waiting:      msg.Waiting,
+			HeldSince:    msg.HeldSince,
+			Lamport:      msg.Lamport,
+			Unstable:     msg.Unstable,
+		}
+	}
+
+	return State{
+		Host:     in.Host,
+		Round:    in.Round,
+		Elements: elements,
+		Peers:    in.Peers,
+		Config: Config{
+			Beta:               in.Config.Beta,
+			RoundDuration:      in.Config.RoundDuration.String(),
+			BufferSize:         in.Config.BufferSize,
+			EvictStable:        in.Config.EvictStable,
+			Exchange:           in.Config.Exchange.String(),
+			Delivery:           in.Config.Delivery.String(),
+			Limits:             Limits(in.Config.Limits),
+			SyncRateLimit:      in.Config.SyncRateLimit,
+			SyncBurst:          in.Config.SyncBurst,
+			MaxMessageAge:      in.Config.MaxMessageAge.String(),
+			RequireKnownSender: in.Config.RequireKnownSender,
+			Signing:            in.Config.Signing,
+			Authentication:     in.Config.Authentication,
+			Encryption:         in.Config.Encryption,
+			MembershipPolicy:   in.Config.MembershipPolicy,
+		},
+		Stats:      Stats(in.Stats),
+		SendErrors: sendErrors,
+		Pending:    pending,
+		Outbound:   in.Outbound,
+
+		StableFrontier: in.StableFrontier,
+	}
+}
+
+// NewHandler returns a handler with the debug pages of given protocol instance.
+// It can be mounted on any http.ServeMux, e.g.:
+//
+//	mux.Handle("/debug/bmmc/", http.StripPrefix("/debug/bmmc", debug.NewHandler(b)))
+//
+// The handler must not be exposed without authentication, since it exposes the
+// state of the node and records CPU profiles of up to a minute.
+func NewHandler(b *bmmc.BMMC) http.Handler {
+	mux := http.NewServeMux()
+
+	views := map[string]func(State) any{
+		StatePath:    func(s State) any { return s },
+		BufferPath:   func(s State) any { return s.Elements },
+		PeersPath:    func(s State) any { return s.Peers },
+		RoundPath:    func(s State) any { return s.Round },
+		ConfigPath:   func(s State) any { return s.Config },
+		StatsPath:    func(s State) any { return s.Stats },
+		ErrorsPath:   func(s State) any { return s.SendErrors },
+		OutboundPath: func(s State) any { return s.Outbound },
+		PendingPath:  func(s State) any { return s.Pending },
+	}
+
+	for path, view := range views {
+		view := view
+
+		mux.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+			writeJSON(w, view(NewState(b.Inspect())))
+		})
+	}
+
+	mux.HandleFunc(PprofPath, servePprof)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		writeJSON(w, []string{
+			StatePath, BufferPath, PeersPath, RoundPath, ConfigPath,
+			StatsPath, ErrorsPath, OutboundPath, PendingPath, PprofPath,
+		})
+	})
+
+	return mux
+}
+
+// writeJSON writes the given value as indented JSON.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
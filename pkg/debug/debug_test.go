@@ -0,0 +1,186 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+var _ = Describe("Debug handler", func() {
+	var srv *httptest.Server
+
+	get := func(path string, v any) {
+		resp, err := http.Get(srv.URL + "/debug/bmmc" + path)
+		Expect(err).ToNot(HaveOccurred())
+
+		defer resp.Body.Close()
+
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(json.NewDecoder(resp.Body).Decode(v)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		network := memory.NewNetwork()
+
+		b, err := bmmc.New(&bmmc.Config{
+			Host:          network.Peer("n0"),
+			BufferSize:    16,
+			RoundDuration: 10 * time.Millisecond,
+			Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+			AuthKeys:      map[string][]byte{"key": []byte("secret")},
+			AuthKeyID:     "key",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		network.Register("n0", b.Handle)
+
+		Expect(b.Start()).To(Succeed())
+		DeferCleanup(b.Stop)
+
+		Expect(b.AddMessage(context.Background(), "message", bmmc.NOCALLBACK)).To(Succeed())
+		// n1 is not registered in the network, so gossip messages cannot be sent
+		Expect(b.AddPeer("n1")).To(Succeed())
+
+		mux := http.NewServeMux()
+		mux.Handle("/debug/bmmc/", http.StripPrefix("/debug/bmmc", NewHandler(b)))
+
+		srv = httptest.NewServer(mux)
+		DeferCleanup(srv.Close)
+	})
+
+	It("serves the messages buffer", func() {
+		var elements []Element
+		get(BufferPath, &elements)
+
+		Expect(elements).To(HaveLen(2))
+
+		var user []Element
+
+		for _, el := range elements {
+			if !el.Internal {
+				user = append(user, el)
+			}
+		}
+
+		Expect(user).To(HaveLen(1))
+		Expect(user[0].ID).ToNot(BeEmpty())
+		Expect(user[0].Origin).To(Equal("n0"))
+		Expect(user[0].CallbackType).To(Equal(bmmc.NOCALLBACK))
+	})
+
+	It("serves the peers and the round", func() {
+		var peers []string
+		get(PeersPath, &peers)
+		Expect(peers).To(Equal([]string{"n1"}))
+
+		Eventually(func() int64 {
+			var round int64
+			get(RoundPath, &round)
+
+			return round
+		}).Should(BeNumerically(">", 0))
+	})
+
+	It("serves the config without secrets", func() {
+		var cfg map[string]any
+		get(ConfigPath, &cfg)
+
+		Expect(cfg).To(HaveKeyWithValue("bufferSize", 16.0))
+		Expect(cfg).To(HaveKeyWithValue("exchange", "async"))
+		Expect(cfg).To(HaveKeyWithValue("roundDuration", "10ms"))
+		Expect(cfg).To(HaveKeyWithValue("authentication", true))
+		Expect(cfg).ToNot(HaveKey("authKeys"))
+	})
+
+	It("serves the recent send errors", func() {
+		Eventually(func() []SendError {
+			var sendErrors []SendError
+			get(ErrorsPath, &sendErrors)
+
+			return sendErrors
+		}).ShouldNot(BeEmpty())
+
+		var state State
+		get(StatePath, &state)
+
+		Expect(state.Host).To(Equal("n0"))
+		Expect(state.SendErrors[0].Peer).To(Equal("n1"))
+		Expect(state.SendErrors[0].Route).To(Equal(bmmc.GossipRoute))
+		Expect(state.Outbound).ToNot(BeNil())
+	})
+
+	It("serves the messages held back by the delivery order", func() {
+		var pending []PendingMessage
+		get(PendingPath, &pending)
+
+		Expect(pending).To(BeEmpty())
+	})
+
+	It("serves the runtime profiles", func() {
+		resp, err := http.Get(srv.URL + "/debug/bmmc/pprof/goroutine?debug=1")
+		Expect(err).ToNot(HaveOccurred())
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(string(body)).To(ContainSubstring("goroutine profile"))
+
+		resp, err = http.Get(srv.URL + "/debug/bmmc/pprof/unknown")
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("serves the CPU profile only when no other CPU profile is running", func() {
+		Expect(pprof.StartCPUProfile(io.Discard)).To(Succeed())
+
+		resp, err := http.Get(srv.URL + "/debug/bmmc/pprof/profile?seconds=1")
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusConflict))
+		Expect(resp.Header.Get("Content-Disposition")).To(BeEmpty())
+
+		pprof.StopCPUProfile()
+
+		resp, err = http.Get(srv.URL + "/debug/bmmc/pprof/profile?seconds=1")
+		Expect(err).ToNot(HaveOccurred())
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Disposition")).To(ContainSubstring("profile"))
+		Expect(body).ToNot(BeEmpty())
+	})
+})
@@ -0,0 +1,118 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cpuProfileName is the name of the CPU profile page.
+	cpuProfileName = "profile"
+
+	defaultCPUProfileSeconds = 30
+	// maxCPUProfileSeconds bounds the duration of a CPU profile, since the
+	// CPU profile is process-wide and only one can be recorded at once
+	maxCPUProfileSeconds = 60
+)
+
+// servePprof serves the runtime profiles, in the format of net/http/pprof:
+// the index with all profiles, the named profiles (e.g. goroutine?debug=1)
+// and the CPU profile (profile?seconds=10).
+// Unlike net/http/pprof, nothing is registered on http.DefaultServeMux.
+func servePprof(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	switch name {
+	case "":
+		servePprofIndex(w)
+	case cpuProfileName:
+		serveCPUProfile(w, r)
+	default:
+		serveProfile(w, r, name)
+	}
+}
+
+// servePprofIndex lists the available profiles.
+func servePprofIndex(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	for _, p := range pprof.Profiles() {
+		fmt.Fprintf(w, "%s\t%d\n", p.Name(), p.Count())
+	}
+
+	fmt.Fprintf(w, "%s\n", cpuProfileName)
+}
+
+// serveProfile writes the named profile.
+func serveProfile(w http.ResponseWriter, r *http.Request, name string) {
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, fmt.Sprintf("unknown profile %q", name), http.StatusNotFound)
+
+		return
+	}
+
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+
+	p.WriteTo(w, debug) //nolint: errcheck
+}
+
+// serveCPUProfile records and writes a CPU profile.
+func serveCPUProfile(w http.ResponseWriter, r *http.Request) {
+	seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || seconds <= 0 {
+		seconds = defaultCPUProfileSeconds
+	}
+
+	seconds = min(seconds, maxCPUProfileSeconds)
+
+	// the profile is recorded in memory, so the headers of the profile are set
+	// only after the profile is started (e.g. not when another profile is running)
+	var profile bytes.Buffer
+
+	if err := pprof.StartCPUProfile(&profile); err != nil {
+		http.Error(w, fmt.Sprintf("cannot start CPU profile: %s", err), http.StatusConflict)
+
+		return
+	}
+
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+	}
+
+	pprof.StopCPUProfile()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+
+	profile.WriteTo(w) //nolint: errcheck
+}
@@ -22,6 +22,13 @@ import (
 	"sync"
 )
 
+var (
+	// ErrDuplicateElement is returned by Insert when the buffer already contains the element.
+	ErrDuplicateElement = errors.New("buffer already contains the element")
+	// ErrStaleElement is returned by Insert when the buffer contains a newer version of a keyed element.
+	ErrStaleElement = errors.New("buffer contains a newer version of the element")
+)
+
 var (
 	errIndexOutOfRange = errors.New("index out of range")
 	errTooOldElement   = errors.New("element is too old and buffer is full")
@@ -92,26 +99,131 @@ func (buf *Buffer) shiftElements(index int) error {
 // Add adds the given element in buffer.
 // When the buffer is full, oldest element will be removed.
 func (buf *Buffer) Add(el Element) error {
+	if _, err := buf.Insert(el); err != nil && !errors.Is(err, ErrDuplicateElement) {
+		return err
+	}
+
+	return nil
+}
+
+// Insert adds the given element in buffer and returns the element removed to
+// make room for it: the older version of a keyed element, or the oldest element
+// if the buffer is full.
+// It returns ErrDuplicateElement if the buffer already contains the element
+// and ErrStaleElement if the buffer contains a newer version of the element.
+func (buf *Buffer) Insert(el Element) (*Element, error) {
 	buf.Mux.Lock()
 	defer buf.Mux.Unlock()
 
 	if e, _ := buf.contains(el); e {
-		return nil
+		return nil, ErrDuplicateElement
+	}
+
+	superseded, err := buf.supersede(el)
+	if err != nil {
+		return nil, err
 	}
 
 	pos, err := buf.elementPosition(el)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// the buffer is not full if the older version was removed
+	full := buf.Len == len(buf.Elements)
+	evicted := superseded
+
+	if full {
+		oldest := buf.Elements[buf.Len-1]
+		evicted = &oldest
 	}
 
 	if err := buf.shiftElements(pos); err != nil {
-		return err
+		return nil, err
 	}
 
 	buf.Elements[pos] = el
-	buf.Len++
 
-	return nil
+	if !full {
+		buf.Len++
+	}
+
+	return evicted, nil
+}
+
+// Remove removes the elements with given IDs from buffer and returns them.
+func (buf *Buffer) Remove(ids []string) []Element {
+	buf.Mux.Lock()
+	defer buf.Mux.Unlock()
+
+	remove := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		remove[id] = struct{}{}
+	}
+
+	removed := []Element{}
+	n := 0
+
+	for i := 0; i < buf.Len; i++ {
+		if _, ok := remove[buf.Elements[i].ID]; ok {
+			removed = append(removed, buf.Elements[i])
+
+			continue
+		}
+
+		buf.Elements[n] = buf.Elements[i]
+		n++
+	}
+
+	for i := n; i < buf.Len; i++ {
+		buf.Elements[i] = Element{}
+	}
+
+	buf.Len = n
+
+	return removed
+}
+
+// supersede removes the older version of the given keyed element from buffer
+// and returns it. It returns ErrStaleElement if the buffer contains a newer version.
+func (buf *Buffer) supersede(el Element) (*Element, error) {
+	if el.Key == "" {
+		return nil, nil
+	}
+
+	for i := 0; i < buf.Len; i++ {
+		if buf.Elements[i].Key != el.Key {
+			continue
+		}
+
+		if !el.Supersedes(buf.Elements[i]) {
+			return nil, ErrStaleElement
+		}
+
+		older := buf.Elements[i]
+
+		copy(buf.Elements[i:buf.Len-1], buf.Elements[i+1:buf.Len])
+		buf.Elements[buf.Len-1] = Element{}
+		buf.Len--
+
+		return &older, nil
+	}
+
+	return nil, nil
+}
+
+// IsSuperseded returns true if the buffer contains a newer version of the given element.
+func (buf *Buffer) IsSuperseded(el Element) bool {
+	buf.Mux.RLock()
+	defer buf.Mux.RUnlock()
+
+	for i := 0; i < buf.Len; i++ {
+		if buf.Elements[i].Supersedes(el) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Digest returns a slice with elements ids.
@@ -163,6 +275,33 @@ func (buf *Buffer) Messages(withInternals bool) []any {
 	return msgs
 }
 
+// AllElements returns a slice with all elements from buffer.
+func (buf *Buffer) AllElements() []Element {
+	buf.Mux.RLock()
+	defer buf.Mux.RUnlock()
+
+	el := make([]Element, buf.Len)
+	copy(el, buf.Elements[:buf.Len])
+
+	return el
+}
+
+// UserElements returns a slice with all user (not internal) elements from buffer.
+func (buf *Buffer) UserElements() []Element {
+	buf.Mux.RLock()
+	defer buf.Mux.RUnlock()
+
+	el := []Element{}
+
+	for i := 0; i < buf.Len; i++ {
+		if !buf.Elements[i].Internal {
+			el = append(el, buf.Elements[i])
+		}
+	}
+
+	return el
+}
+
 // Length returns number of elements in buffer.
 func (buf *Buffer) Length() int {
 	buf.Mux.RLock()
@@ -179,12 +318,39 @@ func (buf *Buffer) ElementsFromIDs(digest []string) []Element {
 	defer buf.Mux.RUnlock()
 
 	el := []Element{}
+	ids := stringSet(digest)
 
 	for i := 0; i < buf.Len; i++ {
-		if ContainsString(digest, buf.Elements[i].ID) {
+		if _, ok := ids[buf.Elements[i].ID]; ok {
 			el = append(el, buf.Elements[i])
 		}
 	}
 
 	return el
 }
+
+// ElementsFromSequences returns a slice with elements from given sequence numbers,
+// indexed by the origin of elements.
+func (buf *Buffer) ElementsFromSequences(sequences map[string][]uint64) []Element {
+	buf.Mux.RLock()
+	defer buf.Mux.RUnlock()
+
+	el := []Element{}
+
+	for i := 0; i < buf.Len; i++ {
+		seqs, ok := sequences[buf.Elements[i].Origin]
+		if !ok || buf.Elements[i].Seq == 0 {
+			continue
+		}
+
+		for _, seq := range seqs {
+			if buf.Elements[i].Seq == seq {
+				el = append(el, buf.Elements[i])
+
+				break
+			}
+		}
+	}
+
+	return el
+}
@@ -360,6 +360,87 @@ var _ = Describe("Buffer interface", func() {
 		})
 	})
 
+	Describe("Insert function", func() {
+		It("reports when the oldest element is removed", func() {
+			buf := NewBuffer(2)
+
+			for i, year := range []int{2012, 2014} {
+				evicted, err := buf.Insert(Element{
+					Timestamp: time.Date(year, time.October, 29, 0, 0, 0, 0, time.UTC),
+					ID:        string(rune('a' + i)),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(evicted).To(BeNil())
+			}
+
+			evicted, err := buf.Insert(Element{
+				Timestamp: time.Date(2016, time.October, 29, 0, 0, 0, 0, time.UTC),
+				ID:        "c",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(evicted).ToNot(BeNil())
+			Expect(evicted.ID).To(Equal("a"))
+			Expect(buf.Length()).To(Equal(2))
+			Expect(buf.Digest()).To(Equal([]string{"c", "b"}))
+
+			_, err = buf.Insert(Element{ID: "c"})
+			Expect(err).To(MatchError(ErrDuplicateElement))
+		})
+	})
+
+	Describe("Insert function with keyed elements", func() {
+		It("replaces the older version of the element", func() {
+			buf := NewBuffer(2)
+
+			older := Element{ID: "a1", Key: "a", Timestamp: time.Date(2012, time.October, 29, 0, 0, 0, 0, time.UTC)}
+			other := Element{ID: "b1", Key: "b", Timestamp: time.Date(2013, time.October, 29, 0, 0, 0, 0, time.UTC)}
+			newer := Element{ID: "a2", Key: "a", Timestamp: time.Date(2014, time.October, 29, 0, 0, 0, 0, time.UTC)}
+
+			for _, el := range []Element{older, other} {
+				evicted, err := buf.Insert(el)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(evicted).To(BeNil())
+			}
+
+			Expect(buf.IsSuperseded(older)).To(BeFalse())
+
+			evicted, err := buf.Insert(newer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(evicted).ToNot(BeNil())
+			Expect(evicted.ID).To(Equal("a1"))
+			Expect(buf.Digest()).To(Equal([]string{"a2", "b1"}))
+			Expect(buf.IsSuperseded(older)).To(BeTrue())
+			Expect(buf.IsSuperseded(newer)).To(BeFalse())
+
+			_, err = buf.Insert(older)
+			Expect(err).To(MatchError(ErrStaleElement))
+			Expect(buf.Digest()).To(Equal([]string{"a2", "b1"}))
+		})
+	})
+
+	Describe("Remove function", func() {
+		It("removes the elements with given IDs", func() {
+			buf := NewBuffer(4)
+
+			for i, year := range []int{2012, 2014, 2016} {
+				Expect(buf.Add(Element{
+					Timestamp: time.Date(year, time.October, 29, 0, 0, 0, 0, time.UTC),
+					ID:        string(rune('a' + i)),
+				})).To(Succeed())
+			}
+
+			removed := buf.Remove([]string{"a", "c", "x"})
+			Expect(removed).To(HaveLen(2))
+			Expect(removed[0].ID).To(Equal("c"))
+			Expect(removed[1].ID).To(Equal("a"))
+			Expect(buf.Length()).To(Equal(1))
+			Expect(buf.Digest()).To(Equal([]string{"b"}))
+
+			Expect(buf.Add(Element{ID: "a"})).To(Succeed())
+			Expect(buf.Digest()).To(Equal([]string{"b", "a"}))
+		})
+	})
+
 	Describe("Digest function", func() {
 		It("returns proper digest when buffer is full", func() {
 			fullBuf := &Buffer{
@@ -551,6 +632,45 @@ var _ = Describe("Buffer interface", func() {
 		})
 	})
 
+	Describe("AllElements function", func() {
+		It("returns user and internal elements", func() {
+			buf := &Buffer{
+				Elements: []Element{
+					{ID: "100", Internal: false},
+					{ID: "101", Internal: true},
+					{},
+				},
+				Len: 2,
+				Mux: &sync.RWMutex{},
+			}
+
+			Expect(buf.AllElements()).To(Equal([]Element{
+				{ID: "100", Internal: false},
+				{ID: "101", Internal: true},
+			}))
+		})
+	})
+
+	Describe("UserElements function", func() {
+		It("doesn't return internal elements", func() {
+			buf := &Buffer{
+				Elements: []Element{
+					{ID: "100", Internal: false},
+					{ID: "101", Internal: true},
+					{ID: "102", Internal: false},
+					{},
+				},
+				Len: 3,
+				Mux: &sync.RWMutex{},
+			}
+
+			Expect(buf.UserElements()).To(Equal([]Element{
+				{ID: "100", Internal: false},
+				{ID: "102", Internal: false},
+			}))
+		})
+	})
+
 	Describe("Length function", func() {
 		It("returns number of elements in buffer", func() {
 			buf := &Buffer{
@@ -593,4 +713,31 @@ var _ = Describe("Buffer interface", func() {
 			Expect(buf.ElementsFromIDs(digest)).To(Equal(expectedElements))
 		})
 	})
+
+	Describe("ElementsFromSequences function", func() {
+		It("return elements from buffer", func() {
+			buf := &Buffer{
+				Elements: make([]Element, 5),
+				Len:      5,
+				Mux:      &sync.RWMutex{},
+			}
+			buf.Elements[0] = Element{ID: "100", Origin: "n0", Seq: 1}
+			buf.Elements[1] = Element{ID: "101", Origin: "n0", Seq: 2}
+			buf.Elements[2] = Element{ID: "102", Origin: "n1", Seq: 1}
+			buf.Elements[3] = Element{ID: "103", Origin: "n1"}
+			buf.Elements[4] = Element{ID: "104", Origin: "n2", Seq: 1}
+
+			sequences := map[string][]uint64{
+				"n0": {2, 3},
+				"n1": {0, 1},
+			}
+
+			expectedElements := []Element{
+				{ID: "101", Origin: "n0", Seq: 2},
+				{ID: "102", Origin: "n1", Seq: 1},
+			}
+
+			Expect(buf.ElementsFromSequences(sequences)).To(Equal(expectedElements))
+		})
+	})
 })
@@ -19,18 +19,48 @@ package buffer
 import (
 	"crypto/sha1" //nolint: gosec
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"time"
 )
 
 // Element is an element from messages buffer.
 type Element struct {
-	ID           string    `json:"id"`
-	Timestamp    time.Time `json:"timestamp"`
-	Msg          any       `json:"msg"`
-	CallbackType string    `json:"callbackType"`
-	GossipCount  int64     `json:"gossipCount"` // number of rounds since the element is in buffer
-	Internal     bool      `json:"internal"`    // true if the element is an internal element, not a user element
+	ID           string            `json:"id"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Msg          any               `json:"msg"`
+	CallbackType string            `json:"callbackType"`
+	GossipCount  int64             `json:"gossipCount"`          // number of rounds since the element is in buffer
+	Internal     bool              `json:"internal"`             // true if the element is an internal element, not a user element
+	Origin       string            `json:"origin,omitempty"`     // host that created the element
+	Signature    []byte            `json:"signature,omitempty"`  // origin signature of the element
+	Ciphertext   []byte            `json:"ciphertext,omitempty"` // encrypted message, when Msg is encrypted
+	KeyID        string            `json:"keyId,omitempty"`      // ID of the key used to encrypt the message
+	Headers      map[string]string `json:"headers,omitempty"`    // metadata of the element (e.g. join token)
+	Hops         int               `json:"hops,omitempty"`       // number of hosts that forwarded the element since its origin
+	Seq          uint64            `json:"seq,omitempty"`        // sequence number of the element from its origin
+	Epoch        uint64            `json:"epoch,omitempty"`      // incarnation of the origin, which numbers its elements from 1 again after a restart
+	Clock        map[string]uint64 `json:"clock,omitempty"`      // sequence numbers of the elements delivered by the origin, for causal delivery
+	Lamport      uint64            `json:"lamport,omitempty"`    // Lamport timestamp of the element, for total order delivery
+	Key          string            `json:"key,omitempty"`        // key of keyed elements, replaced by newer versions with the same key
+}
+
+// signedElement contains the fields of an element covered by the origin signature.
+type signedElement struct {
+	ID           string            `json:"id"`
+	Timestamp    int64             `json:"timestamp"`
+	Msg          json.RawMessage   `json:"msg"`
+	CallbackType string            `json:"callbackType"`
+	Internal     bool              `json:"internal"`
+	Origin       string            `json:"origin"`
+	Ciphertext   []byte            `json:"ciphertext,omitempty"`
+	KeyID        string            `json:"keyId,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Seq          uint64            `json:"seq,omitempty"`
+	Epoch        uint64            `json:"epoch,omitempty"`
+	Clock        map[string]uint64 `json:"clock,omitempty"`
+	Lamport      uint64            `json:"lamport,omitempty"`
+	Key          string            `json:"key,omitempty"`
 }
 
 // generateIDFromMsg returns an ID consisting of a hash of the original string,
@@ -47,18 +77,149 @@ func generateIDFromMsg(s string) (string, error) {
 }
 
 // NewElement creates new buffer element with given message and callback type.
+// The ID of user elements depends only on the message, until they get a sequence
+// number from their origin, while the ID of internal elements (e.g. peers list
+// updates) also depends on the callback type and timestamp, since they are events.
 func NewElement(msg any, cbType string, internal bool) (Element, error) {
-	id, err := generateIDFromMsg(fmt.Sprintf("%v", msg))
+	timestamp := time.Now()
+	idSource := fmt.Sprintf("%v", msg)
+
+	if internal {
+		idSource = fmt.Sprintf("%s/%s/%d", cbType, idSource, timestamp.UnixNano())
+	}
+
+	id, err := generateIDFromMsg(idSource)
 	if err != nil {
 		return Element{}, err
 	}
 
 	return Element{
 		ID:           id,
-		Timestamp:    time.Now(),
+		Timestamp:    timestamp,
 		Msg:          msg,
 		CallbackType: cbType,
 		GossipCount:  0,
 		Internal:     internal,
 	}, nil
 }
+
+// NewKeyedElement creates new buffer element which is a version of the given key.
+// The ID of keyed elements also depends on the key and timestamp, since the same
+// message can be a version of different keys, or again a version of the same key.
+func NewKeyedElement(key string, msg any, cbType string) (Element, error) {
+	timestamp := time.Now()
+
+	id, err := generateIDFromMsg(fmt.Sprintf("%s/%s/%v/%d", cbType, key, msg, timestamp.UnixNano()))
+	if err != nil {
+		return Element{}, err
+	}
+
+	return Element{
+		ID:           id,
+		Timestamp:    timestamp,
+		Msg:          msg,
+		CallbackType: cbType,
+		GossipCount:  0,
+		Key:          key,
+	}, nil
+}
+
+// Sequence sets the sequence number of the element from its origin, in the
+// given epoch of the origin, for ordered delivery. The ID of the element also
+// depends on its origin, epoch and sequence number, so the same message added by
+// different origins, or again by the same origin, is a different element.
+func (e *Element) Sequence(epoch uint64, seq uint64) error {
+	id, err := generateIDFromMsg(fmt.Sprintf("%s/%s/%d/%d", e.ID, e.Origin, epoch, seq))
+	if err != nil {
+		return err
+	}
+
+	e.ID = id
+	e.Epoch = epoch
+	e.Seq = seq
+
+	return nil
+}
+
+// Supersedes returns true if the element is a newer version of the same key
+// as the given element. Versions are ordered by timestamp, then by origin and
+// ID, so all hosts pick the same last writer.
+func (e Element) Supersedes(other Element) bool {
+	if e.Key == "" || e.Key != other.Key {
+		return false
+	}
+
+	if !e.Timestamp.Equal(other.Timestamp) {
+		return e.Timestamp.After(other.Timestamp)
+	}
+
+	if e.Origin != other.Origin {
+		return e.Origin > other.Origin
+	}
+
+	return e.ID > other.ID
+}
+
+// canonicalMsg returns the JSON encoding of given message, as it is seen by
+// peers after decoding it (e.g. struct fields become sorted map keys).
+func canonicalMsg(msg any) ([]byte, error) {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	var decoded any
+
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err //nolint: wrapcheck
+	}
+
+	return json.Marshal(decoded) //nolint: wrapcheck
+}
+
+// SigningBytes returns the bytes covered by the origin signature of the element.
+// Fields updated by peers (e.g. the gossip count) are not covered.
+func (e Element) SigningBytes() ([]byte, error) {
+	msg, err := canonicalMsg(e.Msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(signedElement{ //nolint: wrapcheck
+		ID:           e.ID,
+		Timestamp:    e.Timestamp.UnixNano(),
+		Msg:          msg,
+		CallbackType: e.CallbackType,
+		Internal:     e.Internal,
+		Origin:       e.Origin,
+		Ciphertext:   e.Ciphertext,
+		KeyID:        e.KeyID,
+		Headers:      e.Headers,
+		Seq:          e.Seq,
+		Epoch:        e.Epoch,
+		Clock:        e.Clock,
+		Lamport:      e.Lamport,
+		Key:          e.Key,
+	})
+}
+
+// Seal replaces the message of the element with the given ciphertext.
+// The element ID is generated from the ciphertext, so it doesn't reveal the message.
+func (e *Element) Seal(ciphertext []byte, keyID string) error {
+	id, err := generateIDFromMsg(string(ciphertext))
+	if err != nil {
+		return err
+	}
+
+	e.ID = id
+	e.Msg = nil
+	e.Ciphertext = ciphertext
+	e.KeyID = keyID
+
+	return nil
+}
+
+// Encrypted returns true if the message of the element is encrypted.
+func (e Element) Encrypted() bool {
+	return len(e.Ciphertext) > 0
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package buffer
 
 import (
+	"encoding/json"
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -34,5 +37,150 @@ var _ = Describe("Buffer interface", func() {
 			Expect(el.GossipCount).To(Equal(int64(0)))
 			Expect(el.Internal).To(BeTrue())
 		})
+
+		It("creates user elements with same ID for same message", func() {
+			first, err := NewElement("message", "callback type", false)
+			Expect(err).ToNot(HaveOccurred())
+
+			second, err := NewElement("message", "callback type", false)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(second.ID).To(Equal(first.ID))
+		})
+
+		It("creates internal elements with different IDs for same message", func() {
+			added, err := NewElement("localhost:19999", "add-peer", true)
+			Expect(err).ToNot(HaveOccurred())
+
+			removed, err := NewElement("localhost:19999", "remove-peer", true)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(removed.ID).ToNot(Equal(added.ID))
+		})
+	})
+
+	Describe("NewKeyedElement function", func() {
+		It("creates keyed elements with different IDs for same message", func() {
+			first, err := NewKeyedElement("a", "message", "callback type")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(first.Key).To(Equal("a"))
+			Expect(first.Internal).To(BeFalse())
+
+			second, err := NewKeyedElement("b", "message", "callback type")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(second.ID).ToNot(Equal(first.ID))
+		})
+	})
+
+	Describe("Sequence function", func() {
+		It("gives the same message different IDs for each origin, epoch and sequence number", func() {
+			ids := map[string]struct{}{}
+
+			for _, origin := range []string{"n8", "n9"} {
+				for epoch := uint64(1); epoch <= 2; epoch++ {
+					for seq := uint64(1); seq <= 2; seq++ {
+						el, err := NewElement("message", "callback type", false)
+						Expect(err).ToNot(HaveOccurred())
+
+						el.Origin = origin
+						Expect(el.Sequence(epoch, seq)).To(Succeed())
+						Expect(el.Epoch).To(Equal(epoch))
+						Expect(el.Seq).To(Equal(seq))
+
+						ids[el.ID] = struct{}{}
+					}
+				}
+			}
+
+			Expect(ids).To(HaveLen(8))
+		})
+	})
+
+	Describe("Supersedes function", func() {
+		timestamp := time.Date(2024, time.October, 29, 0, 0, 0, 0, time.UTC)
+
+		DescribeTable("compares the versions of a key", func(el, other Element, expected bool) {
+			Expect(el.Supersedes(other)).To(Equal(expected))
+		},
+			Entry("newer timestamp",
+				Element{ID: "1", Key: "a", Timestamp: timestamp.Add(time.Second)},
+				Element{ID: "2", Key: "a", Timestamp: timestamp}, true),
+			Entry("older timestamp",
+				Element{ID: "1", Key: "a", Timestamp: timestamp},
+				Element{ID: "2", Key: "a", Timestamp: timestamp.Add(time.Second)}, false),
+			Entry("same timestamp, greater origin",
+				Element{ID: "1", Key: "a", Timestamp: timestamp, Origin: "n2"},
+				Element{ID: "2", Key: "a", Timestamp: timestamp, Origin: "n1"}, true),
+			Entry("same timestamp and origin, greater ID",
+				Element{ID: "2", Key: "a", Timestamp: timestamp, Origin: "n1"},
+				Element{ID: "1", Key: "a", Timestamp: timestamp, Origin: "n1"}, true),
+			Entry("different keys",
+				Element{ID: "1", Key: "a", Timestamp: timestamp.Add(time.Second)},
+				Element{ID: "2", Key: "b", Timestamp: timestamp}, false),
+			Entry("elements without key",
+				Element{ID: "1", Timestamp: timestamp.Add(time.Second)},
+				Element{ID: "2", Timestamp: timestamp}, false),
+		)
+	})
+
+	Describe("SigningBytes function", func() {
+		type testType struct {
+			String string
+			Int    int
+			Map    map[string]any
+		}
+
+		It("returns same bytes after the element is decoded by a peer", func() {
+			el, err := NewElement(testType{
+				String: "string",
+				Int:    100,
+				Map:    map[string]any{"b": true, "a": 1.5},
+			}, "callback type", false)
+			Expect(err).ToNot(HaveOccurred())
+
+			el.Origin = "localhost:19999"
+
+			encoded, err := json.Marshal(el)
+			Expect(err).ToNot(HaveOccurred())
+
+			var decoded Element
+			Expect(json.Unmarshal(encoded, &decoded)).To(Succeed())
+
+			decoded.GossipCount = 10
+
+			expected, err := el.SigningBytes()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded.SigningBytes()).To(Equal(expected))
+		})
+
+		It("returns different bytes when a signed field is changed", func() {
+			el, err := NewElement("message", "callback type", false)
+			Expect(err).ToNot(HaveOccurred())
+
+			original, err := el.SigningBytes()
+			Expect(err).ToNot(HaveOccurred())
+
+			el.Msg = "another message"
+			Expect(el.SigningBytes()).NotTo(Equal(original))
+		})
+	})
+
+	Describe("Seal function", func() {
+		It("replaces the message with the ciphertext", func() {
+			el, err := NewElement("message", "callback type", false)
+			Expect(err).ToNot(HaveOccurred())
+
+			id := el.ID
+
+			Expect(el.Encrypted()).To(BeFalse())
+			Expect(el.Seal([]byte("ciphertext"), "key-id")).To(Succeed())
+
+			Expect(el.Encrypted()).To(BeTrue())
+			Expect(el.Msg).To(BeNil())
+			Expect(el.ID).NotTo(Equal(id))
+			Expect(el.Ciphertext).To(Equal([]byte("ciphertext")))
+			Expect(el.KeyID).To(Equal("key-id"))
+		})
 	})
 })
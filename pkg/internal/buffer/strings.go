@@ -27,12 +27,24 @@ func ContainsString(a []string, x string) bool {
 	return false
 }
 
+// stringSet returns a set with the strings from given slice.
+func stringSet(a []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(a))
+
+	for i := range a {
+		set[a[i]] = struct{}{}
+	}
+
+	return set
+}
+
 // MissingStrings returns the disjunction between given slices: a - b.
 func MissingStrings(a []string, b []string) []string {
 	s := []string{}
+	set := stringSet(b)
 
 	for i := range a {
-		if !ContainsString(b, a[i]) {
+		if _, ok := set[a[i]]; !ok {
 			s = append(s, a[i])
 		}
 	}
@@ -21,8 +21,12 @@ import (
 	"log/slog"
 )
 
-// NOCALLBACK is the type of messages without callback.
-const NOCALLBACK = "no-callback"
+const (
+	// NOCALLBACK is the type of messages without callback.
+	NOCALLBACK = "no-callback"
+	// RETRACT is the type of tombstone messages used for retracting messages.
+	RETRACT = "retract"
+)
 
 var (
 	errNilCallbackMap         = errors.New("callback map must not be nil")
@@ -59,7 +63,7 @@ func (r *Registry) GetCallback(t string) func(any, *slog.Logger) error {
 func ValidateCustomCallbacks(customCallbacks map[string]func(any, *slog.Logger) error) error {
 	// don't allow to use internal callbacks types as custom callback types
 	for customType := range customCallbacks {
-		if customType == ADDPEER || customType == REMOVEPEER {
+		if customType == ADDPEER || customType == REMOVEPEER || customType == RETRACT {
 			return errNotAllowedCallbackType
 		}
 	}
@@ -128,6 +128,19 @@ var _ = Describe("Callback interface", func() {
 			Expect(ValidateCustomCallbacks(cb)).To(MatchError(errors.New("callback type is not allowed"))) //nolint: goerr113
 		})
 
+		It("returns error when callbacks contain a `retract` type", func() {
+			cb := map[string]func(any, *slog.Logger) error{
+				"a-callback": func(_ any, _ *slog.Logger) error {
+					return nil
+				},
+				"retract": func(_ any, _ *slog.Logger) error {
+					return nil
+				},
+			}
+
+			Expect(ValidateCustomCallbacks(cb)).To(MatchError(errors.New("callback type is not allowed"))) //nolint: goerr113
+		})
+
 		It("doesn't return error when all callback are valid", func() {
 			cb := map[string]func(any, *slog.Logger) error{
 				"a-callback": func(_ any, _ *slog.Logger) error {
@@ -40,6 +40,10 @@ var (
 type PeerCallbackData struct {
 	Element buffer.Element
 	Buffer  *peer.Buffer
+	// OnAdded is called when the peer is added. Optional.
+	OnAdded func(peer string)
+	// OnRemoved is called when the peer is removed. Optional.
+	OnRemoved func(peer string)
 }
 
 // AddPeerCallback is the callback for adding peers in peers buffer.
@@ -63,6 +67,10 @@ func AddPeerCallback(data any, logger *slog.Logger) error {
 
 	logger.Debug("new peer added", "peer", p)
 
+	if peerCBData.OnAdded != nil {
+		peerCBData.OnAdded(p)
+	}
+
 	return nil
 }
 
@@ -78,9 +86,17 @@ func RemovePeerCallback(data any, logger *slog.Logger) error {
 		return errCannotConvertToString
 	}
 
-	peerCBData.Buffer.RemovePeer(p)
+	if removed := peerCBData.Buffer.RemovePeer(p); !removed {
+		logger.Debug("peer doesn't exist", "peer", p)
+
+		return nil
+	}
 
 	logger.Debug("peer removed", "peer", p)
 
+	if peerCBData.OnRemoved != nil {
+		peerCBData.OnRemoved(p)
+	}
+
 	return nil
 }
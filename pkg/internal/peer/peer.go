@@ -21,3 +21,15 @@ type Peer interface {
 	String() string
 	Send(msg []byte, route string, peerToSend string) error
 }
+
+// Multicaster is the interface of a Host Peer that can send a message
+// to all peers at once (e.g. using an IP multicast group).
+type Multicaster interface {
+	Multicast(msg []byte, route string) error
+}
+
+// Requester is the interface of a Host Peer that can return the response
+// body of a request in the same round trip.
+type Requester interface {
+	Request(msg []byte, route string, peerToSend string) ([]byte, error)
+}
@@ -73,7 +73,8 @@ func (peerBuffer *Buffer) AddPeer(peer string) bool {
 }
 
 // RemovePeer removes a peer from peers buffer.
-func (peerBuffer *Buffer) RemovePeer(peer string) {
+// RemovePeer returns `false` when the peer doesn't exist in buffer.
+func (peerBuffer *Buffer) RemovePeer(peer string) bool {
 	peerBuffer.mux.Lock()
 	defer peerBuffer.mux.Unlock()
 
@@ -87,10 +88,14 @@ func (peerBuffer *Buffer) RemovePeer(peer string) {
 		}
 	}
 
-	if pos >= 0 {
-		peerBuffer.peers[pos] = peerBuffer.peers[len(peerBuffer.peers)-1] // Copy last element to index pos.
-		peerBuffer.peers = peerBuffer.peers[:len(peerBuffer.peers)-1]     // Truncate slice.
+	if pos < 0 {
+		return false
 	}
+
+	peerBuffer.peers[pos] = peerBuffer.peers[len(peerBuffer.peers)-1] // Copy last element to index pos.
+	peerBuffer.peers = peerBuffer.peers[:len(peerBuffer.peers)-1]     // Truncate slice.
+
+	return true
 }
 
 // GetPeers returns a list of strings that contains peers.
@@ -142,3 +147,11 @@ func (peerBuffer *Buffer) GetRandomPeers(noPeers int) []string {
 
 	return selectedPeers
 }
+
+// Contains returns true if the peer exists in peers buffer.
+func (peerBuffer *Buffer) Contains(peer string) bool {
+	peerBuffer.mux.RLock()
+	defer peerBuffer.mux.RUnlock()
+
+	return peerBuffer.alreadyExists(peer)
+}
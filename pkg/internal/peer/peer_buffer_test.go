@@ -91,6 +91,21 @@ var _ = Describe("HTTP Peer Buffer Interface", func() {
 		})
 	})
 
+	When("Contains() func is called", func() {
+		It("returns true only for peers from buffer", func() {
+			pBuf := &Buffer{
+				peers: []string{
+					"localhost/10000",
+					"localhost/20000",
+				},
+				mux: &sync.RWMutex{},
+			}
+
+			Expect(pBuf.Contains("localhost/20000")).To(BeTrue())
+			Expect(pBuf.Contains("localhost/55555")).To(BeFalse())
+		})
+	})
+
 	When("AddPeer() func is called", func() {
 		It("adds peer in the peers buffer when it doesn't exist", func() {
 			pBuf := &Buffer{
@@ -166,7 +181,7 @@ var _ = Describe("HTTP Peer Buffer Interface", func() {
 				"localhost/20000",
 			}
 
-			pBuf.RemovePeer(peerToRemove)
+			Expect(pBuf.RemovePeer(peerToRemove)).To(BeTrue())
 			Expect(pBuf.peers).To(ConsistOf(expectedPeers))
 		})
 
@@ -185,7 +200,7 @@ var _ = Describe("HTTP Peer Buffer Interface", func() {
 				"localhost/20000",
 			}
 
-			pBuf.RemovePeer(peerToRemove)
+			Expect(pBuf.RemovePeer(peerToRemove)).To(BeTrue())
 			Expect(pBuf.peers).To(ConsistOf(expectedPeers))
 		})
 
@@ -204,7 +219,7 @@ var _ = Describe("HTTP Peer Buffer Interface", func() {
 				"localhost/20000",
 			}
 
-			pBuf.RemovePeer(peerToRemove)
+			Expect(pBuf.RemovePeer(peerToRemove)).To(BeTrue())
 			Expect(pBuf.peers).To(ConsistOf(expectedPeers))
 		})
 
@@ -222,7 +237,7 @@ var _ = Describe("HTTP Peer Buffer Interface", func() {
 				"localhost/20000",
 			}
 
-			pBuf.RemovePeer(peerToRemove)
+			Expect(pBuf.RemovePeer(peerToRemove)).To(BeFalse())
 			Expect(pBuf.peers).To(ConsistOf(expectedPeers))
 		})
 	})
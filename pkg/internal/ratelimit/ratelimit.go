@@ -0,0 +1,149 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the number of buckets after which full buckets are removed.
+const maxIdleBuckets = 1024
+
+// bucket is a token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket rate limiter with a bucket for each key.
+type Limiter struct {
+	rate    float64 // tokens per second
+	burst   float64 // bucket capacity
+	buckets map[string]*bucket
+	now     func() time.Time
+	mux     *sync.Mutex
+}
+
+// NewLimiter creates a limiter that allows `rate` tokens per second for each
+// key, with bursts of at most `burst` tokens.
+func NewLimiter(rate, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+		mux:     &sync.Mutex{},
+	}
+}
+
+// refill returns the bucket of the given key, refilled until now.
+// Important! Whoever calls this function must LOCK the limiter.
+func (l *Limiter) refill(key string) *bucket {
+	now := l.now()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		l.pruneFullBuckets(now)
+
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+
+		return b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+
+	b.last = now
+
+	return b
+}
+
+// pruneFullBuckets removes the buckets that would be full at given time,
+// when there are too many buckets.
+// Important! Whoever calls this function must LOCK the limiter.
+func (l *Limiter) pruneFullBuckets(now time.Time) {
+	if len(l.buckets) < maxIdleBuckets {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Available returns the number of tokens available for the given key.
+func (l *Limiter) Available(key string) int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return int(l.refill(key).tokens)
+}
+
+// AllowN consumes n tokens for the given key.
+// It returns false and doesn't consume any token if there are not enough tokens.
+func (l *Limiter) AllowN(key string, n int) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	b := l.refill(key)
+
+	if b.tokens < float64(n) {
+		return false
+	}
+
+	b.tokens -= float64(n)
+
+	return true
+}
+
+// Reserve consumes up to n tokens for the given key and returns the number of
+// consumed tokens. Unused tokens can be given back with Release.
+func (l *Limiter) Reserve(key string, n int) int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	b := l.refill(key)
+
+	granted := min(n, int(b.tokens))
+	if granted <= 0 {
+		return 0
+	}
+
+	b.tokens -= float64(granted)
+
+	return granted
+}
+
+// Release gives back n reserved tokens for the given key.
+func (l *Limiter) Release(key string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	b := l.refill(key)
+
+	b.tokens = min(b.tokens+float64(n), l.burst)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limiter", func() {
+	var (
+		l   *Limiter
+		now time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		l = NewLimiter(100, 1000)
+		l.now = func() time.Time {
+			return now
+		}
+	})
+
+	It("allows bursts", func() {
+		Expect(l.Available("peer")).To(Equal(1000))
+		Expect(l.AllowN("peer", 600)).To(BeTrue())
+		Expect(l.AllowN("peer", 600)).To(BeFalse())
+		Expect(l.Available("peer")).To(Equal(400))
+	})
+
+	It("refills the bucket in time", func() {
+		Expect(l.AllowN("peer", 1000)).To(BeTrue())
+
+		now = now.Add(2 * time.Second)
+		Expect(l.Available("peer")).To(Equal(200))
+
+		now = now.Add(time.Hour)
+		Expect(l.Available("peer")).To(Equal(1000))
+	})
+
+	It("reserves the available tokens", func() {
+		Expect(l.Reserve("peer", 600)).To(Equal(600))
+		Expect(l.Reserve("peer", 600)).To(Equal(400))
+		Expect(l.Reserve("peer", 600)).To(Equal(0))
+
+		l.Release("peer", 300)
+		Expect(l.Available("peer")).To(Equal(300))
+
+		l.Release("peer", 5000)
+		Expect(l.Available("peer")).To(Equal(1000))
+	})
+
+	It("has a bucket for each key", func() {
+		Expect(l.AllowN("first-peer", 1000)).To(BeTrue())
+		Expect(l.AllowN("second-peer", 1000)).To(BeTrue())
+		Expect(l.AllowN("first-peer", 1)).To(BeFalse())
+	})
+
+	It("removes full buckets when there are too many buckets", func() {
+		for i := 0; i < maxIdleBuckets; i++ {
+			l.buckets[string(rune(i))] = &bucket{tokens: 1000, last: now}
+		}
+
+		Expect(l.AllowN("peer", 1)).To(BeTrue())
+		Expect(l.buckets).To(HaveLen(1))
+	})
+})
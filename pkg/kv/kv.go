@@ -0,0 +1,343 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
+)
+
+// CallbackType is the callback type of the messages used by the store.
+const CallbackType = "kv"
+
+const (
+	createStoreErrFmt = "error at creating the store: %w"
+	setErrFmt         = "error at setting the key %q: %w"
+	deleteErrFmt      = "error at deleting the key %q: %w"
+	messageErrFmt     = "%w: %w"
+)
+
+var (
+	errNilBMMCConfig           = errors.New("bmmc config must not be nil")
+	errReservedCallback        = errors.New("callback type " + CallbackType + " is reserved for the store")
+	errCannotConvertToDelivery = errors.New("cannot convert the given data to delivery")
+	errInvalidMessage          = errors.New("invalid store message")
+)
+
+// Record is a version of a key from the store.
+// Deleted records are tombstones, which are kept so older versions of
+// the key received later don't resurrect it.
+type Record struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	Origin    string    `json:"origin"`
+	Timestamp time.Time `json:"timestamp"`
+	ID        string    `json:"id"`
+}
+
+// Newer returns true if the record is a newer version than the given record.
+// Versions are ordered by timestamp, then by origin and ID, like the keyed
+// messages of the bimodal multicast protocol.
+func (r Record) Newer(other Record) bool {
+	if !r.Timestamp.Equal(other.Timestamp) {
+		return r.Timestamp.After(other.Timestamp)
+	}
+
+	if r.Origin != other.Origin {
+		return r.Origin > other.Origin
+	}
+
+	return r.ID > other.ID
+}
+
+// Resolver returns the record kept by the store when a new version of a key is
+// received. It must be deterministic and must not depend on the order in which
+// the versions are received (i.e. it must be commutative, associative and
+// idempotent), so all stores converge to the same records. With a custom
+// Resolver, every version of a key is delivered to all stores.
+type Resolver func(current, incoming Record) Record
+
+// LastWriterWins is the default Resolver. It keeps the newer version.
+func LastWriterWins(current, incoming Record) Record {
+	if incoming.Newer(current) {
+		return incoming
+	}
+
+	return current
+}
+
+// Config is the config of the store.
+type Config struct {
+	// BMMC is the config of the bimodal multicast instance used to disseminate
+	// the records. The store adds its callback in a copy of the callbacks.
+	// Required.
+	BMMC *bmmc.Config
+	// Resolver resolves the conflicts between versions of the same key.
+	// A custom Resolver receives every version of a key, so the versions are not
+	// replaced in the buffers of the hosts and the buffer must be large enough
+	// for the versions written while they are disseminated.
+	// Optional, defaults to LastWriterWins.
+	Resolver Resolver
+}
+
+// message is the message disseminated for a new version of a key.
+// The key is in the message when the version is not a keyed message.
+type message struct {
+	Key     string `json:"key,omitempty"`
+	Value   []byte `json:"value,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Store is a key-value store replicated with the bimodal multicast protocol.
+// By default, each key is a keyed message, so the older versions of a key are
+// replaced by the newer ones in the buffers of all hosts. With a custom Resolver,
+// each version is a message, so every version is delivered to the resolver.
+type Store struct {
+	bmmc     *bmmc.BMMC
+	host     peer.Peer
+	resolver Resolver
+	// keyed is true if the versions are keyed messages, i.e. the older versions
+	// received after a newer one are dropped, like by LastWriterWins
+	keyed    bool
+	mux      *sync.RWMutex
+	records  map[string]Record
+	watchers map[int]func(Record)
+	nextID   int
+}
+
+// New creates a store and its bimodal multicast instance.
+// The instance must be started with BMMC().Start().
+func New(cfg Config) (*Store, error) {
+	if cfg.BMMC == nil {
+		return nil, fmt.Errorf(createStoreErrFmt, errNilBMMCConfig)
+	}
+
+	if _, ok := cfg.BMMC.Callbacks[CallbackType]; ok {
+		return nil, fmt.Errorf(createStoreErrFmt, errReservedCallback)
+	}
+
+	keyed := cfg.Resolver == nil
+	if keyed {
+		cfg.Resolver = LastWriterWins
+	}
+
+	s := &Store{
+		host:     cfg.BMMC.Host,
+		resolver: cfg.Resolver,
+		keyed:    keyed,
+		mux:      &sync.RWMutex{},
+		records:  map[string]Record{},
+		watchers: map[int]func(Record){},
+	}
+
+	bmmcCfg := *cfg.BMMC
+	bmmcCfg.Callbacks = maps.Clone(cfg.BMMC.Callbacks)
+
+	if bmmcCfg.Callbacks == nil {
+		bmmcCfg.Callbacks = map[string]func(any, *slog.Logger) error{}
+	}
+
+	bmmcCfg.Callbacks[CallbackType] = s.callback
+
+	b, err := bmmc.New(&bmmcCfg)
+	if err != nil {
+		return nil, fmt.Errorf(createStoreErrFmt, err)
+	}
+
+	s.bmmc = b
+
+	return s, nil
+}
+
+// BMMC returns the bimodal multicast instance of the store.
+func (s *Store) BMMC() *bmmc.BMMC {
+	return s.bmmc
+}
+
+// Get returns the value of the given key and true if the key exists.
+func (s *Store) Get(key string) ([]byte, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	e, ok := s.records[key]
+	if !ok || e.Deleted {
+		return nil, false
+	}
+
+	return e.Value, true
+}
+
+// Set sets the value of the given key on all hosts.
+func (s *Store) Set(ctx context.Context, key string, value []byte) error {
+	if err := s.write(ctx, key, message{Value: value}); err != nil {
+		return fmt.Errorf(setErrFmt, key, err)
+	}
+
+	return nil
+}
+
+// Delete deletes the given key on all hosts.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if err := s.write(ctx, key, message{Deleted: true}); err != nil {
+		return fmt.Errorf(deleteErrFmt, key, err)
+	}
+
+	return nil
+}
+
+// Range calls fn for each key of the store, in sorted order, until fn returns false.
+func (s *Store) Range(fn func(key string, value []byte) bool) {
+	s.mux.RLock()
+
+	records := make([]Record, 0, len(s.records))
+
+	for _, e := range s.records {
+		if !e.Deleted {
+			records = append(records, e)
+		}
+	}
+
+	s.mux.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	for _, e := range records {
+		if !fn(e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// Watch calls fn with each record that changes the store, including the
+// tombstones of deleted keys. It returns a function that stops watching.
+func (s *Store) Watch(fn func(Record)) func() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.watchers[id] = fn
+
+	return func() {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		delete(s.watchers, id)
+	}
+}
+
+// write adds a new version of the given key.
+// The version is applied in the store by the callback of the message.
+func (s *Store) write(ctx context.Context, key string, msg message) error {
+	if !s.keyed {
+		msg.Key = key
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err //nolint: wrapcheck
+	}
+
+	// the message is sent as string, so it is the same on all hosts
+	if !s.keyed {
+		return s.bmmc.AddMessage(ctx, string(data), CallbackType) //nolint: wrapcheck
+	}
+
+	return s.bmmc.AddKeyedMessage(ctx, key, string(data), CallbackType) //nolint: wrapcheck
+}
+
+// callback applies the delivered version of a key.
+func (s *Store) callback(data any, _ *slog.Logger) error {
+	delivery, ok := data.(bmmc.Delivery)
+	if !ok {
+		return errCannotConvertToDelivery
+	}
+
+	raw, ok := delivery.Msg.(string)
+	if !ok {
+		return errInvalidMessage
+	}
+
+	var msg message
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		return fmt.Errorf(messageErrFmt, errInvalidMessage, err)
+	}
+
+	key := delivery.Key
+	if key == "" {
+		key = msg.Key
+	}
+
+	if key == "" {
+		return errInvalidMessage
+	}
+
+	s.apply(Record{
+		Key:       key,
+		Value:     msg.Value,
+		Deleted:   msg.Deleted,
+		Origin:    delivery.Origin,
+		Timestamp: delivery.OriginTime.UTC(),
+		ID:        delivery.ID,
+	})
+
+	return nil
+}
+
+// apply resolves the given version with the current version of its key and
+// notifies the watchers if the store changed.
+func (s *Store) apply(incoming Record) {
+	s.mux.Lock()
+
+	current, ok := s.records[incoming.Key]
+
+	record := incoming
+	if ok {
+		record = s.resolver(current, incoming)
+	}
+
+	if ok && record.ID == current.ID && record.Deleted == current.Deleted && bytes.Equal(record.Value, current.Value) {
+		s.mux.Unlock()
+
+		return
+	}
+
+	s.records[incoming.Key] = record
+
+	watchers := make([]func(Record), 0, len(s.watchers))
+	for _, fn := range s.watchers {
+		watchers = append(watchers, fn)
+	}
+
+	s.mux.Unlock()
+
+	for _, fn := range watchers {
+		fn(record)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+// newStore creates a store that sends messages in the given network.
+// The config of the store can be customized before the store is created.
+func newStore(network *memory.Network, name string, customize func(*Config)) (*Store, error) {
+	cfg := Config{
+		BMMC: &bmmc.Config{
+			Host:          network.Peer(name),
+			BufferSize:    64,
+			RoundDuration: 10 * time.Millisecond,
+			Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	if customize != nil {
+		customize(&cfg)
+	}
+
+	return New(cfg)
+}
+
+// newTestStore creates a store registered in the given network.
+func newTestStore(network *memory.Network, name string, customize func(*Config)) *Store {
+	s, err := newStore(network, name, customize)
+	Expect(err).ToNot(HaveOccurred())
+
+	network.Register(name, s.Handle)
+
+	return s
+}
+
+// newTestCluster creates a full mesh of started stores, named "n0", "n1", etc.
+func newTestCluster(network *memory.Network, size int, customize func(*Config)) []*Store {
+	stores, stop, err := memory.FullMesh(network, size, func(name string) (*Store, memory.MeshNode, error) {
+		s, err := newStore(network, name, customize)
+		if err != nil {
+			return nil, memory.MeshNode{}, err
+		}
+
+		return s, memory.MeshNode{Handler: s.Handle, Member: s.BMMC()}, nil
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	DeferCleanup(stop)
+
+	return stores
+}
+
+// withResolver sets the given resolver in the config of the stores.
+func withResolver(resolver Resolver) func(*Config) {
+	return func(cfg *Config) {
+		cfg.Resolver = resolver
+	}
+}
+
+// valueOf returns the value of the given key as string.
+func valueOf(s *Store, key string) func() string {
+	return func() string {
+		value, _ := s.Get(key)
+
+		return string(value)
+	}
+}
+
+var _ = Describe("Store", func() {
+	var (
+		ctx    context.Context
+		stores []*Store
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		stores = newTestCluster(memory.NewNetwork(), 3, nil)
+	})
+
+	It("replicates the values on all hosts", func() {
+		Expect(stores[0].Set(ctx, "color", []byte("red"))).To(Succeed())
+
+		for _, s := range stores {
+			Eventually(valueOf(s, "color")).Should(Equal("red"))
+		}
+
+		Expect(stores[1].Set(ctx, "color", []byte("blue"))).To(Succeed())
+
+		for _, s := range stores {
+			Eventually(valueOf(s, "color")).Should(Equal("blue"))
+		}
+	})
+
+	It("deletes the keys on all hosts", func() {
+		Expect(stores[0].Set(ctx, "color", []byte("red"))).To(Succeed())
+
+		for _, s := range stores {
+			Eventually(valueOf(s, "color")).Should(Equal("red"))
+		}
+
+		Expect(stores[2].Delete(ctx, "color")).To(Succeed())
+
+		for _, s := range stores {
+			Eventually(func() bool {
+				_, ok := s.Get("color")
+
+				return ok
+			}).Should(BeFalse())
+		}
+
+		Expect(stores[0].Snapshot()).To(ConsistOf(HaveField("Deleted", BeTrue())))
+	})
+
+	It("ranges over the keys in sorted order", func() {
+		Expect(stores[0].Set(ctx, "b", []byte("2"))).To(Succeed())
+		Expect(stores[0].Set(ctx, "a", []byte("1"))).To(Succeed())
+		Expect(stores[0].Set(ctx, "c", []byte("3"))).To(Succeed())
+		Expect(stores[0].Delete(ctx, "c")).To(Succeed())
+
+		var keys []string
+
+		stores[0].Range(func(key string, _ []byte) bool {
+			keys = append(keys, key)
+
+			return true
+		})
+		Expect(keys).To(Equal([]string{"a", "b"}))
+
+		keys = nil
+
+		stores[0].Range(func(key string, _ []byte) bool {
+			keys = append(keys, key)
+
+			return false
+		})
+		Expect(keys).To(Equal([]string{"a"}))
+	})
+
+	It("notifies the watchers about changes", func() {
+		var (
+			mux     sync.Mutex
+			changes []Record
+		)
+
+		cancel := stores[1].Watch(func(e Record) {
+			mux.Lock()
+			defer mux.Unlock()
+
+			changes = append(changes, e)
+		})
+
+		changesOf := func() []Record {
+			mux.Lock()
+			defer mux.Unlock()
+
+			return append([]Record{}, changes...)
+		}
+
+		Expect(stores[0].Set(ctx, "color", []byte("red"))).To(Succeed())
+		Eventually(changesOf).Should(HaveLen(1))
+
+		change := changesOf()[0]
+		Expect(change.Key).To(Equal("color"))
+		Expect(change.Value).To(Equal([]byte("red")))
+		Expect(change.Origin).To(Equal("n0"))
+
+		cancel()
+
+		Expect(stores[0].Set(ctx, "color", []byte("blue"))).To(Succeed())
+		Eventually(valueOf(stores[1], "color")).Should(Equal("blue"))
+		Expect(changesOf()).To(HaveLen(1))
+	})
+
+	It("converges with many concurrent writers", func() {
+		var wg sync.WaitGroup
+
+		keys := []string{"a", "b", "c", "d", "e"}
+
+		for i, s := range stores {
+			wg.Add(1)
+
+			go func(i int, s *Store) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for j := 0; j < 30; j++ {
+					key := keys[(i+j)%len(keys)]
+
+					var err error
+					if j%7 == 6 {
+						err = s.Delete(ctx, key)
+					} else {
+						err = s.Set(ctx, key, []byte(fmt.Sprintf("n%d-%d", i, j)))
+					}
+
+					// a newer version of the key may be already received from another writer
+					if err != nil && !errors.Is(err, bmmc.ErrStaleMessage) {
+						Expect(err).ToNot(HaveOccurred())
+					}
+				}
+			}(i, s)
+		}
+
+		wg.Wait()
+
+		converged := func() bool {
+			for _, s := range stores[1:] {
+				if !reflect.DeepEqual(s.Snapshot(), stores[0].Snapshot()) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+		Eventually(converged, 5*time.Second).Should(BeTrue())
+		Expect(stores[0].Snapshot()).To(HaveLen(len(keys)))
+	})
+
+	It("resolves the conflicts with the given resolver", func() {
+		// keeps the greater value, whatever the version
+		greater := func(current, incoming Record) Record {
+			if bytes.Compare(incoming.Value, current.Value) > 0 {
+				return incoming
+			}
+
+			return current
+		}
+
+		stores = newTestCluster(memory.NewNetwork(), 2, withResolver(greater))
+
+		Expect(stores[0].Set(ctx, "color", []byte("red"))).To(Succeed())
+		Eventually(valueOf(stores[1], "color")).Should(Equal("red"))
+
+		Expect(stores[1].Set(ctx, "color", []byte("blue"))).To(Succeed())
+		Consistently(valueOf(stores[0], "color"), 100*time.Millisecond).Should(Equal("red"))
+		Expect(valueOf(stores[1], "color")()).To(Equal("red"))
+	})
+
+	It("converges with many concurrent writers and the given resolver", func() {
+		// keeps the greater value, whatever the version, so the last writer
+		// (by timestamp) doesn't win
+		greater := func(current, incoming Record) Record {
+			if bytes.Compare(incoming.Value, current.Value) > 0 {
+				return incoming
+			}
+
+			return current
+		}
+
+		stores = newTestCluster(memory.NewNetwork(), 3, withResolver(greater))
+
+		var wg sync.WaitGroup
+
+		keys := []string{"a", "b"}
+
+		for i, s := range stores {
+			wg.Add(1)
+
+			go func(i int, s *Store) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				// the last writes of n0 are the lowest values
+				for j := 0; j < 10; j++ {
+					value := fmt.Sprintf("%02d-n%d", (i+1)*(10-j), i)
+					Expect(s.Set(ctx, keys[j%len(keys)], []byte(value))).To(Succeed())
+				}
+			}(i, s)
+		}
+
+		wg.Wait()
+
+		for _, s := range stores {
+			Eventually(valueOf(s, "a"), 5*time.Second).Should(Equal("30-n2"))
+			Eventually(valueOf(s, "b"), 5*time.Second).Should(Equal("27-n2"))
+		}
+
+		for _, s := range stores[1:] {
+			Expect(s.Snapshot()).To(Equal(stores[0].Snapshot()))
+		}
+	})
+
+	It("returns error when the callback type is reserved", func() {
+		_, err := New(Config{
+			BMMC: &bmmc.Config{
+				Host:       memory.NewNetwork().Peer("n0"),
+				BufferSize: 25,
+				Callbacks: map[string]func(any, *slog.Logger) error{
+					CallbackType: func(any, *slog.Logger) error { return nil },
+				},
+			},
+		})
+		Expect(err).To(MatchError(errReservedCallback))
+	})
+
+	It("returns error when the bmmc config is missing", func() {
+		_, err := New(Config{})
+		Expect(err).To(MatchError(errNilBMMCConfig))
+	})
+})
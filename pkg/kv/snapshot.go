@@ -0,0 +1,103 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
+)
+
+// SnapshotRoute is the route for snapshot requests of joining hosts.
+const SnapshotRoute = "/kv/snapshot"
+
+const bootstrapErrFmt = "error at bootstrapping from peer %s: %w"
+
+var errHostCannotRequest = errors.New("host must implement Request for bootstrapping")
+
+// Snapshot returns all records of the store, including the tombstones of
+// deleted keys, in sorted order of keys.
+func (s *Store) Snapshot() []Record {
+	s.mux.RLock()
+
+	records := make([]Record, 0, len(s.records))
+	for _, e := range s.records {
+		records = append(records, e)
+	}
+
+	s.mux.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	return records
+}
+
+// Restore merges the records of a snapshot in the store.
+// The records are resolved like the versions received from peers.
+func (s *Store) Restore(records []Record) {
+	for _, e := range records {
+		if e.Key != "" {
+			s.apply(e)
+		}
+	}
+}
+
+// Handle handles a message received by the host server on the given route.
+// It replies to the snapshot requests and passes the other messages to the
+// bimodal multicast instance of the store. Snapshot requests are rejected like
+// the messages of the protocol (e.g. when they are not authenticated).
+func (s *Store) Handle(ctx context.Context, route string, body []byte) ([]byte, error) {
+	if route != SnapshotRoute {
+		return s.bmmc.Handle(ctx, route, body) //nolint: wrapcheck
+	}
+
+	//nolint: wrapcheck
+	return s.bmmc.HandleRequest(ctx, route, body, func(string, []byte) ([]byte, error) {
+		return json.Marshal(s.Snapshot())
+	})
+}
+
+// Bootstrap requests a snapshot from the given peer and merges it in the store.
+// A joining host calls it to receive the records that are not in the buffers
+// of its peers anymore. The host of the store must implement Request and the
+// host server of the peer must route the SnapshotRoute to Handle.
+// The snapshot is signed, encrypted and authenticated like the messages of the
+// protocol, when configured, and it is rejected before being decoded when it is
+// larger than the Limits.MaxBodyBytes of the store.
+func (s *Store) Bootstrap(ctx context.Context, p string) error {
+	if _, ok := s.host.(peer.Requester); !ok {
+		return fmt.Errorf(bootstrapErrFmt, p, errHostCannotRequest)
+	}
+
+	resp, err := s.bmmc.Request(ctx, SnapshotRoute, p, nil)
+	if err != nil {
+		return fmt.Errorf(bootstrapErrFmt, p, err)
+	}
+
+	var records []Record
+	if err = json.Unmarshal(resp, &records); err != nil {
+		return fmt.Errorf(bootstrapErrFmt, p, err)
+	}
+
+	s.Restore(records)
+
+	return nil
+}
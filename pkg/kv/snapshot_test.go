@@ -0,0 +1,159 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+	"github.com/rstefan1/bimodal-multicast/pkg/transport/memory"
+)
+
+// fakeHost is a host that cannot send requests.
+type fakeHost struct{}
+
+func (fakeHost) String() string                   { return "fake" }
+func (fakeHost) Send(_ []byte, _, _ string) error { return nil }
+
+var _ = Describe("Snapshot", func() {
+	It("bootstraps a joining host from a peer", func() {
+		network := memory.NewNetwork()
+		stores := newTestCluster(network, 2, nil)
+
+		Expect(stores[0].Set(context.Background(), "color", []byte("red"))).To(Succeed())
+		Expect(stores[0].Set(context.Background(), "size", []byte("big"))).To(Succeed())
+		Expect(stores[0].Delete(context.Background(), "size")).To(Succeed())
+
+		Eventually(valueOf(stores[1], "color")).Should(Equal("red"))
+		Eventually(func() int { return len(stores[1].Snapshot()) }).Should(Equal(2))
+
+		joiner := newTestStore(network, "n2", nil)
+		Expect(joiner.Bootstrap(context.Background(), "n1")).To(Succeed())
+		Expect(joiner.Snapshot()).To(Equal(stores[0].Snapshot()))
+		Expect(valueOf(joiner, "color")()).To(Equal("red"))
+	})
+
+	It("keeps the newer versions when restoring a snapshot", func() {
+		s := newTestStore(memory.NewNetwork(), "n0", nil)
+
+		now := time.Now()
+		s.Restore([]Record{{Key: "color", Value: []byte("blue"), Origin: "n1", Timestamp: now, ID: "2"}})
+		s.Restore([]Record{{Key: "color", Value: []byte("red"), Origin: "n1", Timestamp: now.Add(-time.Second), ID: "1"}})
+
+		Expect(valueOf(s, "color")()).To(Equal("blue"))
+	})
+
+	It("returns error when the host cannot send requests", func() {
+		s, err := New(Config{BMMC: &bmmc.Config{Host: fakeHost{}, BufferSize: 25}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(s.Bootstrap(context.Background(), "n1")).To(MatchError(errHostCannotRequest))
+	})
+
+	It("returns error when the context is done", func() {
+		network := memory.NewNetwork()
+		newTestCluster(network, 1, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		joiner := newTestStore(network, "n1", nil)
+		Expect(joiner.Bootstrap(ctx, "n0")).To(MatchError(context.Canceled))
+	})
+
+	It("rejects the snapshots larger than the body limit", func() {
+		network := memory.NewNetwork()
+		stores := newTestCluster(network, 1, nil)
+		Expect(stores[0].Set(context.Background(), "color", bytes.Repeat([]byte("x"), 1024))).To(Succeed())
+
+		joiner := newTestStore(network, "n1", func(cfg *Config) {
+			cfg.BMMC.Limits = bmmc.Limits{MaxBodyBytes: 512}
+		})
+		Expect(joiner.Bootstrap(context.Background(), "n0")).To(MatchError(bmmc.ErrLimitExceeded))
+		Expect(joiner.Snapshot()).To(BeEmpty())
+	})
+
+	It("rejects the snapshot requests that are not authenticated", func() {
+		network := memory.NewNetwork()
+		withAuthKey := func(key string) func(*Config) {
+			return func(cfg *Config) {
+				cfg.BMMC.AuthKeys = map[string][]byte{"k1": []byte(key)}
+				cfg.BMMC.AuthKeyID = "k1"
+			}
+		}
+
+		stores := newTestCluster(network, 1, withAuthKey("secret"))
+		Expect(stores[0].Set(context.Background(), "color", []byte("red"))).To(Succeed())
+
+		_, err := stores[0].Handle(context.Background(), SnapshotRoute, []byte(`{"payload":"e30="}`))
+		Expect(err).To(MatchError(bmmc.ErrUnauthenticated))
+
+		intruder := newTestStore(network, "n8", withAuthKey("guess"))
+		Expect(intruder.Bootstrap(context.Background(), "n0")).To(MatchError(bmmc.ErrUnauthenticated))
+		Expect(intruder.Snapshot()).To(BeEmpty())
+
+		joiner := newTestStore(network, "n9", withAuthKey("secret"))
+		Expect(joiner.Bootstrap(context.Background(), "n0")).To(Succeed())
+		Expect(valueOf(joiner, "color")()).To(Equal("red"))
+	})
+
+	It("accepts only the snapshots signed by the requested peer", func() {
+		network := memory.NewNetwork()
+		keys := map[string]ed25519.PrivateKey{}
+		trusted := bmmc.NewKeyRing(nil)
+
+		for _, name := range []string{"n0", "n1", "n9"} {
+			public, private, err := ed25519.GenerateKey(nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			keys[name] = private
+			trusted.Set(name, public)
+		}
+
+		withSigning := func(cfg *Config) {
+			cfg.BMMC.PrivateKey = keys[cfg.BMMC.Host.String()]
+			cfg.BMMC.TrustedKeys = trusted
+		}
+
+		stores := newTestCluster(network, 2, withSigning)
+		Expect(stores[0].Set(context.Background(), "color", []byte("red"))).To(Succeed())
+		Eventually(valueOf(stores[1], "color")).Should(Equal("red"))
+
+		// n1 replies to the snapshot requests sent to n0
+		network.Register("n0", stores[1].Handle)
+
+		joiner := newTestStore(network, "n9", withSigning)
+		Expect(joiner.Bootstrap(context.Background(), "n0")).To(MatchError(bmmc.ErrRejectedSender))
+		Expect(joiner.Snapshot()).To(BeEmpty())
+
+		Expect(joiner.Bootstrap(context.Background(), "n1")).To(Succeed())
+		Expect(valueOf(joiner, "color")()).To(Equal("red"))
+
+		// hosts without trusted keys cannot request snapshots
+		untrusted := newTestStore(network, "n8", func(cfg *Config) {
+			withSigning(cfg)
+			cfg.BMMC.PrivateKey = nil
+		})
+		Expect(untrusted.Bootstrap(context.Background(), "n1")).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,298 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+)
+
+const writeMetricsErrFmt = "error at writing metrics: %w"
+
+// DefaultBuckets are the default upper bounds of histogram buckets, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10} //nolint: gochecknoglobals
+
+// HopBuckets are the default upper bounds of the buckets of bmmc.MetricDeliveryHops.
+var HopBuckets = []float64{1, 2, 3, 4, 5, 6, 8, 10, 15, 20} //nolint: gochecknoglobals
+
+// histogram holds the observations of a histogram.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// series identifies a metric by its name and its labels,
+// formatted as in Prometheus text format (e.g. topic="news").
+type series struct {
+	name   string
+	labels string
+}
+
+// Registry stores metrics in memory and exports them in Prometheus text format.
+// It can be used as metrics of the protocol and mounted on a HTTP mux.
+type Registry struct {
+	mux *sync.Mutex
+	// labels are added to the metrics reported by the registry
+	labels     string
+	buckets    []float64
+	custom     map[string][]float64
+	counters   map[series]float64
+	gauges     map[series]float64
+	histograms map[series]*histogram
+}
+
+// NewRegistry creates a Registry. Histograms use the given bucket upper
+// bounds, or DefaultBuckets when none are given, except bmmc.MetricDeliveryHops,
+// which uses HopBuckets. Use SetBuckets to change the buckets of a histogram.
+func NewRegistry(buckets ...float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	r := &Registry{
+		mux:        &sync.Mutex{},
+		buckets:    sortedBuckets(buckets),
+		custom:     map[string][]float64{},
+		counters:   map[series]float64{},
+		gauges:     map[series]float64{},
+		histograms: map[series]*histogram{},
+	}
+
+	r.SetBuckets(bmmc.MetricDeliveryHops, HopBuckets...)
+
+	return r
+}
+
+// WithLabel returns a view of the registry which adds the given label to the
+// reported metrics, e.g. the topic of the metrics of bmmc.Topics. The metrics
+// are stored and exported by the registry.
+func (r *Registry) WithLabel(key, value string) bmmc.Metrics {
+	labeled := *r
+	labeled.labels = withLabel(r.labels, key, value)
+
+	return &labeled
+}
+
+// SetBuckets sets the bucket upper bounds of the given histogram.
+// The observations of the histogram are discarded.
+func (r *Registry) SetBuckets(name string, buckets ...float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.custom[name] = sortedBuckets(buckets)
+
+	for s := range r.histograms {
+		if s.name == name {
+			delete(r.histograms, s)
+		}
+	}
+}
+
+// AddCounter adds the given delta to a counter.
+func (r *Registry) AddCounter(name string, delta float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.counters[r.series(name)] += delta
+}
+
+// SetGauge sets the value of a gauge.
+func (r *Registry) SetGauge(name string, value float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.gauges[r.series(name)] = value
+}
+
+// ObserveHistogram adds an observation to a histogram.
+func (r *Registry) ObserveHistogram(name string, value float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	h, ok := r.histograms[r.series(name)]
+	if !ok {
+		buckets, custom := r.custom[name]
+		if !custom {
+			buckets = r.buckets
+		}
+
+		h = &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		r.histograms[r.series(name)] = h
+	}
+
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			h.counts[i]++
+		}
+	}
+
+	h.count++
+	h.sum += value
+}
+
+// WriteTo writes all metrics in Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	writeValues(cw, "counter", r.counters)
+	writeValues(cw, "gauge", r.gauges)
+
+	histograms := sortedSeries(r.histograms)
+
+	for i, s := range histograms {
+		h := r.histograms[s]
+
+		if i == 0 || histograms[i-1].name != s.name {
+			fmt.Fprintf(cw, "# TYPE %s histogram\n", s.name)
+		}
+
+		for j, upperBound := range h.buckets {
+			fmt.Fprintf(cw, "%s_bucket{%s} %d\n", s.name, withLabel(s.labels, "le", formatFloat(upperBound)), h.counts[j])
+		}
+
+		fmt.Fprintf(cw, "%s_bucket{%s} %d\n", s.name, withLabel(s.labels, "le", "+Inf"), h.count)
+		fmt.Fprintf(cw, "%s %s\n", s.withSuffix("_sum"), formatFloat(h.sum))
+		fmt.Fprintf(cw, "%s %d\n", s.withSuffix("_count"), h.count)
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+
+	if cw.err != nil {
+		return cw.n, fmt.Errorf(writeMetricsErrFmt, cw.err)
+	}
+
+	return cw.n, nil
+}
+
+// ServeHTTP writes all metrics in Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	r.WriteTo(w) //nolint: errcheck
+}
+
+// series returns the series of the given metric, with the labels of the registry.
+func (r *Registry) series(name string) series {
+	return series{name: name, labels: r.labels}
+}
+
+// withSuffix returns the series formatted as in Prometheus text format,
+// with the given suffix added to its name.
+func (s series) withSuffix(suffix string) string {
+	if s.labels == "" {
+		return s.name + suffix
+	}
+
+	return s.name + suffix + "{" + s.labels + "}"
+}
+
+// writeValues writes the given counters or gauges in Prometheus text format.
+func writeValues(cw *countingWriter, typ string, values map[series]float64) {
+	keys := sortedSeries(values)
+
+	for i, s := range keys {
+		if i == 0 || keys[i-1].name != s.name {
+			fmt.Fprintf(cw, "# TYPE %s %s\n", s.name, typ)
+		}
+
+		fmt.Fprintf(cw, "%s %s\n", s.withSuffix(""), formatFloat(values[s]))
+	}
+}
+
+// withLabel returns the given labels with the given label added.
+func withLabel(labels, key, value string) string {
+	label := fmt.Sprintf("%s=%q", key, value)
+	if labels == "" {
+		return label
+	}
+
+	return labels + "," + label
+}
+
+// countingWriter counts the written bytes and keeps the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+
+	return n, err //nolint: wrapcheck
+}
+
+// sortedBuckets returns a sorted copy of the given bucket upper bounds.
+func sortedBuckets(buckets []float64) []float64 {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	return buckets
+}
+
+// sortedSeries returns the series of given map, sorted by name and labels.
+func sortedSeries[V any](m map[series]V) []series {
+	keys := make([]series, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.SortFunc(keys, func(a, b series) int {
+		if a.name != b.name {
+			return strings.Compare(a.name, b.name)
+		}
+
+		return strings.Compare(a.labels, b.labels)
+	})
+
+	return keys
+}
+
+// formatFloat formats a value as expected by Prometheus.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
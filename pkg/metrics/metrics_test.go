@@ -0,0 +1,139 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/bmmc"
+)
+
+var _ bmmc.LabeledMetrics = &Registry{}
+
+var _ = Describe("Registry", func() {
+	var r *Registry
+
+	BeforeEach(func() {
+		r = NewRegistry(0.1, 1)
+	})
+
+	It("writes metrics in Prometheus text format", func() {
+		r.AddCounter(bmmc.MetricGossipsSent, 1)
+		r.AddCounter(bmmc.MetricGossipsSent, 2)
+		r.SetGauge(bmmc.MetricPeers, 5)
+		r.SetGauge(bmmc.MetricPeers, 4)
+		r.ObserveHistogram(bmmc.MetricRoundDuration, 0.05)
+		r.ObserveHistogram(bmmc.MetricRoundDuration, 0.5)
+		r.ObserveHistogram(bmmc.MetricRoundDuration, 2)
+
+		var sb strings.Builder
+
+		n, err := r.WriteTo(&sb)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(int64(sb.Len())))
+		Expect(sb.String()).To(Equal(`# TYPE bmmc_gossips_sent_total counter
+bmmc_gossips_sent_total 3
+# TYPE bmmc_peers gauge
+bmmc_peers 4
+# TYPE bmmc_round_duration_seconds histogram
+bmmc_round_duration_seconds_bucket{le="0.1"} 1
+bmmc_round_duration_seconds_bucket{le="1"} 2
+bmmc_round_duration_seconds_bucket{le="+Inf"} 3
+bmmc_round_duration_seconds_sum 2.55
+bmmc_round_duration_seconds_count 3
+`))
+	})
+
+	It("uses hop buckets for delivery hops", func() {
+		r = NewRegistry()
+		r.ObserveHistogram(bmmc.MetricDeliveryHops, 2)
+		r.ObserveHistogram(bmmc.MetricDeliveryHops, 7)
+
+		var sb strings.Builder
+
+		_, err := r.WriteTo(&sb)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sb.String()).To(ContainSubstring(`bmmc_delivery_hops_bucket{le="1"} 0
+bmmc_delivery_hops_bucket{le="2"} 1
+`))
+		Expect(sb.String()).To(ContainSubstring(`bmmc_delivery_hops_bucket{le="8"} 2
+`))
+		Expect(sb.String()).ToNot(ContainSubstring(`bmmc_delivery_hops_bucket{le="0.005"}`))
+	})
+
+	It("uses the buckets set for a histogram", func() {
+		r.ObserveHistogram(bmmc.MetricRoundDuration, 0.5)
+		r.SetBuckets(bmmc.MetricRoundDuration, 10, 5)
+		r.ObserveHistogram(bmmc.MetricRoundDuration, 7)
+		r.ObserveHistogram(bmmc.MetricBufferSize, 0.5)
+
+		var sb strings.Builder
+
+		_, err := r.WriteTo(&sb)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sb.String()).To(ContainSubstring(`bmmc_round_duration_seconds_bucket{le="5"} 0
+bmmc_round_duration_seconds_bucket{le="10"} 1
+bmmc_round_duration_seconds_bucket{le="+Inf"} 1
+`))
+		Expect(sb.String()).To(ContainSubstring(`bmmc_buffer_size_bucket{le="1"} 1
+`))
+	})
+
+	It("writes the labeled metrics", func() {
+		r.AddCounter(bmmc.MetricGossipsSent, 1)
+		r.WithLabel("topic", "news").AddCounter(bmmc.MetricGossipsSent, 2)
+		r.WithLabel("topic", "news").SetGauge(bmmc.MetricPeers, 3)
+		r.WithLabel("topic", "news").ObserveHistogram(bmmc.MetricRoundDuration, 0.5)
+
+		var sb strings.Builder
+
+		_, err := r.WriteTo(&sb)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sb.String()).To(Equal(`# TYPE bmmc_gossips_sent_total counter
+bmmc_gossips_sent_total 1
+bmmc_gossips_sent_total{topic="news"} 2
+# TYPE bmmc_peers gauge
+bmmc_peers{topic="news"} 3
+# TYPE bmmc_round_duration_seconds histogram
+bmmc_round_duration_seconds_bucket{topic="news",le="0.1"} 0
+bmmc_round_duration_seconds_bucket{topic="news",le="1"} 1
+bmmc_round_duration_seconds_bucket{topic="news",le="+Inf"} 1
+bmmc_round_duration_seconds_sum{topic="news"} 0.5
+bmmc_round_duration_seconds_count{topic="news"} 1
+`))
+	})
+
+	It("serves metrics over HTTP", func() {
+		r.AddCounter(bmmc.MetricSendErrors, 1)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+
+		body, err := io.ReadAll(rec.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(ContainSubstring("bmmc_send_errors_total 1\n"))
+	})
+})
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const sendErrFmt = "error at sending message to peer %s: %w"
+
+var errUnknownPeer = errors.New("unknown peer")
+
+// Handler handles a message received on the given route and returns the response body.
+// The Handle method of a bimodal multicast server is a Handler.
+type Handler func(ctx context.Context, route string, body []byte) ([]byte, error)
+
+// Network is an in-memory network of peers.
+type Network struct {
+	handlers map[string]Handler
+	mux      *sync.RWMutex
+}
+
+// NewNetwork creates an in-memory network.
+func NewNetwork() *Network {
+	return &Network{
+		handlers: map[string]Handler{},
+		mux:      &sync.RWMutex{},
+	}
+}
+
+// Register registers the handler of the peer with the given name.
+func (n *Network) Register(name string, h Handler) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.handlers[name] = h
+}
+
+// Unregister removes the peer with the given name from network.
+// Messages sent to the removed peer will fail.
+func (n *Network) Unregister(name string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	delete(n.handlers, name)
+}
+
+// Peer returns the peer with the given name.
+func (n *Network) Peer(name string) *Peer {
+	return &Peer{
+		name:    name,
+		network: n,
+	}
+}
+
+// deliver calls the handler of the given peer.
+func (n *Network) deliver(msg []byte, route string, peerToSend string) ([]byte, error) {
+	n.mux.RLock()
+	h, ok := n.handlers[peerToSend]
+	n.mux.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf(sendErrFmt, peerToSend, errUnknownPeer)
+	}
+
+	resp, err := h(context.Background(), route, msg)
+	if err != nil {
+		return nil, fmt.Errorf(sendErrFmt, peerToSend, err)
+	}
+
+	return resp, nil
+}
+
+// Peer is a peer from an in-memory network.
+type Peer struct {
+	name    string
+	network *Network
+}
+
+// String returns the name of the peer.
+func (p *Peer) String() string {
+	return p.name
+}
+
+// Send sends a message to the given peer.
+func (p *Peer) Send(msg []byte, route string, peerToSend string) error {
+	_, err := p.network.deliver(msg, route, peerToSend)
+
+	return err
+}
+
+// Request sends a message to the given peer and returns its response body.
+func (p *Peer) Request(msg []byte, route string, peerToSend string) ([]byte, error) {
+	return p.network.deliver(msg, route, peerToSend)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory Network", func() {
+	var (
+		network  *Network
+		received []string
+	)
+
+	BeforeEach(func() {
+		network = NewNetwork()
+		received = []string{}
+
+		network.Register("n2", func(_ context.Context, route string, body []byte) ([]byte, error) {
+			received = append(received, route+" "+string(body))
+
+			if route == "/fail" {
+				return nil, errors.New("handler error") //nolint: goerr113
+			}
+
+			return []byte("reply"), nil
+		})
+	})
+
+	It("sends messages to registered peers", func() {
+		p := network.Peer("n1")
+
+		Expect(p.String()).To(Equal("n1"))
+		Expect(p.Send([]byte("msg"), "/route", "n2")).To(Succeed())
+		Expect(received).To(Equal([]string{"/route msg"}))
+	})
+
+	It("returns the response of requests", func() {
+		resp, err := network.Peer("n1").Request([]byte("msg"), "/route", "n2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp).To(Equal([]byte("reply")))
+	})
+
+	It("returns handler errors", func() {
+		Expect(network.Peer("n1").Send([]byte("msg"), "/fail", "n2")).To(MatchError(ContainSubstring("handler error")))
+	})
+
+	It("returns error for unknown peers", func() {
+		Expect(network.Peer("n1").Send([]byte("msg"), "/route", "n3")).To(MatchError(errUnknownPeer))
+
+		network.Unregister("n2")
+		Expect(network.Peer("n1").Send([]byte("msg"), "/route", "n2")).To(MatchError(errUnknownPeer))
+	})
+})
@@ -0,0 +1,99 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"fmt"
+)
+
+const meshErrFmt = "error at creating the node %s of the mesh: %w"
+
+// Member is a member of a mesh, e.g. a bimodal multicast server.
+type Member interface {
+	AddPeer(p string) error
+	Start() error
+	Stop()
+}
+
+// MeshNode is a node of a mesh: the handler of its messages and its member.
+type MeshNode struct {
+	Handler Handler
+	Member  Member
+}
+
+// FullMesh creates a full mesh of the given size in the network, with the nodes
+// named "n0", "n1", etc. The given function creates the node with the given name.
+// Each node is registered in the network, gets all other nodes as peers and is
+// started. FullMesh returns the nodes and a function that stops them.
+func FullMesh[N any](n *Network, size int, newNode func(name string) (N, MeshNode, error)) ([]N, func(), error) {
+	nodes := make([]N, size)
+	meshNodes := make([]MeshNode, size)
+
+	for i := range nodes {
+		name := meshNodeName(i)
+
+		node, meshNode, err := newNode(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf(meshErrFmt, name, err)
+		}
+
+		n.Register(name, meshNode.Handler)
+
+		nodes[i] = node
+		meshNodes[i] = meshNode
+	}
+
+	started := make([]Member, 0, size)
+
+	stop := func() {
+		for _, m := range started {
+			m.Stop()
+		}
+	}
+
+	for i, meshNode := range meshNodes {
+		if err := startMeshNode(meshNode.Member, i, size); err != nil {
+			stop()
+
+			return nil, nil, fmt.Errorf(meshErrFmt, meshNodeName(i), err)
+		}
+
+		started = append(started, meshNode.Member)
+	}
+
+	return nodes, stop, nil
+}
+
+// startMeshNode adds all other nodes of the mesh as peers of the i-th member and starts it.
+func startMeshNode(m Member, i int, size int) error {
+	for j := 0; j < size; j++ {
+		if i == j {
+			continue
+		}
+
+		if err := m.AddPeer(meshNodeName(j)); err != nil {
+			return err //nolint: wrapcheck
+		}
+	}
+
+	return m.Start() //nolint: wrapcheck
+}
+
+// meshNodeName returns the name of the i-th node of a mesh.
+func meshNodeName(i int) string {
+	return fmt.Sprintf("n%d", i)
+}
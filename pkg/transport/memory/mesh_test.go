@@ -0,0 +1,118 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var errStart = errors.New("cannot start")
+
+// fakeMember records its peers and whether it is running.
+type fakeMember struct {
+	name     string
+	peers    []string
+	running  bool
+	startErr error
+}
+
+func (m *fakeMember) AddPeer(p string) error {
+	m.peers = append(m.peers, p)
+
+	return nil
+}
+
+func (m *fakeMember) Start() error {
+	if m.startErr != nil {
+		return m.startErr
+	}
+
+	m.running = true
+
+	return nil
+}
+
+func (m *fakeMember) Stop() {
+	m.running = false
+}
+
+var _ = Describe("Full Mesh", func() {
+	var (
+		network *Network
+		members []*fakeMember
+	)
+
+	newMember := func(failing string) func(name string) (*fakeMember, MeshNode, error) {
+		return func(name string) (*fakeMember, MeshNode, error) {
+			m := &fakeMember{name: name}
+			if name == failing {
+				m.startErr = errStart
+			}
+
+			members = append(members, m)
+
+			handler := func(_ context.Context, _ string, _ []byte) ([]byte, error) {
+				return []byte(name), nil
+			}
+
+			return m, MeshNode{Handler: handler, Member: m}, nil
+		}
+	}
+
+	BeforeEach(func() {
+		network = NewNetwork()
+		members = nil
+	})
+
+	It("registers, connects and starts all nodes", func() {
+		nodes, stop, err := FullMesh(network, 3, newMember(""))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodes).To(Equal(members))
+
+		Expect(nodes[0].peers).To(Equal([]string{"n1", "n2"}))
+		Expect(nodes[1].peers).To(Equal([]string{"n0", "n2"}))
+		Expect(nodes[2].peers).To(Equal([]string{"n0", "n1"}))
+
+		resp, err := network.Peer("n0").Request(nil, "/route", "n2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp).To(Equal([]byte("n2")))
+
+		for _, n := range nodes {
+			Expect(n.running).To(BeTrue())
+		}
+
+		stop()
+
+		for _, n := range nodes {
+			Expect(n.running).To(BeFalse())
+		}
+	})
+
+	It("stops the started nodes when a node cannot start", func() {
+		_, _, err := FullMesh(network, 3, newMember("n1"))
+		Expect(err).To(MatchError(errStart))
+		Expect(err).To(MatchError(ContainSubstring("n1")))
+
+		for _, m := range members {
+			Expect(m.running).To(BeFalse())
+		}
+	})
+})
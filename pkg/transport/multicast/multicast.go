@@ -0,0 +1,256 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multicast
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/rstefan1/bimodal-multicast/pkg/internal/peer"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// maxDatagramSize is the maximum payload of an UDP datagram.
+	maxDatagramSize = 65507
+
+	// routeSeparator separates the route and the sender from the message in a datagram.
+	routeSeparator = '\n'
+
+	resolveGroupErrFmt  = "error at resolving multicast group %s: %w"
+	findInterfaceErrFmt = "error at finding network interface %s: %w"
+	dialGroupErrFmt     = "error at dialing multicast group %s: %w"
+	setInterfaceErrFmt  = "error at setting multicast interface %s: %w"
+	joinGroupErrFmt     = "error at joining multicast group %s: %w"
+	writeDatagramErrFmt = "error at writing datagram to multicast group %s: %w"
+	setReadBufferErrFmt = "error at setting read buffer size: %w"
+)
+
+var (
+	errNilUnicastPeer    = errors.New("unicast peer must not be nil")
+	errNotMulticastGroup = errors.New("address is not a multicast group")
+	errTooLargeDatagram  = errors.New("message is too large for an UDP datagram")
+	errInvalidDatagram   = errors.New("invalid datagram")
+)
+
+// Config is the config for the multicast peer.
+type Config struct {
+	// Unicast is the peer used for solicitation and synchronization replies.
+	// Required.
+	Unicast peer.Peer
+	// Group is the multicast group address (e.g. 239.0.0.1:9999).
+	// Required.
+	Group string
+	// Interface is the name of the network interface used to join the group
+	// and to send datagrams to the group.
+	// Optional. When empty, the system default interface is used.
+	Interface string
+	// ReadBufferSize is the size of the socket receive buffer.
+	// Optional.
+	ReadBufferSize int
+}
+
+// Peer decorates a Peer with an UDP multicast group.
+// New messages are disseminated to the multicast group, while solicitation
+// and synchronization replies are sent with the unicast peer.
+type Peer struct {
+	unicast        peer.Peer
+	group          *net.UDPAddr
+	iface          *net.Interface
+	conn           *net.UDPConn
+	readBufferSize int
+}
+
+// NewPeer creates a multicast Peer.
+func NewPeer(cfg Config) (*Peer, error) {
+	if cfg.Unicast == nil {
+		return nil, errNilUnicastPeer
+	}
+
+	group, err := net.ResolveUDPAddr("udp", cfg.Group)
+	if err != nil {
+		return nil, fmt.Errorf(resolveGroupErrFmt, cfg.Group, err)
+	}
+
+	if !group.IP.IsMulticast() {
+		return nil, fmt.Errorf(resolveGroupErrFmt, cfg.Group, errNotMulticastGroup)
+	}
+
+	var iface *net.Interface
+
+	if cfg.Interface != "" {
+		iface, err = net.InterfaceByName(cfg.Interface)
+		if err != nil {
+			return nil, fmt.Errorf(findInterfaceErrFmt, cfg.Interface, err)
+		}
+	}
+
+	conn, err := net.DialUDP("udp", nil, group)
+	if err != nil {
+		return nil, fmt.Errorf(dialGroupErrFmt, cfg.Group, err)
+	}
+
+	if iface != nil {
+		if err := setMulticastInterface(conn, group, iface); err != nil {
+			conn.Close() //nolint: errcheck
+
+			return nil, fmt.Errorf(setInterfaceErrFmt, cfg.Interface, err)
+		}
+	}
+
+	return &Peer{
+		unicast:        cfg.Unicast,
+		group:          group,
+		iface:          iface,
+		conn:           conn,
+		readBufferSize: cfg.ReadBufferSize,
+	}, nil
+}
+
+// String returns the unicast peer as string.
+func (p *Peer) String() string {
+	return p.unicast.String()
+}
+
+// Send sends a request to the given peer using the unicast peer.
+func (p *Peer) Send(msg []byte, route string, peerToSend string) error {
+	return p.unicast.Send(msg, route, peerToSend) //nolint: wrapcheck
+}
+
+// Multicast sends a message to all members of the multicast group.
+func (p *Peer) Multicast(msg []byte, route string) error {
+	datagram, err := encodeDatagram(msg, route, p.unicast.String())
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.conn.Write(datagram); err != nil {
+		return fmt.Errorf(writeDatagramErrFmt, p.group, err)
+	}
+
+	return nil
+}
+
+// Listen joins the multicast group and calls the given handler for each
+// datagram received from other members, until the stop channel is closed.
+// The handler receives the source address of the datagram, which can be passed
+// to bmmc.ContextWithSource. The datagrams sent by this peer and looped back by
+// the system are ignored.
+func (p *Peer) Listen(stop <-chan struct{}, handler func(route string, body []byte, source string)) error {
+	conn, err := net.ListenMulticastUDP("udp", p.iface, p.group)
+	if err != nil {
+		return fmt.Errorf(joinGroupErrFmt, p.group, err)
+	}
+
+	if p.readBufferSize > 0 {
+		if err := conn.SetReadBuffer(p.readBufferSize); err != nil {
+			conn.Close() //nolint: errcheck
+
+			return fmt.Errorf(setReadBufferErrFmt, err)
+		}
+	}
+
+	go func() {
+		<-stop
+
+		conn.Close() //nolint: errcheck
+	}()
+
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+
+				continue
+			}
+
+			p.dispatch(buf[:n], addr.String(), handler)
+		}
+	}()
+
+	return nil
+}
+
+// dispatch calls the given handler for the given datagram received from the
+// given source, unless the datagram is invalid or was sent by this peer.
+func (p *Peer) dispatch(datagram []byte, source string, handler func(route string, body []byte, source string)) {
+	route, sender, body, err := decodeDatagram(datagram)
+	if err != nil || sender == p.unicast.String() {
+		return
+	}
+
+	handler(route, body, source)
+}
+
+// Close closes the connection used for sending datagrams.
+func (p *Peer) Close() error {
+	return p.conn.Close() //nolint: wrapcheck
+}
+
+// setMulticastInterface sets the interface used to send datagrams to the given group.
+func setMulticastInterface(conn *net.UDPConn, group *net.UDPAddr, iface *net.Interface) error {
+	if group.IP.To4() != nil {
+		return ipv4.NewPacketConn(conn).SetMulticastInterface(iface) //nolint: wrapcheck
+	}
+
+	return ipv6.NewPacketConn(conn).SetMulticastInterface(iface) //nolint: wrapcheck
+}
+
+// encodeDatagram returns a datagram with the given route, sender and message.
+func encodeDatagram(msg []byte, route string, sender string) ([]byte, error) {
+	size := len(route) + 1 + len(sender) + 1 + len(msg)
+	if size > maxDatagramSize {
+		return nil, errTooLargeDatagram
+	}
+
+	datagram := make([]byte, 0, size)
+	datagram = append(datagram, route...)
+	datagram = append(datagram, routeSeparator)
+	datagram = append(datagram, sender...)
+	datagram = append(datagram, routeSeparator)
+	datagram = append(datagram, msg...)
+
+	return datagram, nil
+}
+
+// decodeDatagram returns the route, the sender and the message from given datagram.
+func decodeDatagram(datagram []byte) (string, string, []byte, error) {
+	i := bytes.IndexByte(datagram, routeSeparator)
+	if i <= 0 {
+		return "", "", nil, errInvalidDatagram
+	}
+
+	j := bytes.IndexByte(datagram[i+1:], routeSeparator)
+	if j < 0 {
+		return "", "", nil, errInvalidDatagram
+	}
+
+	j += i + 1
+
+	body := make([]byte, len(datagram)-j-1)
+	copy(body, datagram[j+1:])
+
+	return string(datagram[:i]), string(datagram[i+1 : j]), body, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2024 Robert Andrei STEFAN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multicast
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakePeer struct {
+	sent []string
+}
+
+func (p *fakePeer) String() string {
+	return "localhost:19999"
+}
+
+func (p *fakePeer) Send(msg []byte, route string, peerToSend string) error {
+	p.sent = append(p.sent, route+" "+peerToSend+" "+string(msg))
+
+	return nil
+}
+
+var _ = Describe("Multicast Peer", func() {
+	Describe("NewPeer function", func() {
+		It("returns error when unicast peer is nil", func() {
+			_, err := NewPeer(Config{Group: "239.0.0.1:9999"})
+			Expect(err).To(MatchError(errNilUnicastPeer))
+		})
+
+		It("returns error when group is not a multicast address", func() {
+			_, err := NewPeer(Config{Unicast: &fakePeer{}, Group: "127.0.0.1:9999"})
+			Expect(err).To(MatchError(errNotMulticastGroup))
+		})
+
+		It("sends unicast messages with the unicast peer", func() {
+			unicast := &fakePeer{}
+
+			p, err := NewPeer(Config{Unicast: unicast, Group: "239.0.0.1:9999"})
+			Expect(err).ToNot(HaveOccurred())
+
+			defer p.Close() //nolint: errcheck
+
+			Expect(p.String()).To(Equal("localhost:19999"))
+			Expect(p.Send([]byte("msg"), "/route", "localhost:29999")).To(Succeed())
+			Expect(unicast.sent).To(Equal([]string{"/route localhost:29999 msg"}))
+		})
+
+		It("creates a peer that sends datagrams on the given interface", func() {
+			p, err := NewPeer(Config{Unicast: &fakePeer{}, Group: "239.0.0.1:9999", Interface: "lo"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.iface.Name).To(Equal("lo"))
+			Expect(p.Close()).To(Succeed())
+		})
+
+		It("returns error when interface doesn't exist", func() {
+			_, err := NewPeer(Config{Unicast: &fakePeer{}, Group: "239.0.0.1:9999", Interface: "unknown0"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("dispatch function", func() {
+		var (
+			p       *Peer
+			routes  []string
+			sources []string
+		)
+
+		handler := func(route string, _ []byte, source string) {
+			routes = append(routes, route)
+			sources = append(sources, source)
+		}
+
+		BeforeEach(func() {
+			var err error
+
+			routes = nil
+			sources = nil
+
+			p, err = NewPeer(Config{Unicast: &fakePeer{}, Group: "239.0.0.1:9999"})
+			Expect(err).ToNot(HaveOccurred())
+
+			DeferCleanup(p.Close)
+		})
+
+		It("calls the handler for datagrams sent by other members", func() {
+			datagram, err := encodeDatagram([]byte("msg"), "/synchronization", "localhost:29999")
+			Expect(err).ToNot(HaveOccurred())
+
+			p.dispatch(datagram, "10.0.0.2:41234", handler)
+			Expect(routes).To(Equal([]string{"/synchronization"}))
+			Expect(sources).To(Equal([]string{"10.0.0.2:41234"}))
+		})
+
+		It("ignores datagrams sent by itself", func() {
+			datagram, err := encodeDatagram([]byte("msg"), "/synchronization", "localhost:19999")
+			Expect(err).ToNot(HaveOccurred())
+
+			p.dispatch(datagram, "10.0.0.1:41234", handler)
+			Expect(routes).To(BeEmpty())
+		})
+
+		It("ignores invalid datagrams", func() {
+			p.dispatch([]byte("/synchronization\nmsg"), "10.0.0.2:41234", handler)
+			Expect(routes).To(BeEmpty())
+		})
+	})
+
+	Describe("encodeDatagram & decodeDatagram functions", func() {
+		It("decodes an encoded datagram", func() {
+			datagram, err := encodeDatagram([]byte(`{"host":"localhost:19999"}`), "/synchronization", "localhost:19999")
+			Expect(err).ToNot(HaveOccurred())
+
+			route, sender, body, err := decodeDatagram(datagram)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(route).To(Equal("/synchronization"))
+			Expect(sender).To(Equal("localhost:19999"))
+			Expect(body).To(Equal([]byte(`{"host":"localhost:19999"}`)))
+		})
+
+		It("returns error when message is too large", func() {
+			_, err := encodeDatagram(bytes.Repeat([]byte("a"), maxDatagramSize), "/synchronization", "localhost:19999")
+			Expect(err).To(MatchError(errTooLargeDatagram))
+		})
+
+		It("returns error when datagram has no route or sender", func() {
+			_, _, _, err := decodeDatagram([]byte("\nlocalhost:19999\nmessage"))
+			Expect(err).To(MatchError(errInvalidDatagram))
+
+			_, _, _, err = decodeDatagram([]byte("/synchronization\nmessage"))
+			Expect(err).To(MatchError(errInvalidDatagram))
+
+			_, _, _, err = decodeDatagram([]byte("message"))
+			Expect(err).To(MatchError(errInvalidDatagram))
+		})
+	})
+})